@@ -35,6 +35,7 @@ import (
 	"time"
 
 	"github.com/percona/go-mysql/log"
+	"github.com/percona/go-mysql/query"
 )
 
 // A Result contains a global class and per-ID classes with finalized metric
@@ -52,6 +53,11 @@ type Aggregator struct {
 	samples     bool
 	utcOffset   time.Duration
 	outlierTime float64
+	groupBy     []string // label keys
+	describe    bool
+	redact      *query.RedactOptions
+	analyze     *query.AnalyzeOptions
+	accuracy    float64
 	// --
 	global    *Class
 	classes   map[string]*Class
@@ -72,6 +78,46 @@ func NewAggregator(samples bool, utcOffset time.Duration, outlierTime float64) *
 	return a
 }
 
+// GroupByLabels makes the aggregator group events by the values of the label
+// keys in addition to the class ID and other dimensions, like Prometheus labels.
+// For example, grouping by "app" yields one class per query and application,
+// and grouping by "controller" and "action" labels from query comments, see
+// log.Options.CommentLabels, yields one class per query and endpoint.
+// The grouped label values are saved in Class.GroupLabels. Events without a
+// label are grouped as if the label was empty. Call this function before adding
+// events.
+func (a *Aggregator) GroupByLabels(keys ...string) {
+	a.groupBy = keys
+}
+
+// DescribeStatements makes the aggregator save the statement type and tables
+// of each class in Class.Statement. The statement is described from the query
+// of the first event in the class. Call this function before adding events.
+func (a *Aggregator) DescribeStatements() {
+	a.describe = true
+}
+
+// RedactExamples makes the aggregator redact example queries, see
+// Class.RedactExamples. Call this function before adding events.
+func (a *Aggregator) RedactExamples(opt query.RedactOptions) {
+	a.redact = &opt
+}
+
+// AnalyzeQueries makes the aggregator save the anti-patterns in the queries of
+// each class, see Class.AnalyzeQueries. Call this function before adding
+// events.
+func (a *Aggregator) AnalyzeQueries(opt query.AnalyzeOptions) {
+	a.analyze = &opt
+}
+
+// RelativeAccuracy sets the relative accuracy of metric quantiles, like P99,
+// see Metrics.SetRelativeAccuracy. The default is DefaultRelativeAccuracy.
+// Call this function before adding events.
+func (a *Aggregator) RelativeAccuracy(relativeAccuracy float64) {
+	a.accuracy = relativeAccuracy
+	a.global.Metrics.SetRelativeAccuracy(relativeAccuracy)
+}
+
 // AddEvent adds the event to the aggregator, automatically creating new classes
 // as needed.
 func (a *Aggregator) AddEvent(event *log.Event, id, user, host, db, server, fingerprint string) {
@@ -88,14 +134,49 @@ func (a *Aggregator) AddEvent(event *log.Event, id, user, host, db, server, fing
 
 	// Group events by all dimentions.
 	ident := fmt.Sprintf("%s;%s;%s;%s;%s", id, user, host, db, server)
+	var groupLabels log.Labels
+	if len(a.groupBy) > 0 {
+		groupLabels = eventLabels(event).Select(a.groupBy...)
+		ident += ";" + groupLabels.String()
+	}
 	class, ok := a.classes[ident]
 	if !ok {
 		class = NewClass(id, user, host, db, server, fingerprint, a.samples)
+		class.GroupLabels = groupLabels
+		if a.accuracy != 0 {
+			class.Metrics.SetRelativeAccuracy(a.accuracy)
+		}
+		if a.describe {
+			s := query.Describe(event.Query)
+			class.Statement = &s
+		}
+		if a.redact != nil {
+			class.RedactExamples(*a.redact)
+		}
+		if a.analyze != nil {
+			class.AnalyzeQueries(*a.analyze)
+		}
 		a.classes[ident] = class
 	}
 	class.AddEvent(event, outlier)
 }
 
+// ClassesByTable returns the classes that read or write each table, keyed on
+// table name like db.t. Classes without Statement, see Aggregator.DescribeStatements,
+// are not returned. A class can be returned for several tables.
+func (r Result) ClassesByTable() map[string][]*Class {
+	tables := map[string][]*Class{}
+	for _, class := range r.Class {
+		if class.Statement == nil {
+			continue
+		}
+		for _, t := range class.Statement.Tables() {
+			tables[t.String()] = append(tables[t.String()], class)
+		}
+	}
+	return tables
+}
+
 // Finalize calculates all metric statistics and returns a Result.
 // Call this function when done adding events to the aggregator.
 func (a *Aggregator) Finalize() Result {
@@ -42,6 +42,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/percona/go-mysql/event"
 	"github.com/percona/go-mysql/log"
@@ -91,18 +92,22 @@ func aggregateSlowLog(input, output string, utcOffset time.Duration, examples bo
 }
 
 func zeroPercentiles(r *event.Result) {
-	for _, metrics := range r.Global.Metrics.TimeMetrics {
-		metrics.P99 = event.Float64(0)
-	}
-	for _, metrics := range r.Global.Metrics.NumberMetrics {
-		metrics.P99 = event.Uint64(0)
-	}
+	classes := []*event.Class{r.Global}
 	for _, class := range r.Class {
+		classes = append(classes, class)
+	}
+	for _, class := range classes {
 		for _, metrics := range class.Metrics.TimeMetrics {
+			metrics.P50 = event.Float64(0)
+			metrics.P95 = event.Float64(0)
 			metrics.P99 = event.Float64(0)
+			metrics.P999 = event.Float64(0)
 		}
 		for _, metrics := range class.Metrics.NumberMetrics {
+			metrics.P50 = event.Uint64(0)
+			metrics.P95 = event.Uint64(0)
 			metrics.P99 = event.Uint64(0)
+			metrics.P999 = event.Uint64(0)
 		}
 	}
 }
@@ -224,3 +229,255 @@ func TestSlow026(t *testing.T) {
 	got, expect := aggregateSlowLog("slow027.log", "slow027.golden", 0, true)
 	assert.JSONEq(t, expect, got)
 }
+
+func TestLabels(t *testing.T) {
+	events := []log.Labels{
+		log.LabelsFromPairs("app", "shop", "controller", "orders"),
+		log.LabelsFromPairs("controller", "orders", "app", "shop"),
+		log.LabelsFromPairs("app", "shop", "controller", "users"),
+		log.LabelsFromPairs("app", "blog"),
+		nil,
+	}
+	newEvent := func(labels log.Labels) *log.Event {
+		e := log.NewEvent()
+		e.Query = "select 1"
+		e.TimeMetrics["Query_time"] = 1
+		e.Labels = labels
+		return e
+	}
+
+	// Label sets are deduplicated.
+	a := event.NewAggregator(false, 0, 0)
+	for _, labels := range events {
+		a.AddEvent(newEvent(labels), "ID", "", "", "", "", "select ?")
+	}
+	res := a.Finalize()
+	require.Len(t, res.Class, 1)
+	class := res.Class["ID;;;;"]
+	assert.Nil(t, class.GroupLabels)
+	assert.Equal(t, []log.Labels{events[0], events[2], events[3]}, class.Labels)
+	assert.Equal(t, []log.Labels{events[0], events[2], events[3]}, res.Global.Labels)
+
+	// Group by label values.
+	a = event.NewAggregator(false, 0, 0)
+	a.GroupByLabels("app")
+	for _, labels := range events {
+		a.AddEvent(newEvent(labels), "ID", "", "", "", "", "select ?")
+	}
+	res = a.Finalize()
+	require.Len(t, res.Class, 3)
+	shop := res.Class[`ID;;;;;{app="shop"}`]
+	require.NotNil(t, shop)
+	assert.Equal(t, log.LabelsFromPairs("app", "shop"), shop.GroupLabels)
+	assert.Equal(t, uint(3), shop.TotalQueries)
+	assert.Equal(t, []log.Labels{events[0], events[2]}, shop.Labels)
+	blog := res.Class[`ID;;;;;{app="blog"}`]
+	require.NotNil(t, blog)
+	assert.Equal(t, uint(1), blog.TotalQueries)
+	none := res.Class[`ID;;;;;{}`]
+	require.NotNil(t, none)
+	assert.Equal(t, uint(1), none.TotalQueries)
+	assert.Empty(t, none.Labels)
+
+	// Label keys are quoted in class IDs, so different label sets do not
+	// collide.
+	a = event.NewAggregator(false, 0, 0)
+	a.GroupByLabels("k1", "k2", `k1="v", k2`)
+	a.AddEvent(newEvent(log.LabelsFromPairs("k1", "v", "k2", "w")), "ID", "", "", "", "", "select ?")
+	a.AddEvent(newEvent(log.LabelsFromPairs(`k1="v", k2`, "w")), "ID", "", "", "", "", "select ?")
+	assert.Len(t, a.Finalize().Class, 2)
+
+	// AddClass merges label sets.
+	global := event.NewClass("", "", "", "", "", "", false)
+	global.AddClass(shop)
+	global.AddClass(blog)
+	global.AddClass(shop)
+	assert.Equal(t, []log.Labels{events[0], events[2], events[3]}, global.Labels)
+
+	// Deprecated fields are filled from Labels, and are used if an event has
+	// no Labels.
+	assert.Equal(t, []string{"app", "controller", "app", "controller", "app"}, global.LabelsKey)
+	assert.Equal(t, []string{"shop", "orders", "shop", "users", "blog"}, global.LabelsValue)
+	e := newEvent(nil)
+	e.LabelsKey = []string{"app"}
+	e.LabelsValue = []string{"shop"}
+	a = event.NewAggregator(false, 0, 0)
+	a.GroupByLabels("app")
+	a.AddEvent(e, "ID", "", "", "", "", "select ?")
+	class = a.Finalize().Class[`ID;;;;;{app="shop"}`]
+	require.NotNil(t, class)
+	assert.Equal(t, []log.Labels{log.LabelsFromPairs("app", "shop")}, class.Labels)
+}
+
+func TestDescribeStatements(t *testing.T) {
+	queries := []string{
+		"SELECT * FROM db.t1 JOIN t2 ON t1.id = t2.id",
+		"UPDATE t2 SET a = 1",
+	}
+	a := event.NewAggregator(false, 0, 0)
+	a.DescribeStatements()
+	for _, q := range queries {
+		e := log.NewEvent()
+		e.Query = q
+		e.TimeMetrics["Query_time"] = 1
+		f := query.Fingerprint(q)
+		a.AddEvent(e, query.Id(f), "", "", "", "", f)
+	}
+	res := a.Finalize()
+	require.Len(t, res.Class, 2)
+
+	sel := res.Class[query.Id(query.Fingerprint(queries[0]))+";;;;"]
+	require.NotNil(t, sel)
+	assert.Equal(t, &query.Statement{
+		Type: query.StatementSelect,
+		Read: []query.Table{{Db: "db", Name: "t1"}, {Name: "t2"}},
+	}, sel.Statement)
+	upd := res.Class[query.Id(query.Fingerprint(queries[1]))+";;;;"]
+	require.NotNil(t, upd)
+	assert.Equal(t, query.StatementUpdate, upd.Statement.Type)
+
+	tables := res.ClassesByTable()
+	assert.Len(t, tables, 2)
+	assert.Equal(t, []*event.Class{sel}, tables["db.t1"])
+	assert.ElementsMatch(t, []*event.Class{sel, upd}, tables["t2"])
+}
+
+func TestRedactExamples(t *testing.T) {
+	q := "SELECT name FROM users WHERE email = 'bob@example.com' AND id IN (1, 2)"
+	f := query.Fingerprint(q)
+	id := query.Id(f)
+
+	a := event.NewAggregator(true, 0, 0)
+	a.RedactExamples(query.RedactOptions{})
+	e := log.NewEvent()
+	e.Query = q
+	e.TimeMetrics["Query_time"] = 1
+	a.AddEvent(e, id, "", "", "", "", f)
+	res := a.Finalize()
+	class := res.Class[id+";;;;"]
+	require.NotNil(t, class)
+	assert.Equal(t, &event.Example{
+		QueryTime: 1,
+		Query:     "SELECT name FROM users WHERE email = ?str AND id IN (?num, ?num)",
+		Size:      len(q),
+		Redacted:  true,
+	}, class.Example)
+
+	// The current example is redacted, too.
+	class = event.NewClass(id, "", "", "", "", f, true)
+	class.AddEvent(e, false)
+	assert.Equal(t, q, class.Example.Query)
+	class.RedactExamples(query.RedactOptions{})
+	assert.Equal(t, "SELECT name FROM users WHERE email = ?str AND id IN (?num, ?num)", class.Example.Query)
+	assert.True(t, class.Example.Redacted)
+}
+
+func TestAnalyzeQueries(t *testing.T) {
+	// The slowest query is the example, and its advice is saved.
+	queries := []string{
+		"SELECT * FROM users WHERE id = 1",
+		"SELECT * FROM users WHERE id = 2 ",
+	}
+	f := query.Fingerprint(queries[0])
+	id := query.Id(f)
+	for _, samples := range []bool{true, false} {
+		a := event.NewAggregator(samples, 0, 0)
+		a.AnalyzeQueries(query.AnalyzeOptions{})
+		for i, q := range queries {
+			e := log.NewEvent()
+			e.Query = q
+			e.TimeMetrics["Query_time"] = float64(i + 1)
+			a.AddEvent(e, id, "", "", "", "", f)
+		}
+		res := a.Finalize()
+		class := res.Class[id+";;;;"]
+		require.NotNil(t, class)
+		require.Len(t, class.Advice, 1)
+		assert.Equal(t, query.RuleSelectStar, class.Advice[0].Rule)
+		assert.Equal(t, 7, class.Advice[0].Start)
+	}
+
+	// No advice, no field.
+	a := event.NewAggregator(true, 0, 0)
+	a.AnalyzeQueries(query.AnalyzeOptions{})
+	e := log.NewEvent()
+	e.Query = "SELECT name FROM users WHERE id = 1"
+	e.TimeMetrics["Query_time"] = 1
+	a.AddEvent(e, "1", "", "", "", "", query.Fingerprint(e.Query))
+	res := a.Finalize()
+	assert.Nil(t, res.Class["1;;;;"].Advice)
+}
+
+func TestQuantiles(t *testing.T) {
+	newAggregator := func(relativeAccuracy float64) *event.Aggregator {
+		a := event.NewAggregator(false, 0, 0)
+		if relativeAccuracy != 0 {
+			a.RelativeAccuracy(relativeAccuracy)
+		}
+		for i := 1; i <= 1000; i++ {
+			e := log.NewEvent()
+			e.Query = "select 1"
+			e.TimeMetrics["Query_time"] = float64(i)
+			e.NumberMetrics["Rows_sent"] = uint64(i)
+			a.AddEvent(e, "ID", "", "", "", "", "select ?")
+		}
+		return a
+	}
+
+	for _, alpha := range []float64{0, 0.05} {
+		if alpha == 0 {
+			alpha = event.DefaultRelativeAccuracy
+		}
+		res := newAggregator(alpha).Finalize()
+		for _, class := range []*event.Class{res.Global, res.Class["ID;;;;"]} {
+			qt := class.Metrics.TimeMetrics["Query_time"]
+			assert.Equal(t, uint64(1000), qt.Cnt)
+			assert.InEpsilon(t, 500.0, *qt.P50, alpha)
+			assert.InEpsilon(t, 950.0, *qt.P95, alpha)
+			assert.InEpsilon(t, 990.0, *qt.P99, alpha)
+			assert.InEpsilon(t, 999.0, *qt.P999, alpha)
+			assert.Equal(t, 1.0, *qt.Min)
+			assert.Equal(t, 1000.0, *qt.Max)
+			rows := class.Metrics.NumberMetrics["Rows_sent"]
+			assert.InEpsilon(t, 990, *rows.P99, alpha)
+		}
+	}
+
+	// AddClass merges the quantiles of the classes.
+	a := newAggregator(0).Finalize().Class["ID;;;;"]
+	b := newAggregator(0).Finalize().Class["ID;;;;"]
+	global := event.NewClass("", "", "", "", "", "", false)
+	global.AddClass(a)
+	global.AddClass(b)
+	qt := global.Metrics.TimeMetrics["Query_time"]
+	assert.InEpsilon(t, 990.0, *qt.P99, event.DefaultRelativeAccuracy)
+	assert.InEpsilon(t, 990.0, *a.Metrics.TimeMetrics["Query_time"].P99, event.DefaultRelativeAccuracy)
+}
+
+// Stats without sketches, like stats decoded from JSON or built as literals,
+// can be finalized and added to.
+func TestFinalizeWithoutSketches(t *testing.T) {
+	m := event.NewMetrics()
+	m.TimeMetrics["Query_time"] = &event.TimeStats{Cnt: 2, Sum: 3, P99: event.Float64(2)}
+	m.NumberMetrics["Rows_sent"] = &event.NumberStats{Cnt: 2, Sum: 4}
+	m.BoolMetrics["QC_Hit"] = &event.BoolStats{Cnt: 2, Sum: 1}
+	require.NotPanics(t, func() { m.Finalize(1, 2) })
+	assert.Equal(t, uint64(2), m.TimeMetrics["Query_time"].Cnt)
+	assert.Equal(t, 2.0, *m.TimeMetrics["Query_time"].P99)
+	assert.Equal(t, uint64(2), m.NumberMetrics["Rows_sent"].Cnt)
+	assert.Equal(t, uint64(2), m.BoolMetrics["QC_Hit"].Cnt)
+
+	e := log.NewEvent()
+	e.TimeMetrics["Query_time"] = 1
+	e.NumberMetrics["Rows_sent"] = 1
+	require.NotPanics(t, func() { m.AddEvent(e, false) })
+	m.Finalize(1, 3)
+	assert.Equal(t, uint64(3), m.TimeMetrics["Query_time"].Cnt)
+	assert.Equal(t, uint64(3), m.NumberMetrics["Rows_sent"].Cnt)
+	assert.Equal(t, 0.0, m.TimeMetrics["Query_time"].Quantile(0.99))
+
+	var decoded event.Class
+	require.NoError(t, json.Unmarshal([]byte(`{"Metrics":{"TimeMetrics":{"Query_time":{"Cnt":1,"Sum":1}}}}`), &decoded))
+	require.NotPanics(t, func() { decoded.Metrics.Finalize(1, 1) })
+	assert.Equal(t, uint64(1), decoded.Metrics.TimeMetrics["Query_time"].Cnt)
+}
@@ -32,6 +32,7 @@ package event
 
 import (
 	"github.com/percona/go-mysql/log"
+	"github.com/percona/go-mysql/query"
 )
 
 const (
@@ -51,13 +52,17 @@ type Class struct {
 	Host                 string
 	Db                   string
 	Server               string
-	LabelsKey            []string
-	LabelsValue          []string
-	Fingerprint          string   // canonical form of query: values replaced with "?"
-	Metrics              *Metrics // statistics for each metric, e.g. max Query_time
-	TotalQueries         uint     // total number of queries in class
-	UniqueQueries        uint     // unique number of queries in class
-	Example              *Example `json:",omitempty"` // sample query with max Query_time
+	LabelsKey            []string         // Deprecated: Use Labels. Keys of the label sets in Labels, one set after another.
+	LabelsValue          []string         // Deprecated: Use Labels. Values of the label sets in Labels, in the order of LabelsKey.
+	GroupLabels          log.Labels       `json:",omitempty"` // label values the class is grouped by, see Aggregator.GroupByLabels
+	Labels               []log.Labels     // distinct label sets of events in class
+	Fingerprint          string           // canonical form of query: values replaced with "?"
+	Statement            *query.Statement `json:",omitempty"` // statement type and tables, see Aggregator.DescribeStatements
+	Metrics              *Metrics         // statistics for each metric, e.g. max Query_time
+	TotalQueries         uint             // total number of queries in class
+	UniqueQueries        uint             // unique number of queries in class
+	Example              *Example         `json:",omitempty"` // sample query with max Query_time
+	Advice               []query.Advice   `json:",omitempty"` // anti-patterns in example query, see Class.AnalyzeQueries
 	NumQueriesWithErrors float32
 	ErrorsCode           []uint64
 	ErrorsCount          []uint64
@@ -65,7 +70,10 @@ type Class struct {
 	outliers  uint
 	lastDb    string
 	errorsMap map[uint64]uint64 // ErrorsCode: ErrorsCount
+	labelsMap map[uint64][]int  // label set hash: indexes in Labels
 	sample    bool
+	redact    *query.RedactOptions
+	analyze   *query.AnalyzeOptions
 }
 
 // A Example is a real query and its database, timestamp, and Query_time.
@@ -77,6 +85,7 @@ type Example struct {
 	Query     string  // truncated to MaxExampleBytes
 	Size      int     `json:",omitempty"` // Original size of query.
 	Ts        string  `json:",omitempty"` // in MySQL time zone
+	Redacted  bool    `json:",omitempty"` // Query is redacted, see Class.RedactExamples
 }
 
 // NewClass returns a new Class for the class ID and fingerprint.
@@ -90,16 +99,53 @@ func NewClass(id, user, host, db, server, fingerprint string, sample bool) *Clas
 		Server:       server,
 		LabelsKey:    []string{},
 		LabelsValue:  []string{},
+		Labels:       []log.Labels{},
 		Fingerprint:  fingerprint,
 		Metrics:      NewMetrics(),
 		TotalQueries: 0,
 		Example:      &Example{},
 		sample:       sample,
 		errorsMap:    map[uint64]uint64{},
+		labelsMap:    map[uint64][]int{},
 	}
 	return class
 }
 
+// RedactExamples makes the class save example queries redacted with
+// query.Redact, so they can be shared without the values in the original
+// queries. The current example, if any, is redacted, too. The original size
+// of the query is kept in Example.Size.
+func (c *Class) RedactExamples(opt query.RedactOptions) {
+	c.redact = &opt
+	if c.Example != nil && c.Example.Query != "" && !c.Example.Redacted {
+		c.setExampleQuery(c.Example.Query)
+	}
+}
+
+// AnalyzeQueries makes the class save the anti-patterns that query.Analyze
+// finds in the example query in Advice. Their offsets are in the example
+// query before it is redacted or truncated. If the class does not save
+// examples, the query of the first event is analyzed.
+func (c *Class) AnalyzeQueries(opt query.AnalyzeOptions) {
+	c.analyze = &opt
+}
+
+// setExampleQuery sets the example query, redacted if enabled, and truncated
+// to MaxExampleBytes.
+func (c *Class) setExampleQuery(q string) {
+	if c.analyze != nil {
+		c.Advice = query.Analyze(q, *c.analyze)
+	}
+	if c.redact != nil {
+		q = query.Redact(q, *c.redact)
+		c.Example.Redacted = true
+	}
+	if len(q) > MaxExampleBytes {
+		q = q[0:MaxExampleBytes-len(TruncatedExampleSuffix)] + TruncatedExampleSuffix
+	}
+	c.Example.Query = q
+}
+
 // AddEvent adds an event to the query class.
 func (c *Class) AddEvent(e *log.Event, outlier bool) {
 	if outlier {
@@ -110,9 +156,7 @@ func (c *Class) AddEvent(e *log.Event, outlier bool) {
 
 	c.Metrics.AddEvent(e, outlier)
 
-	// Add labels
-	c.LabelsKey = append(c.LabelsKey, e.LabelsKey...)
-	c.LabelsValue = append(c.LabelsValue, e.LabelsValue...)
+	c.addLabels(eventLabels(e))
 
 	// Add Errors
 	if lastErrno, ok := e.NumberMetrics["Last_errno"]; ok && lastErrno > 0 {
@@ -125,6 +169,9 @@ func (c *Class) AddEvent(e *log.Event, outlier bool) {
 	if e.Db != "" {
 		c.lastDb = e.Db
 	}
+	if c.analyze != nil && !c.sample && c.TotalQueries+c.outliers == 1 {
+		c.Advice = query.Analyze(e.Query, *c.analyze)
+	}
 	if c.sample {
 		if n, ok := e.TimeMetrics["Query_time"]; ok {
 			if float64(n) > c.Example.QueryTime {
@@ -135,11 +182,7 @@ func (c *Class) AddEvent(e *log.Event, outlier bool) {
 				} else {
 					c.Example.Db = c.lastDb
 				}
-				if len(e.Query) > MaxExampleBytes {
-					c.Example.Query = e.Query[0:MaxExampleBytes-len(TruncatedExampleSuffix)] + TruncatedExampleSuffix
-				} else {
-					c.Example.Query = e.Query
-				}
+				c.setExampleQuery(e.Query)
 				if !e.Ts.IsZero() {
 					// todo use time.RFC3339Nano instead
 					c.Example.Ts = e.Ts.UTC().Format("2006-01-02 15:04:05")
@@ -155,11 +198,22 @@ func (c *Class) AddClass(newClass *Class) {
 	c.UniqueQueries++
 	c.TotalQueries += newClass.TotalQueries
 	c.Example = nil
+	if c.Statement == nil {
+		c.Statement = newClass.Statement
+	}
+	if c.Advice == nil {
+		c.Advice = newClass.Advice
+	}
+
+	for _, ls := range newClass.Labels {
+		c.addLabels(ls)
+	}
 
 	for newMetric, newStats := range newClass.Metrics.TimeMetrics {
 		stats, ok := c.Metrics.TimeMetrics[newMetric]
 		if !ok {
 			m := *newStats
+			m.sketch = newStats.sketch.clone()
 			c.Metrics.TimeMetrics[newMetric] = &m
 		} else {
 			stats.Cnt++
@@ -170,6 +224,8 @@ func (c *Class) AddClass(newClass *Class) {
 			if Float64Value(newStats.Max) > Float64Value(stats.Max) || stats.Max == nil {
 				stats.Max = newStats.Max
 			}
+			stats.sketch = mergeSketches(stats.sketch, newStats.sketch)
+			stats.setQuantiles()
 		}
 	}
 
@@ -177,6 +233,7 @@ func (c *Class) AddClass(newClass *Class) {
 		stats, ok := c.Metrics.NumberMetrics[newMetric]
 		if !ok {
 			m := *newStats
+			m.sketch = newStats.sketch.clone()
 			c.Metrics.NumberMetrics[newMetric] = &m
 		} else {
 			stats.Cnt++
@@ -187,6 +244,8 @@ func (c *Class) AddClass(newClass *Class) {
 			if Uint64Value(newStats.Max) > Uint64Value(stats.Max) || stats.Max == nil {
 				stats.Max = newStats.Max
 			}
+			stats.sketch = mergeSketches(stats.sketch, newStats.sketch)
+			stats.setQuantiles()
 		}
 	}
 
@@ -202,6 +261,57 @@ func (c *Class) AddClass(newClass *Class) {
 	}
 }
 
+// mergeSketches returns the sketch of the values of a and b, or nil if either
+// is nil, like for stats decoded from JSON, because then the values of one
+// are not known.
+func mergeSketches(a, b *Sketch) *Sketch {
+	if a == nil || b == nil {
+		return nil
+	}
+	a.Merge(b)
+	return a
+}
+
+// eventLabels returns the labels of the event, which are in the deprecated
+// LabelsKey and LabelsValue if the event has no Labels.
+func eventLabels(e *log.Event) log.Labels {
+	if len(e.Labels) > 0 {
+		return e.Labels
+	}
+	var ls log.Labels
+	for i, k := range e.LabelsKey {
+		if i < len(e.LabelsValue) {
+			ls = ls.Set(k, e.LabelsValue[i])
+		}
+	}
+	return ls
+}
+
+// addLabels adds the label set to Labels unless the class already has it.
+func (c *Class) addLabels(ls log.Labels) {
+	if len(ls) == 0 {
+		return
+	}
+	if c.labelsMap == nil {
+		// Class was not created by NewClass, e.g. decoded from JSON.
+		c.labelsMap = map[uint64][]int{}
+		for i, l := range c.Labels {
+			h := l.Hash()
+			c.labelsMap[h] = append(c.labelsMap[h], i)
+		}
+	}
+	h := ls.Hash()
+	for _, i := range c.labelsMap[h] {
+		if c.Labels[i].Equal(ls) {
+			return
+		}
+	}
+	c.labelsMap[h] = append(c.labelsMap[h], len(c.Labels))
+	c.Labels = append(c.Labels, ls)
+	c.LabelsKey = append(c.LabelsKey, ls.Keys()...)
+	c.LabelsValue = append(c.LabelsValue, ls.Values()...)
+}
+
 // Finalize calculates all metric statistics. Call this function when done
 // adding events to the class.
 func (c *Class) Finalize(rateLimit uint) {
@@ -50,4 +50,7 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 // used, for example, to aggregate unique queries in a log file, then sort the
 // classes by some metric, like max Query_time, to find the slowest query
 // relative to a global class for the same set of events.
+//
+// An Aggregator yields one Result for all events. A WindowAggregator yields a
+// Result for each time window, like each minute, for trends.
 package event
@@ -31,43 +31,53 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package event
 
 import (
-	"sort"
+	"math"
 
 	"github.com/percona/go-mysql/log"
 )
 
 // Metrics encapsulate the metrics of an event like Query_time and Rows_sent.
+// Quantiles are estimated with a Sketch per metric, so memory does not grow
+// with the number of events.
 type Metrics struct {
 	TimeMetrics   map[string]*TimeStats   `json:",omitempty"`
 	NumberMetrics map[string]*NumberStats `json:",omitempty"`
 	BoolMetrics   map[string]*BoolStats   `json:",omitempty"`
+	// --
+	accuracy float64 // relative accuracy of sketches
 }
 
 // TimeStats are microsecond-based metrics like Query_time and Lock_time.
 type TimeStats struct {
-	vals       []float64 `json:"-"`
+	sketch     *Sketch `json:"-"`
 	Cnt        uint64
 	Sum        float64
 	Min        *float64 `json:",omitempty"`
+	P50        *float64 `json:",omitempty"` // median
+	P95        *float64 `json:",omitempty"` // 95th percentile
 	P99        *float64 `json:",omitempty"` // 99th percentile
+	P999       *float64 `json:",omitempty"` // 99.9th percentile
 	Max        *float64 `json:",omitempty"`
 	outlierSum float64
 }
 
 // NumberStats are integer-based metrics like Rows_sent and Merge_passes.
 type NumberStats struct {
-	vals       []uint64 `json:"-"`
+	sketch     *Sketch `json:"-"`
 	Cnt        uint64
 	Sum        uint64
 	Min        *uint64 `json:",omitempty"`
+	P50        *uint64 `json:",omitempty"` // median
+	P95        *uint64 `json:",omitempty"` // 95th percentile
 	P99        *uint64 `json:",omitempty"` // 99th percentile
+	P999       *uint64 `json:",omitempty"` // 99.9th percentile
 	Max        *uint64 `json:",omitempty"`
 	outlierSum uint64
 }
 
 // BoolStats are boolean-based metrics like QC_Hit and Filesort.
 type BoolStats struct {
-	vals       []bool `json:"-"`
+	added      uint64 // number of values added, set as Cnt by Finalize
 	Cnt        uint64
 	Sum        uint64 // %true = Sum/Cnt
 	outlierSum uint64
@@ -79,17 +89,25 @@ func NewMetrics() *Metrics {
 		TimeMetrics:   make(map[string]*TimeStats),
 		NumberMetrics: make(map[string]*NumberStats),
 		BoolMetrics:   make(map[string]*BoolStats),
+		accuracy:      DefaultRelativeAccuracy,
 	}
 	return m
 }
 
+// SetRelativeAccuracy sets the relative accuracy of the quantiles of metrics
+// that are added after, like 0.01 for 1%, see NewSketch. More accurate
+// quantiles use more memory.
+func (m *Metrics) SetRelativeAccuracy(relativeAccuracy float64) {
+	m.accuracy = relativeAccuracy
+}
+
 // AddEvent saves all the metrics of the event.
 func (m *Metrics) AddEvent(e *log.Event, outlier bool) {
 	for metric, val := range e.TimeMetrics {
 		stats, seenMetric := m.TimeMetrics[metric]
 		if !seenMetric {
 			m.TimeMetrics[metric] = &TimeStats{
-				vals: []float64{},
+				sketch: NewSketch(m.accuracy),
 			}
 			stats = m.TimeMetrics[metric]
 		}
@@ -98,14 +116,14 @@ func (m *Metrics) AddEvent(e *log.Event, outlier bool) {
 		} else {
 			stats.Sum += val
 		}
-		stats.vals = append(stats.vals, float64(val))
+		stats.add(val)
 	}
 
 	for metric, val := range e.NumberMetrics {
 		stats, seenMetric := m.NumberMetrics[metric]
 		if !seenMetric {
 			m.NumberMetrics[metric] = &NumberStats{
-				vals: []uint64{},
+				sketch: NewSketch(m.accuracy),
 			}
 			stats = m.NumberMetrics[metric]
 		}
@@ -114,7 +132,7 @@ func (m *Metrics) AddEvent(e *log.Event, outlier bool) {
 		} else {
 			stats.Sum += val
 		}
-		stats.vals = append(stats.vals, val)
+		stats.add(val)
 	}
 
 	for metric, val := range e.BoolMetrics {
@@ -130,50 +148,112 @@ func (m *Metrics) AddEvent(e *log.Event, outlier bool) {
 				stats.Sum += 1
 			}
 		}
-		stats.vals = append(stats.vals, val)
+		stats.added++
+	}
+}
+
+// add adds the value to the sketch, and to Min and Max. Stats without a
+// sketch, like stats decoded from JSON, count the value in Cnt.
+func (s *TimeStats) add(v float64) {
+	if s.sketch != nil {
+		s.sketch.Add(v)
+	} else {
+		s.Cnt++
+	}
+	if s.Min == nil || v < *s.Min {
+		s.Min = Float64(v)
+	}
+	if s.Max == nil || v > *s.Max {
+		s.Max = Float64(v)
+	}
+}
+
+// add adds the value to the sketch, and to Min and Max. Stats without a
+// sketch, like stats decoded from JSON, count the value in Cnt.
+func (s *NumberStats) add(v uint64) {
+	if s.sketch != nil {
+		s.sketch.Add(float64(v))
+	} else {
+		s.Cnt++
+	}
+	if s.Min == nil || v < *s.Min {
+		s.Min = Uint64(v)
+	}
+	if s.Max == nil || v > *s.Max {
+		s.Max = Uint64(v)
+	}
+}
+
+// Quantile returns the value at quantile q, like 0.99 for P99, within the
+// relative accuracy of the metrics, see Sketch.Quantile. It returns 0 if the
+// stats have no sketch, like stats decoded from JSON.
+func (s *TimeStats) Quantile(q float64) float64 {
+	if s.sketch == nil {
+		return 0
 	}
+	return s.sketch.Quantile(q)
 }
 
-type byUint64 []uint64
+// Quantile returns the value at quantile q, like 0.99 for P99, within the
+// relative accuracy of the metrics and rounded, see Sketch.Quantile. It
+// returns 0 if the stats have no sketch, like stats decoded from JSON.
+func (s *NumberStats) Quantile(q float64) uint64 {
+	if s.sketch == nil {
+		return 0
+	}
+	return uint64(math.Round(s.sketch.Quantile(q)))
+}
 
-func (a byUint64) Len() int      { return len(a) }
-func (a byUint64) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
-func (a byUint64) Less(i, j int) bool {
-	return a[i] < a[j] // ascending order
+// setQuantiles sets the percentiles from the sketch, if any.
+func (s *TimeStats) setQuantiles() {
+	if s.sketch == nil || s.sketch.Count() == 0 {
+		return
+	}
+	s.P50 = Float64(s.Quantile(0.5))
+	s.P95 = Float64(s.Quantile(0.95))
+	s.P99 = Float64(s.Quantile(0.99))
+	s.P999 = Float64(s.Quantile(0.999))
+}
+
+// setQuantiles sets the percentiles from the sketch, if any.
+func (s *NumberStats) setQuantiles() {
+	if s.sketch == nil || s.sketch.Count() == 0 {
+		return
+	}
+	s.P50 = Uint64(s.Quantile(0.5))
+	s.P95 = Uint64(s.Quantile(0.95))
+	s.P99 = Uint64(s.Quantile(0.99))
+	s.P999 = Uint64(s.Quantile(0.999))
 }
 
 // Finalize calculates the statistics of the added metrics. Call this function
-// when done adding events.
+// when done adding events. Stats without a sketch, like stats decoded from
+// JSON or built as literals, keep their Cnt and percentiles.
 func (m *Metrics) Finalize(rateLimit uint, totalQueries uint) {
 	if rateLimit == 0 {
 		rateLimit = 1
 	}
 
 	for _, s := range m.TimeMetrics {
-		sort.Float64s(s.vals)
-		cnt := len(s.vals)
-
-		s.Cnt = uint64(cnt)
-		s.Min = Float64(s.vals[0])
-		s.P99 = Float64(s.vals[(99*cnt)/100])
-		s.Max = Float64(s.vals[cnt-1])
+		if s.sketch != nil {
+			s.Cnt = s.sketch.Count()
+		}
+		s.setQuantiles()
 		s.Sum = (s.Sum * float64(rateLimit)) + s.outlierSum
 	}
 
 	for _, s := range m.NumberMetrics {
-		sort.Sort(byUint64(s.vals))
-		cnt := len(s.vals)
-
-		s.Cnt = uint64(cnt)
-		s.Min = Uint64(s.vals[0])
-		s.P99 = Uint64(s.vals[(99*cnt)/100])
-		s.Max = Uint64(s.vals[cnt-1])
+		if s.sketch != nil {
+			s.Cnt = s.sketch.Count()
+		}
+		s.setQuantiles()
 		s.Sum = (s.Sum * uint64(rateLimit)) + s.outlierSum
 	}
 
 	for _, s := range m.BoolMetrics {
-		cnt := len(s.vals)
-		s.Cnt = uint64(cnt)
+		if s.added > 0 {
+			s.Cnt = s.added
+		}
 		s.Sum = (s.Sum * uint64(rateLimit)) + s.outlierSum
 	}
 }
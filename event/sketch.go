@@ -0,0 +1,217 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package event
+
+import (
+	"math"
+)
+
+const (
+	// DefaultRelativeAccuracy is the relative accuracy of metric quantiles:
+	// a quantile is within 1% of the true value.
+	DefaultRelativeAccuracy = 0.01
+
+	// SketchMaxBins is the maximum number of bins of a Sketch. With the
+	// default accuracy, it covers values from 1 microsecond to more than a
+	// year without collapsing bins.
+	SketchMaxBins = 2048
+
+	// minIndexable is the smallest value that is not counted as zero.
+	minIndexable = 1e-9
+)
+
+// A Sketch is a DDSketch: a mergeable quantile sketch with relative accuracy.
+// Values are counted in bins whose bounds grow exponentially, so a quantile
+// is within the relative accuracy of the true value, and memory depends on
+// the range of the values, not their number. If the range needs more than
+// SketchMaxBins, the lowest bins are collapsed, so low quantiles lose
+// accuracy first. Min and Max are exact. Values are not negative; values
+// smaller than 1e-9 are counted as zero.
+//
+// See https://arxiv.org/abs/1908.10693.
+type Sketch struct {
+	gamma    float64
+	logGamma float64
+	bins     []uint64 // counts of bins offset, offset+1, ...
+	offset   int      // index of bins[0]
+	zero     uint64   // count of values smaller than minIndexable
+	count    uint64
+	min      float64
+	max      float64
+}
+
+// NewSketch returns a sketch with the relative accuracy, like 0.01 for 1%.
+// If relativeAccuracy is not between 0 and 1, DefaultRelativeAccuracy is used.
+func NewSketch(relativeAccuracy float64) *Sketch {
+	if relativeAccuracy <= 0 || relativeAccuracy >= 1 {
+		relativeAccuracy = DefaultRelativeAccuracy
+	}
+	gamma := (1 + relativeAccuracy) / (1 - relativeAccuracy)
+	return &Sketch{gamma: gamma, logGamma: math.Log(gamma)}
+}
+
+// Add adds the value to the sketch.
+func (s *Sketch) Add(v float64) {
+	if v < 0 || math.IsNaN(v) {
+		v = 0
+	}
+	s.addStats(v, v, 1)
+	if v < minIndexable {
+		s.zero++
+		return
+	}
+	s.addBin(int(math.Ceil(math.Log(v)/s.logGamma)), 1)
+}
+
+// Merge adds the values of o to the sketch. The relative accuracy of the
+// sketch does not change; if o has another accuracy, its values are
+// approximated by the values of its bins.
+func (s *Sketch) Merge(o *Sketch) {
+	if o == nil || o.count == 0 {
+		return
+	}
+	s.addStats(o.min, o.max, o.count)
+	s.zero += o.zero
+	for i, n := range o.bins {
+		if n == 0 {
+			continue
+		}
+		k := o.offset + i
+		if o.gamma != s.gamma {
+			k = int(math.Ceil(math.Log(o.value(k)) / s.logGamma))
+		}
+		s.addBin(k, n)
+	}
+}
+
+// Count returns the number of values added.
+func (s *Sketch) Count() uint64 {
+	return s.count
+}
+
+// Quantile returns the value at quantile q, like 0.99 for the 99th percentile,
+// within the relative accuracy: the value at index q*Count() of the sorted
+// values, like Min for 0 and Max for 1. It returns 0 if the sketch is empty.
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return s.min
+	}
+	rank := uint64(q * float64(s.count))
+	if rank >= s.count {
+		return s.max
+	}
+	if rank < s.zero {
+		return s.min
+	}
+	seen := s.zero
+	v := s.max
+	for i, n := range s.bins {
+		seen += n
+		if seen > rank {
+			v = s.value(s.offset + i)
+			break
+		}
+	}
+	return math.Min(math.Max(v, s.min), s.max)
+}
+
+// clone returns a copy of the sketch, or nil if s is nil.
+func (s *Sketch) clone() *Sketch {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	c.bins = append([]uint64(nil), s.bins...)
+	return &c
+}
+
+// addStats adds n values from lo to hi to the count, min, and max.
+func (s *Sketch) addStats(lo, hi float64, n uint64) {
+	if s.count == 0 || lo < s.min {
+		s.min = lo
+	}
+	if s.count == 0 || hi > s.max {
+		s.max = hi
+	}
+	s.count += n
+}
+
+// addBin adds n to the bin at index k. If there would be more than
+// SketchMaxBins bins, the lowest bins are collapsed.
+func (s *Sketch) addBin(k int, n uint64) {
+	switch {
+	case len(s.bins) == 0:
+		s.bins = append(s.bins, 0)
+		s.offset = k
+	case k < s.offset:
+		if hi := s.offset + len(s.bins) - 1; hi-k+1 > SketchMaxBins {
+			k = hi - SketchMaxBins + 1
+		}
+		if k < s.offset {
+			s.bins = append(make([]uint64, s.offset-k, s.offset-k+len(s.bins)), s.bins...)
+			s.offset = k
+		}
+	case k >= s.offset+len(s.bins):
+		if k-s.offset+1 > SketchMaxBins {
+			s.collapse(k - SketchMaxBins + 1)
+		}
+		s.bins = append(s.bins, make([]uint64, k-s.offset-len(s.bins)+1)...)
+	}
+	s.bins[k-s.offset] += n
+}
+
+// collapse adds the bins below index lo to the bin at lo.
+func (s *Sketch) collapse(lo int) {
+	n := lo - s.offset
+	if n <= 0 {
+		return
+	}
+	var sum uint64
+	for _, c := range s.bins[:min(n, len(s.bins))] {
+		sum += c
+	}
+	if n >= len(s.bins) {
+		s.bins = append(s.bins[:0], sum)
+	} else {
+		s.bins[n] += sum
+		s.bins = append(s.bins[:0], s.bins[n:]...)
+	}
+	s.offset = lo
+}
+
+// value returns the value of the bin at index k: the value within the relative
+// accuracy of all values in the bin.
+func (s *Sketch) value(k int) float64 {
+	return math.Exp(float64(k)*s.logGamma) * 2 / (s.gamma + 1)
+}
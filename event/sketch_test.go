@@ -0,0 +1,124 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package event_test
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/go-mysql/event"
+)
+
+func TestSketchEmpty(t *testing.T) {
+	s := event.NewSketch(0)
+	assert.Equal(t, uint64(0), s.Count())
+	assert.Equal(t, 0.0, s.Quantile(0.99))
+}
+
+func TestSketchQuantile(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	vals := make([]float64, 100000)
+	s := event.NewSketch(event.DefaultRelativeAccuracy)
+	for i := range vals {
+		// Query times from 1 microsecond to about 1000 seconds.
+		vals[i] = math.Exp(r.Float64()*20 - 13.8)
+		s.Add(vals[i])
+	}
+	sort.Float64s(vals)
+	require.Equal(t, uint64(len(vals)), s.Count())
+
+	for _, q := range []float64{0, 0.5, 0.95, 0.99, 0.999, 1} {
+		want := vals[min(int(q*float64(len(vals))), len(vals)-1)]
+		assert.InEpsilon(t, want, s.Quantile(q), event.DefaultRelativeAccuracy, "q=%v", q)
+	}
+	assert.Equal(t, vals[0], s.Quantile(0))
+	assert.Equal(t, vals[len(vals)-1], s.Quantile(1))
+}
+
+func TestSketchZero(t *testing.T) {
+	s := event.NewSketch(0)
+	for i := 0; i < 10; i++ {
+		s.Add(0)
+	}
+	s.Add(5)
+	assert.Equal(t, 0.0, s.Quantile(0.5))
+	assert.Equal(t, 5.0, s.Quantile(1))
+}
+
+func TestSketchMerge(t *testing.T) {
+	a := event.NewSketch(0)
+	b := event.NewSketch(0)
+	all := event.NewSketch(0)
+	for i := 1; i <= 1000; i++ {
+		v := float64(i)
+		if i%2 == 0 {
+			a.Add(v)
+		} else {
+			b.Add(v)
+		}
+		all.Add(v)
+	}
+	a.Merge(b)
+	assert.Equal(t, uint64(1000), a.Count())
+	for _, q := range []float64{0, 0.5, 0.95, 0.99, 1} {
+		assert.Equal(t, all.Quantile(q), a.Quantile(q), "q=%v", q)
+	}
+
+	// Other accuracy
+	c := event.NewSketch(0.05)
+	c.Add(100)
+	a.Merge(c)
+	assert.Equal(t, uint64(1001), a.Count())
+	a.Merge(nil)
+	assert.Equal(t, uint64(1001), a.Count())
+}
+
+func TestSketchCollapse(t *testing.T) {
+	// Values from 1e-9 to 1e9 need more than SketchMaxBins bins at 1%,
+	// so the lowest bins are collapsed and high quantiles stay accurate.
+	s := event.NewSketch(0)
+	vals := []float64{}
+	for v := 1e-9; v < 1e9; v *= 1.001 {
+		vals = append(vals, v)
+		s.Add(v)
+	}
+	n := len(vals)
+	for _, q := range []float64{0.5, 0.99} {
+		assert.InEpsilon(t, vals[int(q*float64(n))], s.Quantile(q), event.DefaultRelativeAccuracy, "q=%v", q)
+	}
+	assert.Equal(t, vals[0], s.Quantile(0))
+	assert.Equal(t, vals[n-1], s.Quantile(1))
+}
@@ -0,0 +1,162 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package event
+
+import (
+	"math/bits"
+	"sort"
+	"time"
+
+	"github.com/percona/go-mysql/log"
+)
+
+// A Window is the Result of the events in a time window: Event.Ts in
+// [Start, End).
+type Window struct {
+	Start time.Time
+	End   time.Time
+	Result
+}
+
+// A WindowAggregator groups events by Event.Ts into fixed time windows, like
+// 1m, 5m, or 1h, with an Aggregator for each window, so it yields a Result for
+// each window instead of one for all events. Windows are aligned to the Unix
+// epoch, so 1h windows begin on the hour.
+//
+// Events can arrive late or out of order: a window is finalized when an event
+// is at least grace after its end, or when Flush is called. Events of a
+// finalized window, and events without Ts, are dropped and counted, see
+// Dropped. Windows without events are not returned.
+type WindowAggregator struct {
+	size          time.Duration
+	offset        time.Duration // Unix epoch minus zero time, modulo size
+	grace         time.Duration
+	newAggregator func() *Aggregator
+	// --
+	windows   map[time.Time]*Aggregator // keyed on window start
+	watermark time.Time                 // greatest Ts
+	final     time.Time                 // windows that end before or at final are finalized
+	dropped   uint
+}
+
+// NewWindowAggregator returns a new WindowAggregator of windows of the size
+// that are finalized at least grace after their end. newAggregator returns the
+// Aggregator of each window, so that each can be configured, like with
+// GroupByLabels. If it is nil, NewAggregator(false, 0, 0) is used. It panics
+// if size is not positive.
+func NewWindowAggregator(size, grace time.Duration, newAggregator func() *Aggregator) *WindowAggregator {
+	if size <= 0 {
+		panic("event: non-positive size for NewWindowAggregator")
+	}
+	if newAggregator == nil {
+		newAggregator = func() *Aggregator { return NewAggregator(false, 0, 0) }
+	}
+	// The seconds from the zero time to the Unix epoch in nanoseconds
+	// overflow a Duration, so the remainder is computed in 128 bits.
+	hi, lo := bits.Mul64(uint64(time.Unix(0, 0).Unix()-time.Time{}.Unix()), uint64(time.Second))
+	return &WindowAggregator{
+		size:          size,
+		offset:        time.Duration(bits.Rem64(hi, lo, uint64(size))),
+		grace:         grace,
+		newAggregator: newAggregator,
+		// --
+		windows: make(map[time.Time]*Aggregator),
+	}
+}
+
+// AddEvent adds the event to the aggregator of its window, like
+// Aggregator.AddEvent, and returns the windows finalized because the event is
+// at least grace after their end, in time order. Events for them are dropped.
+func (w *WindowAggregator) AddEvent(event *log.Event, id, user, host, db, server, fingerprint string) []Window {
+	if event.Ts.IsZero() {
+		w.dropped++
+		return nil
+	}
+	start := w.start(event.Ts)
+	if !start.Add(w.size).After(w.final) {
+		w.dropped++
+		return nil
+	}
+	start = start.UTC()
+	a, ok := w.windows[start]
+	if !ok {
+		a = w.newAggregator()
+		w.windows[start] = a
+	}
+	a.AddEvent(event, id, user, host, db, server, fingerprint)
+
+	if event.Ts.After(w.watermark) {
+		w.watermark = event.Ts
+	}
+	return w.finalize(w.watermark.Add(-w.grace))
+}
+
+// Flush finalizes and returns all windows in time order. Events for them are
+// dropped.
+func (w *WindowAggregator) Flush() []Window {
+	return w.finalize(time.Time{})
+}
+
+// Dropped returns the number of events that were not aggregated because their
+// window was finalized or they had no Ts.
+func (w *WindowAggregator) Dropped() uint {
+	return w.dropped
+}
+
+// finalize finalizes and returns the windows that end before or at t, or all
+// windows if t is zero.
+func (w *WindowAggregator) finalize(t time.Time) []Window {
+	var windows []Window
+	for start, a := range w.windows {
+		end := start.Add(w.size)
+		if !t.IsZero() && end.After(t) {
+			continue
+		}
+		windows = append(windows, Window{Start: start, End: end, Result: a.Finalize()})
+		delete(w.windows, start)
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Start.Before(windows[j].Start) })
+	for _, win := range windows {
+		if win.End.After(w.final) {
+			w.final = win.End
+		}
+	}
+	if t.After(w.final) {
+		w.final = w.start(t)
+	}
+	return windows
+}
+
+// start returns the start of the window of t. Time.Truncate aligns to the
+// zero time, so t is shifted to align to the Unix epoch.
+func (w *WindowAggregator) start(t time.Time) time.Time {
+	return t.Add(-w.offset).Truncate(w.size).Add(w.offset)
+}
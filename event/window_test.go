@@ -0,0 +1,119 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package event_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/go-mysql/event"
+	"github.com/percona/go-mysql/log"
+)
+
+func TestWindowAggregator(t *testing.T) {
+	w := event.NewWindowAggregator(time.Minute, 30*time.Second, func() *event.Aggregator {
+		a := event.NewAggregator(true, 0, 0)
+		a.GroupByLabels("app")
+		return a
+	})
+	t0 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	add := func(ts time.Duration, q string) []event.Window {
+		e := log.NewEvent()
+		e.Query = q
+		e.Ts = t0.Add(ts)
+		e.TimeMetrics["Query_time"] = 1
+		return w.AddEvent(e, q, "", "", "", "", q)
+	}
+
+	assert.Empty(t, add(10*time.Second, "a"))
+	assert.Empty(t, add(50*time.Second, "b"))
+	assert.Empty(t, add(65*time.Second, "a"))
+	assert.Empty(t, add(55*time.Second, "a"), "late within grace period")
+	assert.Empty(t, add(85*time.Second, "b"), "less than grace after window")
+
+	windows := add(90*time.Second, "b")
+	require.Len(t, windows, 1)
+	assert.Equal(t, t0, windows[0].Start)
+	assert.Equal(t, t0.Add(time.Minute), windows[0].End)
+	assert.Equal(t, uint(3), windows[0].Global.TotalQueries)
+	require.Len(t, windows[0].Class, 2)
+	for _, class := range windows[0].Class {
+		if class.Id == "a" {
+			assert.Equal(t, uint(2), class.TotalQueries)
+		}
+	}
+
+	assert.Empty(t, add(59*time.Second, "a"), "finalized window")
+	assert.Equal(t, uint(1), w.Dropped())
+	assert.Empty(t, w.AddEvent(log.NewEvent(), "a", "", "", "", "", "a"), "no Ts")
+	assert.Equal(t, uint(2), w.Dropped())
+
+	windows = add(200*time.Second, "a")
+	require.Len(t, windows, 1)
+	assert.Equal(t, t0.Add(time.Minute), windows[0].Start)
+	assert.Equal(t, uint(3), windows[0].Global.TotalQueries)
+
+	windows = w.Flush()
+	require.Len(t, windows, 1)
+	assert.Equal(t, t0.Add(3*time.Minute), windows[0].Start)
+	assert.Equal(t, uint(1), windows[0].Global.TotalQueries)
+	assert.Empty(t, w.Flush())
+}
+
+// Windows are aligned to the Unix epoch, even if their size does not divide
+// the time since the zero time.
+func TestWindowAggregatorAlignment(t *testing.T) {
+	for _, size := range []time.Duration{7 * 24 * time.Hour, 7 * time.Hour, 90 * time.Minute, time.Minute} {
+		w := event.NewWindowAggregator(size, 0, nil)
+		e := log.NewEvent()
+		e.Query = "select 1"
+		e.Ts = time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC)
+		w.AddEvent(e, "a", "", "", "", "", "select ?")
+		windows := w.Flush()
+		require.Len(t, windows, 1)
+		n := int64(size / time.Second)
+		expected := time.Unix(e.Ts.Unix()/n*n, 0).UTC()
+		assert.Equal(t, expected, windows[0].Start, size.String())
+		assert.Equal(t, expected.Add(size), windows[0].End, size.String())
+		if size == 7*24*time.Hour {
+			// 1970-01-01 was a Thursday.
+			assert.Equal(t, time.Thursday, windows[0].Start.Weekday())
+		}
+	}
+}
+
+func TestWindowAggregatorSize(t *testing.T) {
+	assert.Panics(t, func() { event.NewWindowAggregator(0, 0, nil) })
+	assert.Panics(t, func() { event.NewWindowAggregator(-time.Minute, 0, nil) })
+}
@@ -0,0 +1,396 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Package cloud parses MySQL slow logs exported from managed services like
+// Amazon RDS/Aurora (CloudWatch Logs) and Google Cloud SQL (Cloud Logging).
+// These services wrap slow log lines in JSON records. The parsers in this
+// package rebuild a normal slow log stream from the records of each instance
+// and parse it with the slow log parser (see percona.com/go-mysql/log/slow/),
+// so exported logs can be analyzed like local ones.
+package cloud
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	stdlog "log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/percona/go-mysql/log"
+	"github.com/percona/go-mysql/log/slow"
+)
+
+// A record is one JSON-wrapped piece of a slow log.
+type record struct {
+	server  string    // instance identifier
+	ts      time.Time // record timestamp, used only for ordering
+	message string    // one or more slow log lines
+}
+
+// A stream is the ordered records of one instance.
+type stream struct {
+	server  string
+	records []record
+}
+
+// A Parser parses a slow log export. It implements the LogParser interface.
+// The entire export is read into memory before parsing starts because records
+// must be grouped by instance and ordered by timestamp.
+//
+// Events are sent instance by instance in the order in which the instances
+// first appear in the export. Event.Server is set to the instance identifier.
+// Event offsets are relative to the slow log rebuilt for each instance, so
+// Options.StartOffset is ignored.
+type Parser struct {
+	r      io.Reader
+	opt    log.Options
+	decode func(io.Reader) ([]record, error)
+	lines  bool // every record is one line
+	// --
+	stopChan  chan bool
+	eventChan chan *log.Event
+	stopped   bool
+}
+
+func newParser(r io.Reader, opt log.Options, decode func(io.Reader) ([]record, error), lines bool) *Parser {
+	opt.StartOffset = 0
+	p := &Parser{
+		r:      r,
+		opt:    opt,
+		decode: decode,
+		lines:  lines,
+		// --
+		stopChan:  make(chan bool, 1),
+		eventChan: make(chan *log.Event),
+	}
+	return p
+}
+
+// NewCloudWatchParser returns a new Parser that reads a CloudWatch Logs export
+// of an RDS or Aurora slow query log from r. It accepts the output of
+// "aws logs filter-log-events" and "aws logs get-log-events" as well as
+// subscription filter and Firehose records.
+func NewCloudWatchParser(r io.Reader, opt log.Options) *Parser {
+	return newParser(r, opt, decodeCloudWatch, false)
+}
+
+// NewCloudSQLParser returns a new Parser that reads a Cloud Logging export of a
+// Cloud SQL for MySQL slow query log from r. It accepts the JSON array output of
+// "gcloud logging read --format=json" and newline-delimited entries written by
+// log sinks. Entries of other logs, like mysql.err, are ignored.
+func NewCloudSQLParser(r io.Reader, opt log.Options) *Parser {
+	return newParser(r, opt, decodeCloudSQL, true)
+}
+
+// logf logs with configured logger.
+func (p *Parser) logf(format string, v ...interface{}) {
+	if !p.opt.Debug {
+		return
+	}
+	if p.opt.Debugf != nil {
+		p.opt.Debugf(format, v...)
+		return
+	}
+	stdlog.Printf(format, v...)
+}
+
+// EventChan returns the unbuffered event channel on which the caller can
+// receive events.
+func (p *Parser) EventChan() <-chan *log.Event {
+	return p.eventChan
+}
+
+// Stop stops the parser before parsing the next event or while blocked on
+// sending the current event to the event channel.
+func (p *Parser) Stop() {
+	p.logf("stopping")
+	p.stopChan <- true
+}
+
+// Start starts the parser. Events are sent to the unbuffered event channel.
+// Parsing stops on EOF, error, or call to Stop. The event channel is closed
+// when parsing stops. The reader is not closed.
+func (p *Parser) Start() error {
+	defer close(p.eventChan)
+
+	records, err := p.decode(p.r)
+	if err != nil {
+		return err
+	}
+	p.logf("decoded %d records", len(records))
+
+	for _, s := range groupByServer(records) {
+		if p.stopped {
+			break
+		}
+		if err := p.parseStream(s); err != nil {
+			return err
+		}
+	}
+
+	p.logf("done")
+	return nil
+}
+
+// parseStream rebuilds the slow log of one instance and sends its events.
+func (p *Parser) parseStream(s stream) error {
+	p.logf("parsing %d records of %q", len(s.records), s.server)
+
+	sp := slow.NewSlowLogReaderParser(strings.NewReader(rebuild(s.records, p.lines)), s.server, p.opt)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- sp.Start()
+	}()
+
+	for e := range sp.EventChan() {
+		if e.Server == "" {
+			e.Server = s.server
+		}
+		select {
+		case p.eventChan <- e:
+		case <-p.stopChan:
+			p.stopped = true
+			sp.Stop()
+			for range sp.EventChan() {
+				// Drain so the slow log parser can return.
+			}
+		}
+	}
+
+	return <-errChan
+}
+
+// groupByServer groups records by instance in order of first appearance and
+// sorts the records of each instance by timestamp. Exports are often newest
+// first, so records in descending order are reversed before sorting to keep
+// records with equal timestamps, like the lines of one entry, in log order.
+func groupByServer(records []record) []stream {
+	var streams []stream
+	index := map[string]int{}
+	for _, r := range records {
+		i, ok := index[r.server]
+		if !ok {
+			i = len(streams)
+			index[r.server] = i
+			streams = append(streams, stream{server: r.server})
+		}
+		streams[i].records = append(streams[i].records, r)
+	}
+
+	for _, s := range streams {
+		recs := s.records
+		if descending(recs) {
+			for i, j := 0, len(recs)-1; i < j; i, j = i+1, j-1 {
+				recs[i], recs[j] = recs[j], recs[i]
+			}
+		}
+		sort.SliceStable(recs, func(i, j int) bool {
+			return recs[i].ts.Before(recs[j].ts)
+		})
+	}
+	return streams
+}
+
+// descending returns true if the records are newest first.
+func descending(records []record) bool {
+	if len(records) < 2 || !records[0].ts.After(records[len(records)-1].ts) {
+		return false
+	}
+	for i := 1; i < len(records); i++ {
+		if records[i].ts.After(records[i-1].ts) {
+			return false
+		}
+	}
+	return true
+}
+
+// rebuild concatenates records into a slow log. If lines is true, every record
+// is one line, so a newline is added after every record that lacks one.
+// Otherwise records are whole slow log entries, and a record that does not
+// begin with a header line ("#") is the continuation of an entry that was split
+// because it exceeded the record size limit, so it is joined as is.
+func rebuild(records []record, lines bool) string {
+	var buf strings.Builder
+	for i, r := range records {
+		if i > 0 && !strings.HasSuffix(records[i-1].message, "\n") {
+			if lines || strings.HasPrefix(r.message, "#") {
+				buf.WriteByte('\n')
+			}
+		}
+		buf.WriteString(r.message)
+	}
+	if buf.Len() > 0 && !strings.HasSuffix(buf.String(), "\n") {
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// --------------------------------------------------------------------------
+
+// cloudWatchEvent is an event of "aws logs filter-log-events" or
+// "aws logs get-log-events" output, or a logEvent of a subscription record.
+type cloudWatchEvent struct {
+	LogStreamName string `json:"logStreamName"`
+	Timestamp     int64  `json:"timestamp"` // milliseconds since epoch
+	Message       string `json:"message"`
+}
+
+// cloudWatchExport is any of the supported CloudWatch Logs export formats.
+type cloudWatchExport struct {
+	// aws logs filter-log-events, aws logs get-log-events
+	Events []cloudWatchEvent `json:"events"`
+
+	// subscription filter and Firehose records
+	LogGroup  string            `json:"logGroup"`
+	LogStream string            `json:"logStream"`
+	LogEvents []cloudWatchEvent `json:"logEvents"`
+}
+
+func decodeCloudWatch(r io.Reader) ([]record, error) {
+	var records []record
+	add := func(stream string, events []cloudWatchEvent) {
+		for _, e := range events {
+			server := e.LogStreamName
+			if server == "" {
+				server = stream
+			}
+			records = append(records, record{
+				server:  server,
+				ts:      time.UnixMilli(e.Timestamp).UTC(),
+				message: e.Message,
+			})
+		}
+	}
+
+	err := decodeValues(r, func(raw json.RawMessage) error {
+		if raw[0] == '[' {
+			var events []cloudWatchEvent
+			if err := json.Unmarshal(raw, &events); err != nil {
+				return err
+			}
+			add("", events)
+			return nil
+		}
+		var export cloudWatchExport
+		if err := json.Unmarshal(raw, &export); err != nil {
+			return err
+		}
+		add("", export.Events)
+		stream := export.LogStream
+		if stream == "" {
+			stream = instanceFromLogGroup(export.LogGroup)
+		}
+		add(stream, export.LogEvents)
+		return nil
+	})
+	return records, err
+}
+
+// instanceFromLogGroup returns the instance or cluster identifier from a log
+// group name like /aws/rds/instance/<id>/slowquery.
+func instanceFromLogGroup(group string) string {
+	parts := strings.Split(strings.Trim(group, "/"), "/")
+	if len(parts) == 5 && parts[0] == "aws" && parts[1] == "rds" {
+		return parts[3]
+	}
+	return ""
+}
+
+// cloudSQLEntry is a Cloud Logging LogEntry.
+type cloudSQLEntry struct {
+	LogName     string    `json:"logName"`
+	Timestamp   time.Time `json:"timestamp"`
+	TextPayload string    `json:"textPayload"`
+	Resource    struct {
+		Labels struct {
+			DatabaseID string `json:"database_id"` // project:instance
+		} `json:"labels"`
+	} `json:"resource"`
+}
+
+func decodeCloudSQL(r io.Reader) ([]record, error) {
+	var records []record
+	add := func(entries []cloudSQLEntry) {
+		for _, e := range entries {
+			if e.LogName != "" && !strings.Contains(e.LogName, "mysql-slow.log") {
+				continue
+			}
+			records = append(records, record{
+				server:  e.Resource.Labels.DatabaseID,
+				ts:      e.Timestamp,
+				message: e.TextPayload,
+			})
+		}
+	}
+
+	err := decodeValues(r, func(raw json.RawMessage) error {
+		if raw[0] == '[' {
+			var entries []cloudSQLEntry
+			if err := json.Unmarshal(raw, &entries); err != nil {
+				return err
+			}
+			add(entries)
+			return nil
+		}
+		var entry cloudSQLEntry
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return err
+		}
+		add([]cloudSQLEntry{entry})
+		return nil
+	})
+	return records, err
+}
+
+// decodeValues calls fn for every top-level JSON value in r. Values can be
+// separated by whitespace or nothing at all, as in Firehose output.
+func decodeValues(r io.Reader, fn func(json.RawMessage) error) error {
+	d := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var raw json.RawMessage
+		if err := d.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("cannot decode export: %w", err)
+		}
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 || raw[0] == 'n' { // null
+			continue
+		}
+		if err := fn(raw); err != nil {
+			return fmt.Errorf("cannot decode export: %w", err)
+		}
+	}
+}
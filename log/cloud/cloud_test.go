@@ -0,0 +1,215 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cloud_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/go-mysql/log"
+	"github.com/percona/go-mysql/log/cloud"
+	"github.com/percona/go-mysql/test"
+)
+
+var (
+	sample = filepath.Join(test.RootDir(), "test/cloud-logs")
+	opt    = log.Options{
+		DefaultLocation: time.UTC,
+	}
+)
+
+func parseExport(t *testing.T, filename string, newParser func(*os.File, log.Options) log.LogParser) []log.Event {
+	file, err := os.Open(filepath.Join(sample, filename))
+	require.NoError(t, err)
+	defer file.Close()
+	p := newParser(file, opt)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- p.Start()
+	}()
+	got := []log.Event{}
+	for e := range p.EventChan() {
+		got = append(got, *e)
+	}
+	require.NoError(t, <-errChan)
+	return got
+}
+
+func cloudWatch(file *os.File, opt log.Options) log.LogParser {
+	return cloud.NewCloudWatchParser(file, opt)
+}
+
+func cloudSQL(file *os.File, opt log.Options) log.LogParser {
+	return cloud.NewCloudSQLParser(file, opt)
+}
+
+// --------------------------------------------------------------------------
+
+// cloudwatch001 is "aws logs filter-log-events" output for two RDS instances.
+// The last event of db-1 is split across two records.
+func TestCloudWatch001(t *testing.T) {
+	got := parseExport(t, "cloudwatch001.json", cloudWatch)
+	expect := []log.Event{
+		{
+			Offset:    0,
+			OffsetEnd: 236,
+			Ts:        time.Date(2023, 11, 14, 22, 13, 21, 0, time.UTC),
+			Query:     "SELECT id FROM users WHERE name IN ('a', 'b', 'c')",
+			User:      "app",
+			Host:      "",
+			Server:    "db-1",
+			TimeMetrics: map[string]float64{
+				"Query_time": 0.5,
+				"Lock_time":  0,
+			},
+			NumberMetrics: map[string]uint64{
+				"Rows_sent":     3,
+				"Rows_examined": 3,
+			},
+			BoolMetrics: map[string]bool{},
+		},
+		{
+			Offset:    236,
+			OffsetEnd: 468,
+			Ts:        time.Date(2023, 11, 14, 22, 13, 22, 123456000, time.UTC),
+			Query:     "SELECT * FROM orders WHERE id = 5",
+			User:      "app",
+			Host:      "",
+			Db:        "shop",
+			Server:    "db-1",
+			TimeMetrics: map[string]float64{
+				"Query_time": 2.5,
+				"Lock_time":  0.0001,
+			},
+			NumberMetrics: map[string]uint64{
+				"Rows_sent":     1,
+				"Rows_examined": 1000,
+			},
+			BoolMetrics: map[string]bool{},
+		},
+		{
+			Offset:    0,
+			OffsetEnd: 234,
+			Ts:        time.Date(2023, 11, 14, 22, 13, 21, 1000, time.UTC),
+			Query:     "UPDATE stock SET qty = qty - 1 WHERE sku = 'a1'",
+			User:      "app",
+			Host:      "",
+			Server:    "db-2",
+			TimeMetrics: map[string]float64{
+				"Query_time": 1,
+				"Lock_time":  0,
+			},
+			NumberMetrics: map[string]uint64{
+				"Rows_sent":     0,
+				"Rows_examined": 10,
+			},
+			BoolMetrics: map[string]bool{},
+		},
+	}
+	assert.EqualValues(t, expect, got)
+}
+
+// cloudwatch002 is a CloudWatch Logs subscription record of an Aurora cluster.
+// The instance is taken from the log group because the log stream is empty.
+func TestCloudWatch002(t *testing.T) {
+	got := parseExport(t, "cloudwatch002.json", cloudWatch)
+	require.Len(t, got, 1)
+	assert.Equal(t, "aurora-1", got[0].Server)
+	assert.Equal(t, "SELECT SLEEP(3)", got[0].Query)
+	assert.Equal(t, "rdsadmin", got[0].User)
+	assert.Equal(t, "localhost", got[0].Host)
+	assert.Equal(t, 3.0, got[0].TimeMetrics["Query_time"])
+}
+
+// cloudsql001 is "gcloud logging read --format=json" output, newest entry first,
+// with one entry per slow log line and an entry of the error log.
+func TestCloudSQL001(t *testing.T) {
+	got := parseExport(t, "cloudsql001.json", cloudSQL)
+	expect := []log.Event{
+		{
+			Offset:    0,
+			OffsetEnd: 228,
+			Ts:        time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC),
+			Query:     "SELECT *\nFROM contacts LIMIT 10",
+			User:      "app",
+			Host:      "",
+			Db:        "crm",
+			Server:    "acme:crm-primary",
+			TimeMetrics: map[string]float64{
+				"Query_time": 1.2,
+				"Lock_time":  0.00001,
+			},
+			NumberMetrics: map[string]uint64{
+				"Rows_sent":     10,
+				"Rows_examined": 10,
+			},
+			BoolMetrics: map[string]bool{},
+		},
+		{
+			Offset:    228,
+			OffsetEnd: 447,
+			Ts:        time.Date(2023, 11, 14, 22, 13, 25, 0, time.UTC),
+			Query:     "DELETE FROM sessions WHERE id = 9",
+			User:      "app",
+			Host:      "",
+			Server:    "acme:crm-primary",
+			TimeMetrics: map[string]float64{
+				"Query_time": 0.3,
+				"Lock_time":  0.00001,
+			},
+			NumberMetrics: map[string]uint64{
+				"Rows_sent":     0,
+				"Rows_examined": 1,
+			},
+			BoolMetrics: map[string]bool{},
+		},
+	}
+	assert.EqualValues(t, expect, got)
+}
+
+func TestCloudWatchInvalidExport(t *testing.T) {
+	file, err := os.Open(filepath.Join(test.RootDir(), "test/slow-logs/slow001.log"))
+	require.NoError(t, err)
+	defer file.Close()
+	p := cloud.NewCloudWatchParser(file, opt)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- p.Start()
+	}()
+	for range p.EventChan() {
+	}
+	assert.Error(t, <-errChan)
+}
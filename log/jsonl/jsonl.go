@@ -0,0 +1,306 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Package jsonl encodes and decodes events as JSON Lines (newline-delimited JSON).
+// The encoding is stable and versioned: every line is one event object with a
+// "v" member that holds the format Version. Events can be parsed once, for
+// example with the slow log parser on the database host, shipped as compact
+// JSON Lines, and decoded elsewhere for aggregation. Other tools can also use
+// this format to inject events.
+//
+// Version 1 lines look like:
+//
+//	{"v":1,"offset":199,"offset_end":358,"ts":"2007-10-15T21:43:52Z","query":"select sleep(2) from n","user":"root","host":"localhost","db":"test","time_metrics":{"Lock_time":0,"Query_time":2},"number_metrics":{"Rows_examined":0,"Rows_sent":1}}
+//
+// Members with zero values are omitted. Ts is encoded as RFC 3339 with
+// nanoseconds, so no precision is lost. Unknown members are ignored by the
+// decoder, so members can be added without changing the version.
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	stdlog "log"
+	"time"
+
+	"github.com/percona/go-mysql/log"
+)
+
+// Version is the version of the encoding written by Encoder. Decoder accepts
+// this version and all previous versions.
+const Version = 1
+
+// line is the encoding of one event.
+type line struct {
+	V             int                `json:"v"`
+	Offset        uint64             `json:"offset,omitempty"`
+	OffsetEnd     uint64             `json:"offset_end,omitempty"`
+	Ts            string             `json:"ts,omitempty"` // RFC 3339 with nanoseconds
+	Admin         bool               `json:"admin,omitempty"`
+	Query         string             `json:"query,omitempty"`
+	User          string             `json:"user,omitempty"`
+	Host          string             `json:"host,omitempty"`
+	Db            string             `json:"db,omitempty"`
+	Server        string             `json:"server,omitempty"`
+	Labels        log.Labels         `json:"labels,omitempty"`
+	TimeMetrics   map[string]float64 `json:"time_metrics,omitempty"`
+	NumberMetrics map[string]uint64  `json:"number_metrics,omitempty"`
+	BoolMetrics   map[string]bool    `json:"bool_metrics,omitempty"`
+	RateType      string             `json:"rate_type,omitempty"`
+	RateLimit     uint               `json:"rate_limit,omitempty"`
+}
+
+// An Encoder writes events as JSON Lines to an output stream.
+type Encoder struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// NewEncoder returns a new Encoder that writes to w. Output is buffered; call
+// Flush when done encoding events.
+func NewEncoder(w io.Writer) *Encoder {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	return &Encoder{
+		w:   bw,
+		enc: enc,
+	}
+}
+
+// Encode writes the event as one line.
+func (e *Encoder) Encode(event *log.Event) error {
+	l := line{
+		V:             Version,
+		Offset:        event.Offset,
+		OffsetEnd:     event.OffsetEnd,
+		Admin:         event.Admin,
+		Query:         event.Query,
+		User:          event.User,
+		Host:          event.Host,
+		Db:            event.Db,
+		Server:        event.Server,
+		Labels:        event.Labels,
+		TimeMetrics:   event.TimeMetrics,
+		NumberMetrics: event.NumberMetrics,
+		BoolMetrics:   event.BoolMetrics,
+		RateType:      event.RateType,
+		RateLimit:     event.RateLimit,
+	}
+	if !event.Ts.IsZero() {
+		l.Ts = event.Ts.Format(time.RFC3339Nano)
+	}
+	if len(l.Labels) == 0 {
+		// Deprecated fields
+		for i, k := range event.LabelsKey {
+			if i < len(event.LabelsValue) {
+				l.Labels = l.Labels.Set(k, event.LabelsValue[i])
+			}
+		}
+	}
+	return e.enc.Encode(l)
+}
+
+// EncodeEvents encodes all events received from the channel until it is
+// closed, then flushes the output. This is usually the event channel of
+// a LogParser.
+func (e *Encoder) EncodeEvents(events <-chan *log.Event) error {
+	for event := range events {
+		if err := e.Encode(event); err != nil {
+			return err
+		}
+	}
+	return e.Flush()
+}
+
+// Flush writes any buffered lines to the underlying writer.
+func (e *Encoder) Flush() error {
+	return e.w.Flush()
+}
+
+// --------------------------------------------------------------------------
+
+// A Decoder reads events encoded as JSON Lines. It implements the LogParser
+// interface.
+type Decoder struct {
+	r   io.Reader
+	opt log.Options
+	// --
+	stopChan  chan bool
+	eventChan chan *log.Event
+	stopped   bool
+}
+
+// NewDecoder returns a new Decoder that reads from r. Options.StartOffset is
+// the byte offset in r, not in the original log, at which to start decoding.
+func NewDecoder(r io.Reader, opt log.Options) *Decoder {
+	d := &Decoder{
+		r:   r,
+		opt: opt,
+		// --
+		stopChan:  make(chan bool, 1),
+		eventChan: make(chan *log.Event),
+	}
+	return d
+}
+
+// logf logs with configured logger.
+func (d *Decoder) logf(format string, v ...interface{}) {
+	if !d.opt.Debug {
+		return
+	}
+	if d.opt.Debugf != nil {
+		d.opt.Debugf(format, v...)
+		return
+	}
+	stdlog.Printf(format, v...)
+}
+
+// EventChan returns the unbuffered event channel on which the caller can
+// receive events.
+func (d *Decoder) EventChan() <-chan *log.Event {
+	return d.eventChan
+}
+
+// Stop stops the decoder before decoding the next event or while blocked on
+// sending the current event to the event channel.
+func (d *Decoder) Stop() {
+	d.logf("stopping")
+	d.stopChan <- true
+}
+
+// Start starts the decoder. Events are sent to the unbuffered event channel.
+// Decoding stops on EOF, error, or call to Stop. The event channel is closed
+// when decoding stops. The reader is not closed. Empty lines are skipped;
+// a malformed line or a line with an unsupported version is an error.
+func (d *Decoder) Start() error {
+	defer close(d.eventChan)
+
+	if d.opt.StartOffset > 0 {
+		if s, ok := d.r.(io.Seeker); ok {
+			if _, err := s.Seek(int64(d.opt.StartOffset), io.SeekStart); err != nil {
+				return err
+			}
+		} else if _, err := io.CopyN(io.Discard, d.r, int64(d.opt.StartOffset)); err != nil {
+			return err
+		}
+	}
+
+	r := bufio.NewReader(d.r)
+	offset := d.opt.StartOffset
+	for !d.stopped {
+		select {
+		case <-d.stopChan:
+			d.stopped = true
+			continue
+		default:
+		}
+
+		b, err := r.ReadBytes('\n')
+		if len(b) > 0 {
+			if perr := d.decodeLine(b, offset); perr != nil {
+				return perr
+			}
+			offset += uint64(len(b))
+		}
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+			break
+		}
+	}
+
+	d.logf("done")
+	return nil
+}
+
+func (d *Decoder) decodeLine(b []byte, offset uint64) error {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+	d.logf("+%d line: %s", offset, b)
+
+	var l line
+	if err := json.Unmarshal(b, &l); err != nil {
+		return fmt.Errorf("line at offset %d: %w", offset, err)
+	}
+	if l.V < 1 || l.V > Version {
+		return fmt.Errorf("line at offset %d: unsupported version %d", offset, l.V)
+	}
+
+	event := log.NewEvent()
+	event.Offset = l.Offset
+	event.OffsetEnd = l.OffsetEnd
+	event.Admin = l.Admin
+	event.Query = l.Query
+	event.User = l.User
+	event.Host = l.Host
+	event.Db = l.Db
+	event.Server = l.Server
+	event.Labels = l.Labels
+	if len(l.Labels) > 0 {
+		event.LabelsKey = l.Labels.Keys()
+		event.LabelsValue = l.Labels.Values()
+	}
+	event.RateType = l.RateType
+	event.RateLimit = l.RateLimit
+	if l.Ts != "" {
+		ts, err := time.Parse(time.RFC3339Nano, l.Ts)
+		if err != nil {
+			return fmt.Errorf("line at offset %d: %w", offset, err)
+		}
+		event.Ts = ts
+	}
+	for k, v := range l.TimeMetrics {
+		event.TimeMetrics[k] = v
+	}
+	for k, v := range l.NumberMetrics {
+		event.NumberMetrics[k] = v
+	}
+	for k, v := range l.BoolMetrics {
+		event.BoolMetrics[k] = v
+	}
+
+	if event.Admin && d.opt.FilterAdminCommand[event.Query] {
+		d.logf("filtered admin command")
+		return nil
+	}
+
+	select {
+	case d.eventChan <- event:
+	case <-d.stopChan:
+		d.stopped = true
+	}
+	return nil
+}
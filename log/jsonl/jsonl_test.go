@@ -0,0 +1,173 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package jsonl_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/go-mysql/log"
+	"github.com/percona/go-mysql/log/jsonl"
+	parser "github.com/percona/go-mysql/log/slow"
+	"github.com/percona/go-mysql/test"
+)
+
+var (
+	sample = filepath.Join(test.RootDir(), "test/slow-logs")
+	opt    = log.Options{
+		DefaultLocation: time.UTC,
+	}
+)
+
+func parseSlowLog(t *testing.T, filename string) []*log.Event {
+	file, err := os.Open(filepath.Join(sample, filename))
+	require.NoError(t, err)
+	defer file.Close()
+	p := parser.NewSlowLogParser(file, opt)
+	go p.Start()
+	var got []*log.Event
+	for e := range p.EventChan() {
+		got = append(got, e)
+	}
+	return got
+}
+
+func encode(t *testing.T, events []*log.Event) []byte {
+	var buf bytes.Buffer
+	enc := jsonl.NewEncoder(&buf)
+	for _, e := range events {
+		require.NoError(t, enc.Encode(e))
+	}
+	require.NoError(t, enc.Flush())
+	return buf.Bytes()
+}
+
+func decode(t *testing.T, data string, o log.Options) ([]*log.Event, error) {
+	d := jsonl.NewDecoder(strings.NewReader(data), o)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- d.Start()
+	}()
+	var got []*log.Event
+	for e := range d.EventChan() {
+		got = append(got, e)
+	}
+	return got, <-errChan
+}
+
+// --------------------------------------------------------------------------
+
+func TestEncodeSlow002(t *testing.T) {
+	got := encode(t, parseSlowLog(t, "slow002.log"))
+
+	golden := filepath.Join("testdata", "slow002.jsonl")
+	if *test.Update {
+		require.NoError(t, os.WriteFile(golden, got, 0o666))
+	}
+	expect, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, string(expect), string(got))
+}
+
+// Every event of every sample slow log survives encoding and decoding.
+func TestRoundTrip(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(sample, "*.log"))
+	require.NoError(t, err)
+	for _, file := range files {
+		filename := filepath.Base(file)
+		t.Run(filename, func(t *testing.T) {
+			events := parseSlowLog(t, filename)
+			got, err := decode(t, string(encode(t, events)), log.Options{})
+			require.NoError(t, err)
+			assert.Equal(t, events, got)
+		})
+	}
+}
+
+func TestRoundTripNanosecondsAndLabels(t *testing.T) {
+	e := log.NewEvent()
+	e.Ts = time.Date(2023, 11, 14, 22, 13, 20, 123456789, time.UTC)
+	e.Query = "SELECT 1"
+	e.Server = "db1"
+	e.Labels = log.LabelsFromPairs("env", "prod", "app", "shop")
+	e.TimeMetrics["Query_time"] = 0.000001
+	e.NumberMetrics["Rows_examined"] = 1<<64 - 1
+	e.BoolMetrics["Full_scan"] = true
+
+	data := encode(t, []*log.Event{e})
+	assert.Equal(t, `{"v":1,"ts":"2023-11-14T22:13:20.123456789Z","query":"SELECT 1","server":"db1","labels":{"app":"shop","env":"prod"},"time_metrics":{"Query_time":0.000001},"number_metrics":{"Rows_examined":18446744073709551615},"bool_metrics":{"Full_scan":true}}`+"\n", string(data))
+
+	got, err := decode(t, string(data), log.Options{})
+	require.NoError(t, err)
+	e.LabelsKey = []string{"app", "env"}
+	e.LabelsValue = []string{"shop", "prod"}
+	assert.Equal(t, []*log.Event{e}, got)
+
+	// Events with only the deprecated fields are encoded with their labels.
+	e.Labels = nil
+	assert.Equal(t, string(data), string(encode(t, []*log.Event{e})))
+}
+
+func TestDecoder(t *testing.T) {
+	data := `{"v":1,"query":"select 1","time_metrics":{"Query_time":1}}
+
+{"v":1,"admin":true,"query":"Quit","future_member":[1,2,3]}
+{"v":1,"query":"select 2"}
+`
+	got, err := decode(t, data, log.Options{FilterAdminCommand: map[string]bool{"Quit": true}})
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, "select 1", got[0].Query)
+	assert.Equal(t, 1.0, got[0].TimeMetrics["Query_time"])
+	assert.Equal(t, "select 2", got[1].Query)
+
+	// StartOffset is the offset of the second event.
+	got, err = decode(t, data, log.Options{StartOffset: 60})
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, "Quit", got[0].Query)
+
+	_, err = decode(t, `{"v":2,"query":"select 1"}`, log.Options{})
+	assert.EqualError(t, err, "line at offset 0: unsupported version 2")
+
+	_, err = decode(t, `{"query":"select 1"}`, log.Options{})
+	assert.EqualError(t, err, "line at offset 0: unsupported version 0")
+
+	_, err = decode(t, "{\"v\":1}\nnot json\n", log.Options{})
+	assert.ErrorContains(t, err, "line at offset 8: invalid character")
+}
@@ -0,0 +1,223 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package log
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Label is a key-value pair that describes an event, like the application,
+// endpoint, or trace that executed the query. Labels are dimensions like
+// Prometheus labels: events can be grouped by label values.
+type Label struct {
+	Key   string
+	Value string
+}
+
+// Labels is a set of labels sorted by key. Keys are unique. The zero value is
+// an empty set. Labels are encoded as a JSON object like {"app":"shop"}.
+type Labels []Label
+
+// NewLabels returns a label set with the key-value pairs in m.
+func NewLabels(m map[string]string) Labels {
+	if len(m) == 0 {
+		return nil
+	}
+	ls := make(Labels, 0, len(m))
+	for k, v := range m {
+		ls = append(ls, Label{Key: k, Value: v})
+	}
+	sort.Slice(ls, func(i, j int) bool { return ls[i].Key < ls[j].Key })
+	return ls
+}
+
+// LabelsFromPairs returns a label set with the key-value pairs kv, like
+// LabelsFromPairs("app", "shop", "env", "prod"). If a key is repeated, the
+// last value wins. A trailing key without a value is ignored.
+func LabelsFromPairs(kv ...string) Labels {
+	var ls Labels
+	for i := 0; i+1 < len(kv); i += 2 {
+		ls = ls.Set(kv[i], kv[i+1])
+	}
+	return ls
+}
+
+// Get returns the value of the label with the key.
+func (ls Labels) Get(key string) (string, bool) {
+	i := ls.search(key)
+	if i < len(ls) && ls[i].Key == key {
+		return ls[i].Value, true
+	}
+	return "", false
+}
+
+// Set returns a copy of the label set with the label key set to value.
+// The original label set is not modified.
+func (ls Labels) Set(key, value string) Labels {
+	i := ls.search(key)
+	if i < len(ls) && ls[i].Key == key {
+		if ls[i].Value == value {
+			return ls
+		}
+		n := append(Labels(nil), ls...)
+		n[i].Value = value
+		return n
+	}
+	n := make(Labels, 0, len(ls)+1)
+	n = append(n, ls[:i]...)
+	n = append(n, Label{Key: key, Value: value})
+	n = append(n, ls[i:]...)
+	return n
+}
+
+// Keys returns the sorted label keys.
+func (ls Labels) Keys() []string {
+	keys := make([]string, len(ls))
+	for i, l := range ls {
+		keys[i] = l.Key
+	}
+	return keys
+}
+
+// Values returns the label values in the order of Keys.
+func (ls Labels) Values() []string {
+	values := make([]string, len(ls))
+	for i, l := range ls {
+		values[i] = l.Value
+	}
+	return values
+}
+
+// Select returns the subset of labels with the keys. Keys that are not set
+// are not in the returned set.
+func (ls Labels) Select(keys ...string) Labels {
+	var n Labels
+	for _, k := range keys {
+		if v, ok := ls.Get(k); ok {
+			n = n.Set(k, v)
+		}
+	}
+	return n
+}
+
+// Equal returns true if both label sets have the same labels.
+func (ls Labels) Equal(o Labels) bool {
+	if len(ls) != len(o) {
+		return false
+	}
+	for i := range ls {
+		if ls[i] != o[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Hash returns a 64-bit FNV-1a hash of the label set. Equal label sets have
+// equal hashes.
+func (ls Labels) Hash() uint64 {
+	h := fnv.New64a()
+	sep := []byte{0xff} // not valid in UTF-8, so it cannot appear in keys
+	for _, l := range ls {
+		h.Write([]byte(l.Key))
+		h.Write(sep)
+		h.Write([]byte(l.Value))
+		h.Write(sep)
+	}
+	return h.Sum64()
+}
+
+// String returns the canonical form of the label set, like
+// {app="shop", env="prod"}. Values are quoted, and so are keys that are not
+// like Prometheus label names, like {"a=b"="c"}, so equal label sets have
+// equal strings and different label sets have different strings.
+func (ls Labels) String() string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range ls {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if isLabelName(l.Key) {
+			b.WriteString(l.Key)
+		} else {
+			b.WriteString(strconv.Quote(l.Key))
+		}
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(l.Value))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// isLabelName returns true if s is like a Prometheus label name, like app or
+// trace_id: a letter or _ followed by letters, digits, and _.
+func isLabelName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalJSON encodes the label set as a JSON object.
+func (ls Labels) MarshalJSON() ([]byte, error) {
+	m := make(map[string]string, len(ls))
+	for _, l := range ls {
+		m[l.Key] = l.Value
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON decodes the label set from a JSON object.
+func (ls *Labels) UnmarshalJSON(data []byte) error {
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	*ls = NewLabels(m)
+	return nil
+}
+
+// search returns the index of the label with the key or, if there is none,
+// the index at which it would be inserted.
+func (ls Labels) search(key string) int {
+	return sort.Search(len(ls), func(i int) bool { return ls[i].Key >= key })
+}
@@ -0,0 +1,99 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package log_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/go-mysql/log"
+)
+
+func TestLabels(t *testing.T) {
+	ls := log.LabelsFromPairs("env", "prod", "app", "shop", "env", "dev")
+	assert.Equal(t, log.Labels{{Key: "app", Value: "shop"}, {Key: "env", Value: "dev"}}, ls)
+	assert.Equal(t, []string{"app", "env"}, ls.Keys())
+	assert.Equal(t, `{app="shop", env="dev"}`, ls.String())
+
+	v, ok := ls.Get("app")
+	assert.True(t, ok)
+	assert.Equal(t, "shop", v)
+	_, ok = ls.Get("trace")
+	assert.False(t, ok)
+
+	// Set does not modify the original set.
+	ls2 := ls.Set("app", "blog")
+	assert.Equal(t, `{app="blog", env="dev"}`, ls2.String())
+	assert.Equal(t, `{app="shop", env="dev"}`, ls.String())
+	ls3 := ls.Set("controller", "orders")
+	assert.Equal(t, []string{"app", "controller", "env"}, ls3.Keys())
+	assert.Equal(t, []string{"app", "env"}, ls.Keys())
+
+	assert.Equal(t, log.LabelsFromPairs("env", "dev"), ls3.Select("env", "trace"))
+	assert.Nil(t, ls3.Select("trace"))
+
+	same := log.NewLabels(map[string]string{"env": "dev", "app": "shop"})
+	assert.True(t, ls.Equal(same))
+	assert.Equal(t, ls.Hash(), same.Hash())
+	assert.False(t, ls.Equal(ls2))
+	assert.NotEqual(t, ls.Hash(), ls2.Hash())
+
+	// Key-value boundaries are part of the hash and string.
+	a := log.LabelsFromPairs("a", "bc")
+	b := log.LabelsFromPairs("ab", "c")
+	assert.NotEqual(t, a.Hash(), b.Hash())
+	assert.NotEqual(t, a.String(), b.String())
+
+	// Keys with separators or quotes are quoted, so label sets do not collide.
+	a = log.LabelsFromPairs("k1", "v", "k2", "w")
+	b = log.LabelsFromPairs(`k1="v", k2`, "w")
+	assert.Equal(t, `{k1="v", k2="w"}`, a.String())
+	assert.Equal(t, `{"k1=\"v\", k2"="w"}`, b.String())
+	assert.Equal(t, `{""="", "a b"="c", x_1="y"}`, log.LabelsFromPairs("x_1", "y", "a b", "c", "", "").String())
+
+	var empty log.Labels
+	assert.Equal(t, "{}", empty.String())
+	assert.True(t, empty.Equal(log.NewLabels(nil)))
+}
+
+func TestLabelsJSON(t *testing.T) {
+	ls := log.LabelsFromPairs("env", "prod", "app", "shop")
+	data, err := json.Marshal(ls)
+	require.NoError(t, err)
+	assert.Equal(t, `{"app":"shop","env":"prod"}`, string(data))
+
+	var got log.Labels
+	require.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, ls, got)
+}
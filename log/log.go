@@ -46,20 +46,24 @@ import (
 type Event struct {
 	Offset        uint64    // byte offset in file at which event starts
 	OffsetEnd     uint64    // byte offset in file at which event ends
-	Ts            time.Time // timestamp of event
+	Ts            time.Time // timestamp of event, e.g. when the query finished (slow log)
 	Admin         bool      // true if Query is admin command
 	Query         string    // SQL query or admin command
 	User          string
 	Host          string
 	Db            string
 	Server        string
-	LabelsKey     []string
-	LabelsValue   []string
+	Labels        Labels             // e.g. application or endpoint that executed the query
 	TimeMetrics   map[string]float64 // *_time and *_wait metrics
 	NumberMetrics map[string]uint64  // most metrics
 	BoolMetrics   map[string]bool    // yes/no metrics
 	RateType      string             // Percona Server rate limit type
 	RateLimit     uint               // Percona Server rate limit value
+	// Deprecated: Use Labels. LabelsKey and LabelsValue are the keys and
+	// values of Labels, set by the parsers that set Labels. Events with
+	// LabelsKey and LabelsValue but no Labels are aggregated with those labels.
+	LabelsKey   []string
+	LabelsValue []string
 }
 
 // NewEvent returns a new Event with initialized metric maps.
@@ -71,6 +75,16 @@ func NewEvent() *Event {
 	return event
 }
 
+// StartTs returns the time at which the query started: Ts minus Query_time.
+// Slow logs record when queries finish, so Ts is the completion time. StartTs
+// returns Ts if there is no Query_time, and the zero time if Ts is zero.
+func (e *Event) StartTs() time.Time {
+	if e.Ts.IsZero() {
+		return e.Ts
+	}
+	return e.Ts.Add(-time.Duration(e.TimeMetrics["Query_time"] * float64(time.Second)))
+}
+
 // Options encapsulate common options for making a new LogParser.
 type Options struct {
 	StartOffset        uint64                                // byte offset in file at which to start parsing
@@ -78,6 +92,7 @@ type Options struct {
 	Debug              bool                                  // print trace info to STDERR with standard library logger
 	Debugf             func(format string, v ...interface{}) // use this function for logging instead of log.Printf (Debug still should be true)
 	DefaultLocation    *time.Location                        // DefaultLocation to assume for logs in MySQL < 5.7 format.
+	CommentLabels      bool                                  // set Labels from sqlcommenter and marginalia comments in queries (slow log), see query.CommentLabels
 }
 
 // A LogParser sends events to a channel.
@@ -0,0 +1,315 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Package merge merges the events of several log parsers into one stream
+// ordered by timestamp. For example, the slow logs of all servers in a cluster
+// can be analyzed as one time-ordered stream of events.
+package merge
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	stdlog "log"
+	"sync"
+	"time"
+
+	"github.com/percona/go-mysql/log"
+)
+
+// A Source is a log parser and the name of the server whose log it parses.
+type Source struct {
+	Name   string // set as Event.Server unless empty
+	Parser log.LogParser
+}
+
+// A Parser merges the events of several sources ordered by Event.Ts. It
+// implements the LogParser interface.
+//
+// Logs are only roughly ordered; for example, slow log events are written when
+// queries finish. So events are buffered and reordered within a window: an
+// event is sent only when every source that has not finished has sent an event
+// at least window later than it. Events that are later than the window are
+// sent as soon as possible, so they are out of order.
+//
+// An event without a timestamp, like a slow log event without a # Time line,
+// is ordered as if it had the timestamp of the previous event of its source
+// that has one, so the events of a source stay in order. Event.Ts is not
+// changed.
+//
+// Memory use grows with the window and with how far the sources are apart in
+// time, but at most BufferLimit events of a source are buffered: when a source
+// has more, the earliest events are sent without waiting for the others, so
+// a source that stalls does not make the others buffer without limit.
+type Parser struct {
+	sources []Source
+	window  time.Duration
+	opt     log.Options
+	limit   int
+	// --
+	stopChan  chan bool
+	eventChan chan *log.Event
+	stopped   bool
+	events    eventHeap
+	buffered  []int // number of buffered events by source
+	seq       uint64
+}
+
+// NewParser returns a new Parser that merges the events of the sources.
+// The sources must not be started; Start starts them. Only the Debug and
+// Debugf options are used.
+func NewParser(sources []Source, window time.Duration, opt log.Options) *Parser {
+	p := &Parser{
+		sources: sources,
+		window:  window,
+		opt:     opt,
+		// --
+		stopChan:  make(chan bool, 1),
+		eventChan: make(chan *log.Event),
+		limit:     DefaultBufferLimit,
+	}
+	return p
+}
+
+// DefaultBufferLimit is the default maximum number of buffered events of a
+// source.
+const DefaultBufferLimit = 10000
+
+// BufferLimit sets the maximum number of buffered events of a source, see
+// Parser. Call it before Start. If n is not greater than zero, the number is
+// not limited.
+func (p *Parser) BufferLimit(n int) {
+	p.limit = n
+}
+
+// logf logs with configured logger.
+func (p *Parser) logf(format string, v ...interface{}) {
+	if !p.opt.Debug {
+		return
+	}
+	if p.opt.Debugf != nil {
+		p.opt.Debugf(format, v...)
+		return
+	}
+	stdlog.Printf(format, v...)
+}
+
+// EventChan returns the unbuffered event channel on which the caller can
+// receive events.
+func (p *Parser) EventChan() <-chan *log.Event {
+	return p.eventChan
+}
+
+// Stop stops the parser and all sources.
+func (p *Parser) Stop() {
+	p.logf("stopping")
+	p.stopChan <- true
+}
+
+// An item is an event of a source, or the end of a source if event is nil.
+type item struct {
+	source int
+	event  *log.Event
+}
+
+// Start starts all sources and merges their events. Events are sent to the
+// unbuffered event channel. Merging stops when all sources stop or on call to
+// Stop. The event channel is closed when merging stops. Errors of all sources
+// are returned, each prefixed with the source name.
+func (p *Parser) Start() error {
+	defer close(p.eventChan)
+
+	n := len(p.sources)
+	errs := make([]error, n)
+	items := make(chan item)
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, s := range p.sources {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := s.Parser.Start(); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", s.Name, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for e := range s.Parser.EventChan() {
+				select {
+				case items <- item{source: i, event: e}:
+				case <-quit:
+					// Drain so the source can stop.
+				}
+			}
+			select {
+			case items <- item{source: i}:
+			case <-quit:
+			}
+		}()
+	}
+
+	open := n
+	done := make([]bool, n)
+	seen := make([]bool, n)
+	lastTs := make([]time.Time, n) // last non-zero Ts of source
+	maxTs := make([]time.Time, n)
+	p.buffered = make([]int, n)
+	for open > 0 && !p.stopped {
+		select {
+		case it := <-items:
+			if it.event == nil {
+				p.logf("source %d done", it.source)
+				done[it.source] = true
+				open--
+			} else {
+				if name := p.sources[it.source].Name; name != "" {
+					it.event.Server = name
+				}
+				ts := it.event.Ts
+				if ts.IsZero() {
+					ts = lastTs[it.source]
+				} else {
+					lastTs[it.source] = ts
+				}
+				p.seq++
+				heap.Push(&p.events, entry{event: it.event, ts: ts, source: it.source, seq: p.seq})
+				p.buffered[it.source]++
+				if !seen[it.source] || ts.After(maxTs[it.source]) {
+					maxTs[it.source] = ts
+				}
+				seen[it.source] = true
+			}
+			if wm, ok := watermark(done, seen, maxTs, p.window); ok {
+				p.send(wm, false)
+			}
+			if it.event != nil && p.limit > 0 {
+				for p.buffered[it.source] > p.limit && !p.stopped {
+					p.sendFirst()
+				}
+			}
+		case <-p.stopChan:
+			p.stopped = true
+		}
+	}
+
+	if p.stopped {
+		close(quit)
+		for i, s := range p.sources {
+			if !done[i] {
+				s.Parser.Stop()
+			}
+		}
+	} else {
+		p.send(time.Time{}, true)
+	}
+	wg.Wait()
+
+	p.logf("done")
+	return errors.Join(errs...)
+}
+
+// watermark returns the time up to which events can be sent: the earliest
+// latest event time of all open sources minus the window. It returns false
+// if all sources are done or some open source has not sent an event yet.
+func watermark(done, seen []bool, maxTs []time.Time, window time.Duration) (time.Time, bool) {
+	var wm time.Time
+	open := false
+	for i := range done {
+		if done[i] {
+			continue
+		}
+		if !seen[i] {
+			return time.Time{}, false
+		}
+		if t := maxTs[i].Add(-window); !open || t.Before(wm) {
+			wm = t
+		}
+		open = true
+	}
+	return wm, open
+}
+
+// send sends buffered events up to and including the watermark time, or all
+// events if all is true.
+func (p *Parser) send(wm time.Time, all bool) {
+	for p.events.Len() > 0 && !p.stopped {
+		if !all && p.events[0].ts.After(wm) {
+			return
+		}
+		p.sendFirst()
+	}
+}
+
+// sendFirst sends the earliest buffered event.
+func (p *Parser) sendFirst() {
+	e := heap.Pop(&p.events).(entry)
+	p.buffered[e.source]--
+	select {
+	case p.eventChan <- e.event:
+	case <-p.stopChan:
+		p.stopped = true
+	}
+}
+
+// --------------------------------------------------------------------------
+
+// An entry is a buffered event. ts is the time by which it is ordered, and
+// seq keeps events with equal times in the order in which they were received.
+type entry struct {
+	event  *log.Event
+	ts     time.Time
+	source int
+	seq    uint64
+}
+
+// eventHeap is a min-heap of events ordered by timestamp.
+type eventHeap []entry
+
+func (h eventHeap) Len() int      { return len(h) }
+func (h eventHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h eventHeap) Less(i, j int) bool {
+	if h[i].ts.Equal(h[j].ts) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].ts.Before(h[j].ts)
+}
+
+func (h *eventHeap) Push(x interface{}) {
+	*h = append(*h, x.(entry))
+}
+
+func (h *eventHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = entry{}
+	*h = old[:n-1]
+	return e
+}
@@ -0,0 +1,253 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package merge_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/go-mysql/log"
+	"github.com/percona/go-mysql/log/jsonl"
+	"github.com/percona/go-mysql/log/merge"
+	parser "github.com/percona/go-mysql/log/slow"
+	"github.com/percona/go-mysql/test"
+)
+
+var t0 = time.Date(2023, 11, 14, 22, 0, 0, 0, time.UTC)
+
+// source returns a source of events with the queries, each at t0 plus the
+// number of seconds in the query.
+func source(name string, seconds ...int) merge.Source {
+	var b strings.Builder
+	for _, s := range seconds {
+		fmt.Fprintf(&b, `{"v":1,"ts":%q,"query":"%s %d"}`+"\n", t0.Add(time.Duration(s)*time.Second).Format(time.RFC3339Nano), name, s)
+	}
+	return merge.Source{
+		Name:   name,
+		Parser: jsonl.NewDecoder(strings.NewReader(b.String()), log.Options{}),
+	}
+}
+
+func run(p *merge.Parser) ([]string, error) {
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- p.Start()
+	}()
+	var got []string
+	for e := range p.EventChan() {
+		got = append(got, e.Server+": "+e.Query)
+	}
+	return got, <-errChan
+}
+
+// --------------------------------------------------------------------------
+
+func TestMerge(t *testing.T) {
+	p := merge.NewParser([]merge.Source{
+		source("db1", 1, 4, 3, 10),
+		source("db2", 2, 2, 9),
+		source("db3", 5, 6, 8, 7),
+	}, 2*time.Second, log.Options{})
+	got, err := run(p)
+	require.NoError(t, err)
+	expect := []string{
+		"db1: db1 1",
+		"db2: db2 2",
+		"db2: db2 2",
+		"db1: db1 3",
+		"db1: db1 4",
+		"db3: db3 5",
+		"db3: db3 6",
+		"db3: db3 7",
+		"db3: db3 8",
+		"db2: db2 9",
+		"db1: db1 10",
+	}
+	assert.Equal(t, expect, got)
+}
+
+// Events later than the window are sent as soon as possible.
+func TestMergeLateEvent(t *testing.T) {
+	p := merge.NewParser([]merge.Source{
+		source("db1", 1, 5, 10, 2, 11),
+	}, time.Second, log.Options{})
+	got, err := run(p)
+	require.NoError(t, err)
+	expect := []string{
+		"db1: db1 1",
+		"db1: db1 5",
+		"db1: db1 2",
+		"db1: db1 10",
+		"db1: db1 11",
+	}
+	assert.Equal(t, expect, got)
+}
+
+func TestMergeErrors(t *testing.T) {
+	bad := merge.Source{
+		Name:   "db2",
+		Parser: jsonl.NewDecoder(strings.NewReader(`{"v":1,"query":"ok"}`+"\n"+`{"v":9}`+"\n"), log.Options{}),
+	}
+	p := merge.NewParser([]merge.Source{source("db1", 1, 2), bad}, time.Second, log.Options{})
+	got, err := run(p)
+	assert.EqualError(t, err, "db2: line at offset 21: unsupported version 9")
+	assert.Equal(t, []string{"db2: ok", "db1: db1 1", "db1: db1 2"}, got)
+}
+
+func TestMergeStop(t *testing.T) {
+	p := merge.NewParser([]merge.Source{
+		source("db1", 1, 2, 3, 4, 5, 6),
+		source("db2", 1, 2, 3, 4, 5, 6),
+	}, 0, log.Options{})
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- p.Start()
+	}()
+	e := <-p.EventChan()
+	assert.Equal(t, t0.Add(time.Second), e.Ts)
+	p.Stop()
+	for range p.EventChan() {
+	}
+	assert.NoError(t, <-errChan)
+}
+
+// Slow logs of several servers are merged into one stream.
+func TestMergeSlowLogs(t *testing.T) {
+	sample := filepath.Join(test.RootDir(), "test/slow-logs")
+	opt := log.Options{DefaultLocation: time.UTC}
+	var sources []merge.Source
+	for _, name := range []string{"slow001.log", "slow006.log", "slow013.log"} {
+		file, err := os.Open(filepath.Join(sample, name))
+		require.NoError(t, err)
+		defer file.Close()
+		sources = append(sources, merge.Source{Name: name, Parser: parser.NewSlowLogParser(file, opt)})
+	}
+	p := merge.NewParser(sources, time.Minute, log.Options{})
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- p.Start()
+	}()
+	var got []*log.Event
+	servers := map[string]int{}
+	for e := range p.EventChan() {
+		got = append(got, e)
+		servers[e.Server]++
+	}
+	require.NoError(t, <-errChan)
+	assert.Equal(t, map[string]int{"slow001.log": 2, "slow006.log": 6, "slow013.log": 5}, servers)
+	for i := 1; i < len(got); i++ {
+		assert.False(t, got[i].Ts.Before(got[i-1].Ts), "event %d is out of order", i)
+	}
+}
+
+// Events without a timestamp keep their order in their source: slow002 has a
+// # Time line only before its first event.
+func TestMergeZeroTs(t *testing.T) {
+	sample := filepath.Join(test.RootDir(), "test/slow-logs")
+	opt := log.Options{DefaultLocation: time.UTC}
+	var sources []merge.Source
+	for _, name := range []string{"slow002.log", "slow001.log"} {
+		file, err := os.Open(filepath.Join(sample, name))
+		require.NoError(t, err)
+		defer file.Close()
+		sources = append(sources, merge.Source{Name: name, Parser: parser.NewSlowLogParser(file, opt)})
+	}
+	p := merge.NewParser(sources, time.Minute, log.Options{})
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- p.Start()
+	}()
+	var got []*log.Event
+	for e := range p.EventChan() {
+		got = append(got, e)
+	}
+	require.NoError(t, <-errChan)
+	require.Len(t, got, 10)
+
+	// slow001 is two months earlier than slow002.
+	var servers []string
+	for _, e := range got {
+		if len(servers) == 0 || servers[len(servers)-1] != e.Server {
+			servers = append(servers, e.Server)
+		}
+	}
+	assert.Equal(t, []string{"slow001.log", "slow002.log"}, servers)
+	for i := 3; i < len(got); i++ {
+		assert.True(t, got[i].Ts.IsZero())
+		assert.Greater(t, got[i].Offset, got[i-1].Offset, "event %d is out of order", i)
+	}
+}
+
+// stalled is a source that sends no events until it is stopped.
+type stalled struct {
+	stop      chan struct{}
+	eventChan chan *log.Event
+}
+
+func (s *stalled) Start() error {
+	<-s.stop
+	close(s.eventChan)
+	return nil
+}
+
+func (s *stalled) Stop()                        { close(s.stop) }
+func (s *stalled) EventChan() <-chan *log.Event { return s.eventChan }
+
+// A source that stalls does not make the others buffer without limit.
+func TestMergeBufferLimit(t *testing.T) {
+	s := &stalled{stop: make(chan struct{}), eventChan: make(chan *log.Event)}
+	p := merge.NewParser([]merge.Source{
+		source("db1", 1, 2, 3, 4, 5, 6),
+		{Name: "db2", Parser: s},
+	}, 0, log.Options{})
+	p.BufferLimit(2)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- p.Start()
+	}()
+	var got []string
+	for i := 0; i < 4; i++ {
+		e := <-p.EventChan()
+		got = append(got, e.Query)
+	}
+	assert.Equal(t, []string{"db1 1", "db1 2", "db1 3", "db1 4"}, got)
+	p.Stop()
+	for range p.EventChan() {
+	}
+	assert.NoError(t, <-errChan)
+}
@@ -36,6 +36,7 @@ import (
 	"fmt"
 	"io"
 	stdlog "log"
+	"math"
 	"os"
 	"regexp"
 	"strconv"
@@ -43,6 +44,7 @@ import (
 	"time"
 
 	"github.com/percona/go-mysql/log"
+	"github.com/percona/go-mysql/query"
 )
 
 // Regular expressions to match important lines in slow log.
@@ -55,12 +57,14 @@ var (
 	metricsRe = regexp.MustCompile(`(\w+): (\S+|\z)`)
 	adminRe   = regexp.MustCompile(`command: (.+)`)
 	setRe     = regexp.MustCompile(`^SET (?:last_insert_id|insert_id|timestamp)`)
+	setTsRe   = regexp.MustCompile(`^SET timestamp=(\d+)`)
 	useRe     = regexp.MustCompile(`^(?i)use `)
 )
 
 // A SlowLogParser parses a MySQL slow log. It implements the LogParser interface.
 type SlowLogParser struct {
-	file *os.File
+	r    io.Reader
+	name string
 	opt  log.Options
 	// --
 	stopChan    chan bool
@@ -74,16 +78,28 @@ type SlowLogParser struct {
 	endOffset   uint64
 	stopped     bool
 	event       *log.Event
+	localTs     bool  // event.Ts is in the old format without time zone
+	setTs       int64 // SET timestamp value, if any
+	tzDetected  bool  // tzOffset is known and DefaultLocation is wrong
+	tzOffset    int   // detected time zone offset in seconds east of UTC
 }
 
 // NewSlowLogParser returns a new SlowLogParser that reads from the open file.
 func NewSlowLogParser(file *os.File, opt log.Options) *SlowLogParser {
+	return NewSlowLogReaderParser(file, file.Name(), opt)
+}
+
+// NewSlowLogReaderParser returns a new SlowLogParser that reads from r. The name
+// is only used for logging. If r does not implement io.Seeker, opt.StartOffset
+// bytes are read and discarded instead of seeking.
+func NewSlowLogReaderParser(r io.Reader, name string, opt log.Options) *SlowLogParser {
 	if opt.DefaultLocation == nil {
 		// Old MySQL format assumes time is taken from SYSTEM.
 		opt.DefaultLocation = time.Local
 	}
 	p := &SlowLogParser{
-		file: file,
+		r:    r,
+		name: name,
 		opt:  opt,
 		// --
 		stopChan:    make(chan bool, 1),
@@ -129,19 +145,23 @@ func (p *SlowLogParser) Stop() {
 // Parsing stops on EOF, error, or call to Stop. The event channel is closed
 // when parsing stops. The file is not closed.
 func (p *SlowLogParser) Start() error {
-	p.logf("parsing %q", p.file.Name())
+	p.logf("parsing %q", p.name)
+
+	defer close(p.eventChan)
 
 	// Seek to the offset, if any.
 	// @todo error if start off > file size
 	if p.opt.StartOffset > 0 {
-		if _, err := p.file.Seek(int64(p.opt.StartOffset), os.SEEK_SET); err != nil {
+		if s, ok := p.r.(io.Seeker); ok {
+			if _, err := s.Seek(int64(p.opt.StartOffset), io.SeekStart); err != nil {
+				return err
+			}
+		} else if _, err := io.CopyN(io.Discard, p.r, int64(p.opt.StartOffset)); err != nil {
 			return err
 		}
 	}
 
-	defer close(p.eventChan)
-
-	r := bufio.NewReader(p.file)
+	r := bufio.NewReader(p.r)
 
 SCANNER_LOOP:
 	for !p.stopped {
@@ -227,6 +247,7 @@ func (p *SlowLogParser) parseHeader(line string) {
 		m := timeRe.FindStringSubmatch(line)
 		if len(m) == 2 {
 			p.event.Ts, _ = time.ParseInLocation("060102 15:04:05", m[1], p.opt.DefaultLocation)
+			p.localTs = true
 		} else {
 			m = timeNewRe.FindStringSubmatch(line)
 			if len(m) == 2 {
@@ -319,7 +340,9 @@ func (p *SlowLogParser) parseQuery(line string) {
 		p.event.Query = line
 	} else if setRe.MatchString(line) {
 		p.logf("set var")
-		// @todo ignore or use these lines?
+		if m := setTsRe.FindStringSubmatch(line); len(m) == 2 {
+			p.setTs, _ = strconv.ParseInt(m[1], 10, 64)
+		}
 	} else {
 		p.logf("query")
 		if p.queryLines > 0 {
@@ -359,6 +382,8 @@ func (p *SlowLogParser) sendEvent(inHeader bool, inQuery bool) {
 		p.event = log.NewEvent()
 		p.headerLines = 0
 		p.queryLines = 0
+		p.localTs = false
+		p.setTs = 0
 		p.inHeader = inHeader
 		p.inQuery = inQuery
 	}()
@@ -371,9 +396,20 @@ func (p *SlowLogParser) sendEvent(inHeader bool, inQuery bool) {
 		return
 	}
 
+	if p.localTs {
+		p.fixTimeZone()
+	}
+
 	// Clean up the event.
 	p.event.Db = strings.TrimSuffix(p.event.Db, ";\n")
 	p.event.Query = strings.TrimSuffix(p.event.Query, ";")
+	if p.opt.CommentLabels && !p.event.Admin {
+		for _, l := range query.CommentLabels(p.event.Query) {
+			p.event.Labels = p.event.Labels.Set(l.Key, l.Value)
+		}
+		p.event.LabelsKey = p.event.Labels.Keys()
+		p.event.LabelsValue = p.event.Labels.Values()
+	}
 
 	// Send the event.  This will block.
 	select {
@@ -382,3 +418,66 @@ func (p *SlowLogParser) sendEvent(inHeader bool, inQuery bool) {
 		p.stopped = true
 	}
 }
+
+// fixTimeZone corrects the time zone of an event timestamp in the old format
+// (MySQL < 5.7), which is server local time without time zone. The time zone
+// offset is detected by comparing the timestamp with the SET timestamp value,
+// which is seconds since the Unix epoch. If the event has no SET timestamp,
+// the last detected offset is used. If no offset was detected or it matches
+// DefaultLocation, DefaultLocation is used. This handles DST transitions: an
+// offset is detected for every event that has SET timestamp, and if it matches
+// DefaultLocation the timestamp is in that location.
+func (p *SlowLogParser) fixTimeZone() {
+	ts := p.event.Ts
+	wall := time.Date(ts.Year(), ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), ts.Nanosecond(), time.UTC)
+
+	if p.setTs > 0 {
+		offset, ok := detectOffset(wall.Unix()-p.setTs, p.event.TimeMetrics["Query_time"])
+		if !ok {
+			p.logf("cannot detect time zone")
+			return
+		}
+		instant := wall.Add(-time.Duration(offset) * time.Second)
+		if _, defaultOffset := instant.In(p.opt.DefaultLocation).Zone(); defaultOffset == offset {
+			p.tzDetected = false
+			p.event.Ts = instant.In(p.opt.DefaultLocation)
+			return
+		}
+		p.logf("detected time zone offset %d", offset)
+		p.tzDetected = true
+		p.tzOffset = offset
+	}
+
+	if p.tzDetected {
+		p.event.Ts = wall.Add(-time.Duration(p.tzOffset) * time.Second).In(time.FixedZone("", p.tzOffset))
+	}
+}
+
+// detectOffset returns the time zone offset in seconds east of UTC given the
+// difference between local time and UTC of an event, in seconds. The local time
+// is when the query finished, but SET timestamp can be when it started, so the
+// difference may be off by the query time. Time zone offsets are multiples of
+// 15 minutes and at most 14 hours. It returns false if the difference is more
+// than a few seconds beyond the query time from any offset, e.g. if SET
+// timestamp is stale.
+func detectOffset(diff int64, queryTime float64) (int, bool) {
+	const (
+		quarter = 15 * 60
+		maxSkew = 5 // seconds
+	)
+	round := func(d float64) (int64, float64) {
+		n := int64(math.Round(d / quarter))
+		return n * quarter, math.Abs(d - float64(n*quarter))
+	}
+	offset, residual := round(float64(diff))
+	if o, r := round(float64(diff) - queryTime); r < residual {
+		offset, residual = o, r
+	}
+	if residual > queryTime+maxSkew {
+		return 0, false
+	}
+	if offset < -14*3600 || offset > 14*3600 {
+		return 0, false
+	}
+	return int(offset), true
+}
@@ -31,11 +31,14 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package slow_test
 
 import (
+	"io"
 	l "log"
 	"os"
 	"path"
+	"strings"
 	"testing"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -958,6 +961,7 @@ func TestParserSlowLog011(t *testing.T) {
 	assert.EqualValues(t, expect, got)
 }
 
+// slow012 was written in UTC-7, which is detected from SET timestamp.
 func TestParserSlowLog012(t *testing.T) {
 	got := parseSlowLog(t, "slow012.log", opt)
 	expect := []log.Event{
@@ -1003,7 +1007,7 @@ func TestParserSlowLog012(t *testing.T) {
 			User:      "msandbox",
 			Offset:    375,
 			OffsetEnd: 609,
-			Ts:        time.Date(2014, 4, 13, 19, 34, 13, 0, time.UTC),
+			Ts:        time.Date(2014, 4, 13, 19, 34, 13, 0, time.FixedZone("", -7*3600)),
 			TimeMetrics: map[string]float64{
 				"Query_time": 0.000127,
 				"Lock_time":  0.000000,
@@ -1905,3 +1909,76 @@ func TestParseSlow026(t *testing.T) {
 	}
 	assert.EqualValues(t, expect, got)
 }
+
+// Readers that cannot seek skip StartOffset bytes instead.
+func TestParserSlowLog001StartOffsetReader(t *testing.T) {
+	data, err := os.ReadFile(path.Join(sample, "slow001.log"))
+	require.NoError(t, err)
+	o := opt
+	o.StartOffset = 358
+	p := parser.NewSlowLogReaderParser(io.MultiReader(strings.NewReader(string(data))), "slow001.log", o)
+	go p.Start()
+	got := []log.Event{}
+	for e := range p.EventChan() {
+		got = append(got, *e)
+	}
+	require.Len(t, got, 1)
+	assert.Equal(t, "select sleep(2) from test.n", got[0].Query)
+	assert.Equal(t, uint64(358), got[0].Offset)
+}
+
+// slow028 has the same local time twice when DST ends in America/New_York.
+// SET timestamp tells which is which.
+func TestParseSlow028(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	o := opt
+	o.DefaultLocation = newYork
+	got := parseSlowLog(t, "slow028.log", o)
+	require.Len(t, got, 2)
+	assert.Equal(t, time.Date(2023, 11, 5, 5, 30, 0, 0, time.UTC), got[0].Ts.UTC())
+	assert.Equal(t, time.Date(2023, 11, 5, 6, 30, 0, 0, time.UTC), got[1].Ts.UTC())
+	assert.Equal(t, newYork, got[0].Ts.Location())
+	assert.Equal(t, newYork, got[1].Ts.Location())
+	assert.Equal(t, time.Date(2023, 11, 5, 5, 29, 58, 0, time.UTC), got[0].StartTs().UTC())
+}
+
+// slow029 was written in UTC+5:30, not in DefaultLocation. The second event
+// has no SET timestamp, so the offset detected for the first event is used.
+func TestParseSlow029(t *testing.T) {
+	got := parseSlowLog(t, "slow029.log", opt)
+	require.Len(t, got, 2)
+	india := time.FixedZone("", 5*3600+30*60)
+	assert.Equal(t, time.Date(2023, 11, 14, 10, 0, 0, 0, india), got[0].Ts)
+	assert.Equal(t, time.Date(2023, 11, 14, 9, 50, 0, 0, india), got[0].StartTs())
+	assert.Equal(t, time.Date(2023, 11, 14, 10, 0, 5, 0, india), got[1].Ts)
+	assert.Equal(t, time.Date(2023, 11, 14, 10, 0, 4, 500000000, india), got[1].StartTs())
+}
+
+// slow030 has queries with sqlcommenter and marginalia comments.
+func TestParseSlow030(t *testing.T) {
+	got := parseSlowLog(t, "slow030.log", opt)
+	require.Len(t, got, 3)
+	assert.Nil(t, got[0].Labels)
+
+	o := opt
+	o.CommentLabels = true
+	got = parseSlowLog(t, "slow030.log", o)
+	require.Len(t, got, 3)
+	assert.Equal(t, log.LabelsFromPairs(
+		"action", "show",
+		"controller", "orders",
+		"traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	), got[0].Labels)
+	assert.Equal(t, log.LabelsFromPairs("action", "update", "application", "shop", "controller", "carts"), got[1].Labels)
+	assert.Nil(t, got[2].Labels)
+	assert.Equal(t, "Quit", got[2].Query)
+}
+
+// slow031 has a stale SET timestamp, 1 hour 16 minutes and 40 seconds before
+// the event, which is not a time zone offset. DefaultLocation is used.
+func TestParseSlow031(t *testing.T) {
+	got := parseSlowLog(t, "slow031.log", opt)
+	require.Len(t, got, 1)
+	assert.Equal(t, time.Date(2023, 11, 14, 10, 0, 0, 0, time.UTC), got[0].Ts)
+}
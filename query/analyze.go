@@ -0,0 +1,354 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query
+
+import (
+	"sort"
+	"strings"
+)
+
+// A Severity is how bad an anti-pattern is.
+type Severity string
+
+const (
+	SeverityNote     Severity = "note"     // can be a problem, depending on the data and schema
+	SeverityWarning  Severity = "warning"  // usually a problem
+	SeverityCritical Severity = "critical" // almost always a problem
+)
+
+// An Advice is an anti-pattern found in a query by Analyze.
+type Advice struct {
+	Rule     string // rule ID, like COL.001
+	Severity Severity
+	Start    int    // byte offset of the anti-pattern in the query
+	End      int    // byte offset after the anti-pattern in the query
+	Message  string // what the rule flags
+}
+
+// Rule IDs of Analyze. IDs that pt-query-advisor had are the same.
+const (
+	RuleSelectStar       = "COL.001" // SELECT *
+	RuleBlindInsert      = "COL.002" // INSERT without column list
+	RuleLeadingWildcard  = "ARG.001" // LIKE '%x'
+	RuleFunctionOnColumn = "ARG.003" // function on column in WHERE, like DATE(c) = ?
+	RuleHugeInList       = "ARG.004" // IN list longer than AnalyzeOptions.MaxInList
+	RuleOrderByRand      = "CLA.002" // ORDER BY RAND()
+	RuleNoWhere          = "CLA.008" // DELETE or UPDATE without WHERE
+	RuleImplicitJoin     = "JOI.005" // FROM a, b
+	RuleNotInSubquery    = "SUB.002" // NOT IN (SELECT ...)
+)
+
+// DefaultMaxInList is the default AnalyzeOptions.MaxInList.
+const DefaultMaxInList = 1000
+
+var ruleSeverity = map[string]Severity{
+	RuleSelectStar:       SeverityNote,
+	RuleBlindInsert:      SeverityNote,
+	RuleLeadingWildcard:  SeverityWarning,
+	RuleFunctionOnColumn: SeverityWarning,
+	RuleHugeInList:       SeverityWarning,
+	RuleOrderByRand:      SeverityWarning,
+	RuleNoWhere:          SeverityCritical,
+	RuleImplicitJoin:     SeverityNote,
+	RuleNotInSubquery:    SeverityWarning,
+}
+
+var ruleMessage = map[string]string{
+	RuleSelectStar:       "SELECT * selects all columns, including columns added later; list the columns",
+	RuleBlindInsert:      "INSERT without a column list breaks when columns are added; list the columns",
+	RuleLeadingWildcard:  "LIKE with a leading wildcard cannot use an index",
+	RuleFunctionOnColumn: "a function on a column in WHERE cannot use an index on the column",
+	RuleHugeInList:       "a huge IN list is slow to parse and optimize; use a temporary table or a join",
+	RuleOrderByRand:      "ORDER BY RAND() sorts all rows to return a few",
+	RuleNoWhere:          "DELETE or UPDATE without WHERE changes all rows",
+	RuleImplicitJoin:     "FROM a, b is a cross join unless WHERE joins the tables; use JOIN with ON",
+	RuleNotInSubquery:    "NOT IN with a subquery returns no rows if the subquery returns NULL; use NOT EXISTS",
+}
+
+// AnalyzeOptions are options for Analyze.
+type AnalyzeOptions struct {
+	// MaxInList is the number of values in an IN list above which it is
+	// huge. Zero means DefaultMaxInList.
+	MaxInList int
+}
+
+// Analyze returns the anti-patterns in q, ordered by offset, like
+// pt-query-advisor. The rules are the Rule constants. Like Fingerprint, it
+// does not parse SQL, so it never fails, but it can miss anti-patterns in
+// unusual queries. Analyze the original query, not its fingerprint: values
+// like LIKE patterns and IN lists matter.
+func Analyze(q string, opt AnalyzeOptions) []Advice {
+	if opt.MaxInList == 0 {
+		opt.MaxInList = DefaultMaxInList
+	}
+	a := analyzer{q: q, opt: opt}
+	l := Lexer{q: q}
+	for t := l.NextSignificant(); t.Type != TokenEOF; t = l.NextSignificant() {
+		a.tokens = append(a.tokens, t)
+	}
+	a.matchParens()
+	a.run()
+	sort.SliceStable(a.advice, func(i, j int) bool { return a.advice[i].Start < a.advice[j].Start })
+	return a.advice
+}
+
+// analyzer holds the state of one Analyze call.
+type analyzer struct {
+	q      string
+	opt    AnalyzeOptions
+	tokens []Token // significant tokens
+	advice []Advice
+	close  []int // index of matching ")" of "(" tokens, or the last token
+	commas []int // number of commas directly in "(" tokens
+}
+
+// A scope is a statement or parenthesized expression.
+type analyzeScope struct {
+	clause string // current clause, like where or from
+	from   bool   // in FROM, including the ON and USING of its joins
+	exists bool   // in EXISTS (...)
+}
+
+// clauses are the keywords that begin clauses.
+var clauses = map[string]bool{
+	"select": true, "from": true, "where": true, "group": true, "having": true, "order": true,
+	"limit": true, "set": true, "values": true, "value": true, "on": true, "using": true,
+	"union": true, "for": true, "into": true, "update": true, "delete": true, "insert": true,
+	"replace": true, "join": true, "straight_join": true, "window": true,
+}
+
+// fromClauses are the clauses that are part of FROM.
+var fromClauses = map[string]bool{
+	"from": true, "join": true, "straight_join": true, "on": true, "using": true,
+}
+
+// keywordFunctions are functions with keyword names, like LEFT(c, 3).
+var keywordFunctions = map[string]bool{
+	"char": true, "convert": true, "database": true, "if": true, "insert": true, "left": true,
+	"mod": true, "repeat": true, "replace": true, "right": true, "schema": true, "truncate": true,
+}
+
+func (a *analyzer) add(rule string, start, end int) {
+	a.advice = append(a.advice, Advice{
+		Rule:     rule,
+		Severity: ruleSeverity[rule],
+		Start:    start,
+		End:      end,
+		Message:  ruleMessage[rule],
+	})
+}
+
+// tok returns the significant token i, or an EOF token.
+func (a *analyzer) tok(i int) Token {
+	if i < 0 || i >= len(a.tokens) {
+		return Token{Type: TokenEOF, Start: len(a.q), End: len(a.q)}
+	}
+	return a.tokens[i]
+}
+
+// matchParens sets close and commas of all "(" tokens in one pass, so
+// unclosed and deeply nested parentheses are not scanned again for each "(".
+func (a *analyzer) matchParens() {
+	a.close = make([]int, len(a.tokens))
+	a.commas = make([]int, len(a.tokens))
+	var open []int
+	for i, t := range a.tokens {
+		switch t.Text {
+		case "(":
+			open = append(open, i)
+		case ")":
+			if len(open) > 0 {
+				a.close[open[len(open)-1]] = i
+				open = open[:len(open)-1]
+			}
+		case ",":
+			if len(open) > 0 && i < len(a.tokens)-1 {
+				a.commas[open[len(open)-1]]++
+			}
+		}
+	}
+	for _, i := range open {
+		a.close[i] = len(a.tokens) - 1
+	}
+}
+
+// closeParen returns the index of the ")" that matches the "(" at i, or the
+// last token if there is none.
+func (a *analyzer) closeParen(i int) int {
+	return a.close[i]
+}
+
+func (a *analyzer) run() {
+	if len(a.tokens) == 0 {
+		return
+	}
+	first := a.tok(a.skipWith())
+	scopes := []analyzeScope{{}}
+	where := false // top-level WHERE
+	for i, t := range a.tokens {
+		sc := &scopes[len(scopes)-1]
+		switch {
+		case t.Text == "(" && t.Type == TokenOperator:
+			scopes = append(scopes, analyzeScope{exists: a.tok(i - 1).Is("exists")})
+			continue
+		case t.Text == ")" && t.Type == TokenOperator:
+			if len(scopes) > 1 {
+				scopes = scopes[:len(scopes)-1]
+			}
+			continue
+		case t.Type == TokenKeyword && clauses[strings.ToLower(t.Text)]:
+			sc.clause = strings.ToLower(t.Text)
+			sc.from = sc.clause == "from" || sc.from && fromClauses[sc.clause]
+			if sc.clause == "where" && len(scopes) == 1 {
+				where = true
+			}
+		}
+
+		switch {
+		case t.Text == "*" && t.Type == TokenOperator:
+			// SELECT *, SELECT t.*, but not COUNT(*) or a * b
+			prev := a.tok(i - 1)
+			if sc.clause == "select" && !sc.exists &&
+				(prev.Is("select") || prev.Is("distinct") || prev.Text == "," || prev.Text == ".") {
+				a.add(RuleSelectStar, t.Start, t.End)
+			}
+		case t.Is("like"):
+			if p := a.tok(i + 1); p.Type == TokenString {
+				v := stringValue(p.Text)
+				if len(v) > 0 && (v[0] == '%' || v[0] == '_') {
+					a.add(RuleLeadingWildcard, p.Start, p.End)
+				}
+			}
+		case t.Is("in") && a.tok(i+1).Text == "(":
+			end := a.closeParen(i + 1)
+			start := t
+			if a.tok(i - 1).Is("not") {
+				start = a.tok(i - 1)
+			}
+			if isSubquery(a.tok(i + 2)) {
+				if start.Is("not") {
+					a.add(RuleNotInSubquery, start.Start, a.tok(end).End)
+				}
+			} else if end > i+2 && a.commas[i+1]+1 > a.opt.MaxInList {
+				a.add(RuleHugeInList, start.Start, a.tok(end).End)
+			}
+		case t.Text == "," && (sc.from || sc.clause == "update" && len(scopes) == 1 && first.Is("update")):
+			a.add(RuleImplicitJoin, t.Start, t.End)
+		case (t.Type == TokenIdent || keywordFunctions[strings.ToLower(t.Text)]) && a.tok(i+1).Text == "(":
+			end := a.closeParen(i + 1)
+			switch {
+			case sc.clause == "order" && t.Is("rand"):
+				a.add(RuleOrderByRand, t.Start, a.tok(end).End)
+			case sc.clause == "where" && a.isColumn(i+2) && (isComparison(a.tok(i-1)) || isComparison(a.tok(end+1))):
+				a.add(RuleFunctionOnColumn, t.Start, a.tok(end).End)
+			}
+		}
+	}
+
+	switch {
+	case (first.Is("delete") || first.Is("update")) && !where:
+		a.add(RuleNoWhere, first.Start, a.tokens[len(a.tokens)-1].End)
+	case first.Is("insert") || first.Is("replace"):
+		a.blindInsert()
+	}
+}
+
+// skipWith returns the index of the first token after the common table
+// expressions of WITH, like DELETE in WITH c AS (...) DELETE ..., or 0 if the
+// query does not begin with WITH.
+func (a *analyzer) skipWith() int {
+	if !a.tok(0).Is("with") {
+		return 0
+	}
+	i := 1
+	if a.tok(i).Is("recursive") {
+		i++
+	}
+	for i < len(a.tokens) {
+		i++ // name
+		if a.tok(i).Text == "(" {
+			i = a.closeParen(i) + 1 // column list
+		}
+		if !a.tok(i).Is("as") || a.tok(i+1).Text != "(" {
+			return i
+		}
+		i = a.closeParen(i+1) + 1
+		if a.tok(i).Text != "," {
+			return i
+		}
+		i++
+	}
+	return i
+}
+
+// isColumn returns true if the tokens at i are a column and the end of a
+// function argument, like c or t.c in DATE(t.c).
+func (a *analyzer) isColumn(i int) bool {
+	if t := a.tok(i); t.Type != TokenIdent && t.Type != TokenQuotedIdent {
+		return false
+	}
+	for a.tok(i+1).Text == "." {
+		i += 2
+	}
+	next := a.tok(i + 1)
+	return next.Text == ")" || next.Text == ","
+}
+
+// blindInsert adds RuleBlindInsert if the INSERT has no column list, like
+// INSERT INTO t VALUES (1).
+func (a *analyzer) blindInsert() {
+	i := 1
+	for a.tok(i).Is("low_priority") || a.tok(i).Is("delayed") || a.tok(i).Is("high_priority") ||
+		a.tok(i).Is("ignore") || a.tok(i).Is("into") {
+		i++
+	}
+	// db.t
+	i++
+	for a.tok(i).Text == "." {
+		i += 2
+	}
+	next := a.tok(i)
+	if next.Is("values") || next.Is("value") || next.Is("select") ||
+		next.Text == "(" && isSubquery(a.tok(i+1)) {
+		a.add(RuleBlindInsert, a.tokens[0].Start, a.tok(i-1).End)
+	}
+}
+
+func isComparison(t Token) bool {
+	if t.Type != TokenOperator {
+		return t.Is("like")
+	}
+	switch t.Text {
+	case "=", "<=>", "!=", "<>", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/go-mysql/query"
+)
+
+func TestAnalyze(t *testing.T) {
+	// Each advice is the rule ID and the text of its span.
+	tests := []struct {
+		query  string
+		advice []string
+	}{
+		{
+			query: "SELECT * FROM a, b WHERE a.x LIKE '%foo' AND DATE(a.created) = '2020-01-01' " +
+				"AND b.id NOT IN (SELECT id FROM c) ORDER BY RAND() LIMIT 1",
+			advice: []string{
+				"COL.001 *",
+				"JOI.005 ,",
+				"ARG.001 '%foo'",
+				"ARG.003 DATE(a.created)",
+				"SUB.002 NOT IN (SELECT id FROM c)",
+				"CLA.002 RAND()",
+			},
+		},
+		{
+			// COUNT(*), multiplication, and EXISTS (SELECT *) are not SELECT *.
+			query:  "select count(*), t.*, a * b from t where exists (select * from u) and id in (select id from v)",
+			advice: []string{"COL.001 *"},
+		},
+		{
+			query:  "select a from t where lower(email) = ? and ? < year(t.d) and abs(a - b) > 1 and c like 'x%'",
+			advice: []string{"ARG.003 lower(email)", "ARG.003 year(t.d)"},
+		},
+		{
+			query:  "UPDATE t SET a = 1",
+			advice: []string{"CLA.008 UPDATE t SET a = 1"},
+		},
+		{
+			query:  "delete from t order by id limit 10",
+			advice: []string{"CLA.008 delete from t order by id limit 10"},
+		},
+		{
+			query:  "delete from t where id = 1",
+			advice: nil,
+		},
+		{
+			query:  "update a, b set a.x = b.x where a.id = b.id",
+			advice: []string{"JOI.005 ,"},
+		},
+		{
+			query:  "INSERT IGNORE INTO db.t VALUES (1)",
+			advice: []string{"COL.002 INSERT IGNORE INTO db.t"},
+		},
+		{
+			query:  "replace t select * from u",
+			advice: []string{"COL.002 replace t", "COL.001 *"},
+		},
+		{
+			// The commas in ON DUPLICATE KEY UPDATE are not joins.
+			query:  "insert into t (a, b) values (1, 2) on duplicate key update a = 1, b = 2",
+			advice: nil,
+		},
+		{
+			query:  "WITH x AS (SELECT id FROM u WHERE a = 1), y (id) AS (SELECT 1) DELETE FROM t",
+			advice: []string{"CLA.008 DELETE FROM t"},
+		},
+		{
+			query:  "with recursive x as (select 1) update t join x on t.id = x.id set t.a = 1 where t.b = 2",
+			advice: nil,
+		},
+		{
+			// Functions with keyword names.
+			query:  "SELECT a FROM t WHERE LEFT(c, 3) = 'abc' AND IF(d) = 1 AND e IN (1) AND NOT (f) = 1",
+			advice: []string{"ARG.003 LEFT(c, 3)", "ARG.003 IF(d)"},
+		},
+		{
+			query:  "select * from t join u on t.id = u.id, v join w using (id), x where t.a = v.a",
+			advice: []string{"COL.001 *", "JOI.005 ,", "JOI.005 ,"},
+		},
+		{
+			// ON DUPLICATE KEY UPDATE after FROM is not part of FROM.
+			query:  "insert into t (a, b) select a, b from u on duplicate key update a = 1, b = 2",
+			advice: nil,
+		},
+	}
+	for _, test := range tests {
+		var advice []string
+		for _, a := range query.Analyze(test.query, query.AnalyzeOptions{}) {
+			advice = append(advice, a.Rule+" "+test.query[a.Start:a.End])
+		}
+		assert.Equal(t, test.advice, advice, test.query)
+	}
+}
+
+func TestAnalyzeInList(t *testing.T) {
+	values := strings.Repeat("1, ", query.DefaultMaxInList) + "1"
+	q := "SELECT a FROM t WHERE id NOT IN (" + values + ")"
+	assert.Equal(t, []query.Advice{{
+		Rule:     query.RuleHugeInList,
+		Severity: query.SeverityWarning,
+		Start:    25,
+		End:      len(q),
+		Message:  "a huge IN list is slow to parse and optimize; use a temporary table or a join",
+	}}, query.Analyze(q, query.AnalyzeOptions{}))
+
+	assert.Empty(t, query.Analyze("SELECT a FROM t WHERE id IN (1, 2, 3)", query.AnalyzeOptions{}))
+	assert.Len(t, query.Analyze("SELECT a FROM t WHERE id IN (1, 2, 3)", query.AnalyzeOptions{MaxInList: 2}), 1)
+}
+
+// Unclosed and deeply nested lists, like in truncated queries, are scanned
+// once, not once per list.
+func TestAnalyzeUnclosedLists(t *testing.T) {
+	for _, unit := range []string{"in(1,", "a in (", "f(", "in(select in(1,", "(("} {
+		q := func(n int) string { return "DELETE FROM t WHERE " + strings.Repeat(unit, n) }
+		analyze := func(n int) func() { return func() { query.Analyze(q(n), query.AnalyzeOptions{}) } }
+		literals := func(n int) func() { return func() { query.FingerprintLiterals(q(n)) } }
+		// 8 times the lists are at most 8 times the tokens lexed.
+		assert.LessOrEqual(t, query.CountLexed(analyze(8000)), 8*query.CountLexed(analyze(1000)), unit)
+		assert.LessOrEqual(t, query.CountLexed(literals(8000)), 8*query.CountLexed(literals(1000)), unit)
+		// The analyzer matches parentheses once, so this takes milliseconds,
+		// not minutes.
+		analyze(50000)()
+	}
+
+	// An unclosed list is counted up to the last value.
+	values := strings.Repeat("1, ", query.DefaultMaxInList)
+	advice := query.Analyze("SELECT a FROM t WHERE id IN ("+values+"1", query.AnalyzeOptions{})
+	require.Len(t, advice, 1)
+	assert.Equal(t, query.RuleHugeInList, advice[0].Rule)
+	assert.Empty(t, query.Analyze("SELECT a FROM t WHERE id IN ("+values, query.AnalyzeOptions{}))
+}
@@ -0,0 +1,753 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Package ast parses common MySQL DML statements into an abstract syntax tree
+// and normalizes them.
+//
+// query.Fingerprint does not parse SQL, so queries that differ only in the
+// order of predicates, alias names, or redundant parentheses have different
+// fingerprints. Normalize parses the query and canonicalizes the tree, so
+// those queries have the same normalized form. Only the common subset of
+// SELECT, INSERT, REPLACE, UPDATE, and DELETE is parsed; Normalize returns
+// the fingerprint of other queries.
+package ast
+
+import (
+	"strings"
+
+	"github.com/percona/go-mysql/query"
+)
+
+// A Node is a node of the tree. String returns the node as SQL.
+type Node interface {
+	String() string
+}
+
+// A Statement is a *Select, *Union, *Insert, *Update, or *Delete.
+type Statement interface {
+	Node
+	statement()
+}
+
+// An Expr is an expression.
+type Expr interface {
+	Node
+	expr()
+}
+
+// A TableExpr is a *Table, *DerivedTable, or *Join.
+type TableExpr interface {
+	Node
+	tableExpr()
+}
+
+// Select is a SELECT statement.
+type Select struct {
+	Options []string // like distinct and sql_no_cache
+	Fields  []*Field
+	From    []TableExpr
+	Where   Expr
+	GroupBy []Expr
+	Having  Expr
+	OrderBy []*Order
+	Limit   *Limit
+	Lock    string // like "for update"
+}
+
+// Union is a UNION of SELECT statements.
+type Union struct {
+	Left  Statement // *Select or *Union
+	Right *Select
+	All   bool
+}
+
+// Insert is an INSERT or REPLACE statement. Rows, Select, or Set is set.
+type Insert struct {
+	Verb        string   // insert or replace
+	Options     []string // like ignore
+	Table       *Table
+	Columns     []string
+	Rows        [][]Expr
+	Select      Statement
+	Set         []*Assignment
+	OnDuplicate []*Assignment
+}
+
+// Update is an UPDATE statement.
+type Update struct {
+	Options []string // like ignore
+	Tables  []TableExpr
+	Set     []*Assignment
+	Where   Expr
+	OrderBy []*Order
+	Limit   *Limit
+}
+
+// Delete is a single-table DELETE statement.
+type Delete struct {
+	Options []string // like quick
+	Table   *Table
+	Where   Expr
+	OrderBy []*Order
+	Limit   *Limit
+}
+
+// A Field is an expression in the select list.
+type Field struct {
+	Expr  Expr
+	Alias string
+}
+
+// An Order is an expression in ORDER BY.
+type Order struct {
+	Expr Expr
+	Desc bool
+}
+
+// A Limit is LIMIT count, or LIMIT count OFFSET offset if Offset is not nil.
+type Limit struct {
+	Count  Expr
+	Offset Expr
+}
+
+// An Assignment is column = value in SET and ON DUPLICATE KEY UPDATE.
+type Assignment struct {
+	Column *Column
+	Value  Expr
+}
+
+// Table is a table name.
+type Table struct {
+	Db    string
+	Name  string
+	Alias string
+}
+
+// DerivedTable is a subquery in FROM.
+type DerivedTable struct {
+	Select Statement
+	Alias  string
+}
+
+// Join is a join of two tables. On or Using is set unless the join is
+// natural or a cross join.
+type Join struct {
+	Type  string // join, left join, right join, straight_join, natural join, etc.
+	Left  TableExpr
+	Right TableExpr
+	On    Expr
+	Using []string
+}
+
+// Value is a literal value, like 'abc', 1, or NULL, or a ? placeholder.
+type Value struct {
+	Text string
+}
+
+// Column is a column name.
+type Column struct {
+	Db    string
+	Table string
+	Name  string
+}
+
+// Star is * or table.* in the select list or COUNT(*).
+type Star struct {
+	Table string
+}
+
+// Variable is a user or system variable, like @a or @@sql_mode.
+type Variable struct {
+	Name string
+}
+
+// Keyword is a function that is called without parentheses, like
+// CURRENT_TIMESTAMP.
+type Keyword struct {
+	Name string
+}
+
+// Unary is a unary operation, like -a or NOT a.
+type Unary struct {
+	Op   string // -, +, ~, !, or not
+	Expr Expr
+}
+
+// Binary is a binary operation, like a + b, a = b, or a LIKE b.
+type Binary struct {
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+// Logical is a chain of AND, OR, or XOR operations.
+type Logical struct {
+	Op    string // and, or, or xor
+	Exprs []Expr
+}
+
+// In is IN with a list of values, or a subquery if Select is not nil.
+type In struct {
+	Expr   Expr
+	Not    bool
+	List   []Expr
+	Select Statement
+}
+
+// Between is BETWEEN low AND high.
+type Between struct {
+	Expr Expr
+	Not  bool
+	Low  Expr
+	High Expr
+}
+
+// Is is IS NULL, IS TRUE, etc.
+type Is struct {
+	Expr  Expr
+	Not   bool
+	Value string // null, true, false, or unknown
+}
+
+// Func is a function call.
+type Func struct {
+	Name     string
+	Distinct bool
+	Args     []Expr
+}
+
+// Subquery is a subquery in an expression.
+type Subquery struct {
+	Select Statement
+}
+
+// Exists is EXISTS with a subquery.
+type Exists struct {
+	Select Statement
+}
+
+// Case is a CASE expression. Operand is nil for CASE WHEN cond THEN ...
+type Case struct {
+	Operand Expr
+	Whens   []*When
+	Else    Expr
+}
+
+// A When is WHEN cond THEN result in a CASE expression.
+type When struct {
+	Cond   Expr
+	Result Expr
+}
+
+// Tuple is a row constructor, like (a, b) in (a, b) = (1, 2).
+type Tuple struct {
+	Exprs []Expr
+}
+
+// Interval is INTERVAL expr unit, like INTERVAL 1 DAY.
+type Interval struct {
+	Expr Expr
+	Unit string
+}
+
+func (*Select) statement() {}
+func (*Union) statement()  {}
+func (*Insert) statement() {}
+func (*Update) statement() {}
+func (*Delete) statement() {}
+
+func (*Table) tableExpr()        {}
+func (*DerivedTable) tableExpr() {}
+func (*Join) tableExpr()         {}
+
+func (*Value) expr()    {}
+func (*Column) expr()   {}
+func (*Star) expr()     {}
+func (*Variable) expr() {}
+func (*Keyword) expr()  {}
+func (*Unary) expr()    {}
+func (*Binary) expr()   {}
+func (*Logical) expr()  {}
+func (*In) expr()       {}
+func (*Between) expr()  {}
+func (*Is) expr()       {}
+func (*Func) expr()     {}
+func (*Subquery) expr() {}
+func (*Exists) expr()   {}
+func (*Case) expr()     {}
+func (*Tuple) expr()    {}
+func (*Interval) expr() {}
+
+func (s *Select) String() string       { return format(s) }
+func (s *Union) String() string        { return format(s) }
+func (s *Insert) String() string       { return format(s) }
+func (s *Update) String() string       { return format(s) }
+func (s *Delete) String() string       { return format(s) }
+func (t *Table) String() string        { return format(t) }
+func (t *DerivedTable) String() string { return format(t) }
+func (t *Join) String() string         { return format(t) }
+func (e *Value) String() string        { return format(e) }
+func (e *Column) String() string       { return format(e) }
+func (e *Star) String() string         { return format(e) }
+func (e *Variable) String() string     { return format(e) }
+func (e *Keyword) String() string      { return format(e) }
+func (e *Unary) String() string        { return format(e) }
+func (e *Binary) String() string       { return format(e) }
+func (e *Logical) String() string      { return format(e) }
+func (e *In) String() string           { return format(e) }
+func (e *Between) String() string      { return format(e) }
+func (e *Is) String() string           { return format(e) }
+func (e *Func) String() string         { return format(e) }
+func (e *Subquery) String() string     { return format(e) }
+func (e *Exists) String() string       { return format(e) }
+func (e *Case) String() string         { return format(e) }
+func (e *Tuple) String() string        { return format(e) }
+func (e *Interval) String() string     { return format(e) }
+
+// Operator precedence, lowest first, like MySQL.
+const (
+	precOr = iota + 1
+	precXor
+	precAnd
+	precNot
+	precBetween
+	precCompare
+	precBitOr
+	precBitAnd
+	precShift
+	precAdd
+	precMul
+	precBitXor
+	precUnary
+	precNeg
+	precPrimary
+)
+
+// binaryPrec is the precedence of binary operators.
+var binaryPrec = map[string]int{
+	"=": precCompare, "<=>": precCompare, "!=": precCompare, "<>": precCompare,
+	"<": precCompare, "<=": precCompare, ">": precCompare, ">=": precCompare,
+	"like": precCompare, "not like": precCompare, "regexp": precCompare, "not regexp": precCompare,
+	"|": precBitOr, "&": precBitAnd, "<<": precShift, ">>": precShift,
+	"+": precAdd, "-": precAdd, "*": precMul, "/": precMul, "div": precMul, "%": precMul, "mod": precMul,
+	"^": precBitXor,
+}
+
+// precedence returns the precedence of the expression. Expressions are
+// parenthesized when their precedence is lower than the context requires.
+func precedence(e Expr) int {
+	switch e := e.(type) {
+	case *Logical:
+		switch e.Op {
+		case "or":
+			return precOr
+		case "xor":
+			return precXor
+		}
+		return precAnd
+	case *Unary:
+		switch e.Op {
+		case "not":
+			return precNot
+		case "!":
+			return precNeg
+		}
+		return precUnary
+	case *Between:
+		return precBetween
+	case *In, *Is:
+		return precCompare
+	case *Binary:
+		return binaryPrec[e.Op]
+	}
+	return precPrimary
+}
+
+// printer formats nodes as SQL.
+type printer struct {
+	strings.Builder
+}
+
+func format(n Node) string {
+	var p printer
+	p.node(n)
+	return p.String()
+}
+
+func (p *printer) node(n Node) {
+	switch n := n.(type) {
+	case Statement:
+		p.statement(n)
+	case TableExpr:
+		p.tableExpr(n)
+	case Expr:
+		p.expr(n, 0)
+	}
+}
+
+func (p *printer) statement(s Statement) {
+	switch s := s.(type) {
+	case *Select:
+		p.WriteString("select")
+		for _, o := range s.Options {
+			p.WriteString(" " + o)
+		}
+		for i, f := range s.Fields {
+			if i > 0 {
+				p.WriteByte(',')
+			}
+			p.WriteByte(' ')
+			p.expr(f.Expr, 0)
+			if f.Alias != "" {
+				p.WriteString(" as " + ident(f.Alias))
+			}
+		}
+		if len(s.From) > 0 {
+			p.WriteString(" from ")
+			for i, t := range s.From {
+				if i > 0 {
+					p.WriteString(", ")
+				}
+				p.tableExpr(t)
+			}
+		}
+		p.where(s.Where)
+		if len(s.GroupBy) > 0 {
+			p.WriteString(" group by ")
+			p.exprs(s.GroupBy)
+		}
+		if s.Having != nil {
+			p.WriteString(" having ")
+			p.expr(s.Having, 0)
+		}
+		p.orderBy(s.OrderBy)
+		p.limit(s.Limit)
+		if s.Lock != "" {
+			p.WriteString(" " + s.Lock)
+		}
+	case *Union:
+		p.statement(s.Left)
+		p.WriteString(" union ")
+		if s.All {
+			p.WriteString("all ")
+		}
+		p.statement(s.Right)
+	case *Insert:
+		p.WriteString(s.Verb)
+		for _, o := range s.Options {
+			p.WriteString(" " + o)
+		}
+		p.WriteString(" into ")
+		p.tableExpr(s.Table)
+		if len(s.Columns) > 0 {
+			p.WriteByte('(')
+			p.idents(s.Columns)
+			p.WriteByte(')')
+		}
+		switch {
+		case s.Select != nil:
+			p.WriteByte(' ')
+			p.statement(s.Select)
+		case s.Set != nil:
+			p.WriteString(" set ")
+			p.assignments(s.Set)
+		default:
+			p.WriteString(" values ")
+			for i, row := range s.Rows {
+				if i > 0 {
+					p.WriteString(", ")
+				}
+				p.WriteByte('(')
+				p.exprs(row)
+				p.WriteByte(')')
+			}
+		}
+		if s.OnDuplicate != nil {
+			p.WriteString(" on duplicate key update ")
+			p.assignments(s.OnDuplicate)
+		}
+	case *Update:
+		p.WriteString("update")
+		for _, o := range s.Options {
+			p.WriteString(" " + o)
+		}
+		for i, t := range s.Tables {
+			if i > 0 {
+				p.WriteByte(',')
+			}
+			p.WriteByte(' ')
+			p.tableExpr(t)
+		}
+		p.WriteString(" set ")
+		p.assignments(s.Set)
+		p.where(s.Where)
+		p.orderBy(s.OrderBy)
+		p.limit(s.Limit)
+	case *Delete:
+		p.WriteString("delete")
+		for _, o := range s.Options {
+			p.WriteString(" " + o)
+		}
+		p.WriteString(" from ")
+		p.tableExpr(s.Table)
+		p.where(s.Where)
+		p.orderBy(s.OrderBy)
+		p.limit(s.Limit)
+	}
+}
+
+func (p *printer) where(e Expr) {
+	if e != nil {
+		p.WriteString(" where ")
+		p.expr(e, 0)
+	}
+}
+
+func (p *printer) orderBy(orders []*Order) {
+	if len(orders) == 0 {
+		return
+	}
+	p.WriteString(" order by ")
+	for i, o := range orders {
+		if i > 0 {
+			p.WriteString(", ")
+		}
+		p.expr(o.Expr, 0)
+		if o.Desc {
+			p.WriteString(" desc")
+		}
+	}
+}
+
+func (p *printer) limit(l *Limit) {
+	if l == nil {
+		return
+	}
+	p.WriteString(" limit ")
+	p.expr(l.Count, 0)
+	if l.Offset != nil {
+		p.WriteString(" offset ")
+		p.expr(l.Offset, 0)
+	}
+}
+
+func (p *printer) assignments(set []*Assignment) {
+	for i, a := range set {
+		if i > 0 {
+			p.WriteString(", ")
+		}
+		p.expr(a.Column, 0)
+		p.WriteString(" = ")
+		p.expr(a.Value, 0)
+	}
+}
+
+func (p *printer) tableExpr(t TableExpr) {
+	switch t := t.(type) {
+	case *Table:
+		if t.Db != "" {
+			p.WriteString(ident(t.Db) + ".")
+		}
+		p.WriteString(ident(t.Name))
+		if t.Alias != "" {
+			p.WriteString(" as " + ident(t.Alias))
+		}
+	case *DerivedTable:
+		p.WriteByte('(')
+		p.statement(t.Select)
+		p.WriteString(") as " + ident(t.Alias))
+	case *Join:
+		p.tableExpr(t.Left)
+		p.WriteString(" " + t.Type + " ")
+		if _, ok := t.Right.(*Join); ok {
+			p.WriteByte('(')
+			p.tableExpr(t.Right)
+			p.WriteByte(')')
+		} else {
+			p.tableExpr(t.Right)
+		}
+		if t.On != nil {
+			p.WriteString(" on ")
+			p.expr(t.On, 0)
+		} else if t.Using != nil {
+			p.WriteString(" using (")
+			p.idents(t.Using)
+			p.WriteByte(')')
+		}
+	}
+}
+
+// expr writes the expression, in parentheses if its precedence is lower
+// than prec.
+func (p *printer) expr(e Expr, prec int) {
+	if precedence(e) < prec {
+		p.WriteByte('(')
+		defer p.WriteByte(')')
+	}
+	switch e := e.(type) {
+	case *Value:
+		p.WriteString(e.Text)
+	case *Column:
+		if e.Db != "" {
+			p.WriteString(ident(e.Db) + ".")
+		}
+		if e.Table != "" {
+			p.WriteString(ident(e.Table) + ".")
+		}
+		p.WriteString(ident(e.Name))
+	case *Star:
+		if e.Table != "" {
+			p.WriteString(ident(e.Table) + ".")
+		}
+		p.WriteByte('*')
+	case *Variable:
+		p.WriteString(e.Name)
+	case *Keyword:
+		p.WriteString(e.Name)
+	case *Unary:
+		var operand printer
+		operand.expr(e.Expr, precedence(e)+1)
+		p.WriteString(e.Op)
+		if e.Op == "not" || (e.Op == "-" && strings.HasPrefix(operand.String(), "-")) {
+			// - -1, not --1 which begins a comment.
+			p.WriteByte(' ')
+		}
+		p.WriteString(operand.String())
+	case *Binary:
+		prec := binaryPrec[e.Op]
+		p.expr(e.Left, prec)
+		p.WriteString(" " + e.Op + " ")
+		p.expr(e.Right, prec+1)
+	case *Logical:
+		prec := precedence(e)
+		for i, x := range e.Exprs {
+			if i > 0 {
+				p.WriteString(" " + e.Op + " ")
+			}
+			p.expr(x, prec+1)
+		}
+	case *In:
+		p.expr(e.Expr, precCompare+1)
+		if e.Not {
+			p.WriteString(" not")
+		}
+		p.WriteString(" in(")
+		if e.Select != nil {
+			p.statement(e.Select)
+		} else {
+			p.exprs(e.List)
+		}
+		p.WriteByte(')')
+	case *Between:
+		p.expr(e.Expr, precCompare+1)
+		if e.Not {
+			p.WriteString(" not")
+		}
+		p.WriteString(" between ")
+		p.expr(e.Low, precCompare+1)
+		p.WriteString(" and ")
+		p.expr(e.High, precCompare+1)
+	case *Is:
+		p.expr(e.Expr, precCompare+1)
+		p.WriteString(" is ")
+		if e.Not {
+			p.WriteString("not ")
+		}
+		p.WriteString(e.Value)
+	case *Func:
+		p.WriteString(e.Name + "(")
+		if e.Distinct {
+			p.WriteString("distinct ")
+		}
+		p.exprs(e.Args)
+		p.WriteByte(')')
+	case *Subquery:
+		p.WriteByte('(')
+		p.statement(e.Select)
+		p.WriteByte(')')
+	case *Exists:
+		p.WriteString("exists(")
+		p.statement(e.Select)
+		p.WriteByte(')')
+	case *Case:
+		p.WriteString("case")
+		if e.Operand != nil {
+			p.WriteByte(' ')
+			p.expr(e.Operand, 0)
+		}
+		for _, w := range e.Whens {
+			p.WriteString(" when ")
+			p.expr(w.Cond, 0)
+			p.WriteString(" then ")
+			p.expr(w.Result, 0)
+		}
+		if e.Else != nil {
+			p.WriteString(" else ")
+			p.expr(e.Else, 0)
+		}
+		p.WriteString(" end")
+	case *Tuple:
+		p.WriteByte('(')
+		p.exprs(e.Exprs)
+		p.WriteByte(')')
+	case *Interval:
+		p.WriteString("interval ")
+		p.expr(e.Expr, precPrimary)
+		p.WriteString(" " + e.Unit)
+	}
+}
+
+func (p *printer) exprs(exprs []Expr) {
+	for i, e := range exprs {
+		if i > 0 {
+			p.WriteString(", ")
+		}
+		p.expr(e, 0)
+	}
+}
+
+func (p *printer) idents(names []string) {
+	for i, name := range names {
+		if i > 0 {
+			p.WriteString(", ")
+		}
+		p.WriteString(ident(name))
+	}
+}
+
+// ident returns the identifier, quoted if it is not a plain identifier, like
+// a keyword or a name with spaces.
+func ident(name string) string {
+	l := query.NewLexer(name)
+	if t := l.Next(); t.Type == query.TokenIdent && t.End == len(name) {
+		return name
+	}
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
@@ -0,0 +1,361 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package ast
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/percona/go-mysql/query"
+)
+
+// Normalize returns the normalized form of q, or query.Fingerprint(q) if q
+// cannot be parsed. The normalized form is like a fingerprint, but more
+// queries that are equivalent have the same normalized form:
+//   - Values are replaced with ?, and lists of values like IN (1, 2) and
+//     VALUES (1), (2) with (?+)
+//   - Operands of AND, OR, XOR, and commutative operators like = are sorted,
+//     so a = ? AND b = ? is the same as ? = b AND a = ?
+//   - Table aliases are renamed t1, t2, etc. and select list aliases c1, c2,
+//     etc., in the order they are declared. Names that are identifiers in the
+//     query, like a table named t1, are skipped, so an alias never becomes
+//     the name of a table or column
+//   - Redundant parentheses are removed
+//   - Keywords and identifiers are lowercase, and whitespace is collapsed
+//
+// Normalized forms and fingerprints are different, so a query that can be
+// parsed and one that cannot are never the same. Use query.Id to make an ID
+// of the normalized form.
+func Normalize(q string) string {
+	stmt, err := Parse(q)
+	if err != nil {
+		return query.Fingerprint(q)
+	}
+	return Canonicalize(stmt).String()
+}
+
+// Canonicalize returns the canonical form of the statement, see Normalize.
+// The statement is modified.
+func Canonicalize(stmt Statement) Statement {
+	n := normalizer{names: identNames(stmt)}
+	n.statement(stmt)
+	return stmt
+}
+
+// normalizer canonicalizes a statement in place.
+type normalizer struct {
+	tables int             // table aliases renamed
+	names  map[string]bool // lowercase identifiers in the statement
+	scopes []*scope        // innermost last
+}
+
+// identNames returns the lowercase identifiers in the statement: names of
+// tables, columns, and aliases, so canonical aliases can skip them.
+func identNames(stmt Statement) map[string]bool {
+	names := map[string]bool{}
+	for _, t := range query.Tokenize(stmt.String()) {
+		if t.Type == query.TokenIdent || t.Type == query.TokenQuotedIdent {
+			names[strings.ToLower(strings.Trim(t.Text, "`"))] = true
+		}
+	}
+	return names
+}
+
+// alias returns the next canonical alias with the prefix, like t1, that is
+// not an identifier in the statement.
+func (n *normalizer) alias(prefix string, count *int) string {
+	for {
+		*count++
+		if alias := prefix + strconv.Itoa(*count); !n.names[alias] {
+			return alias
+		}
+	}
+}
+
+// A scope is the aliases of a SELECT, UPDATE, or DELETE.
+type scope struct {
+	tables  map[string]string // table alias -> canonical alias
+	columns map[string]string // select list alias -> canonical alias
+	renamed int               // select list aliases renamed
+}
+
+func (n *normalizer) push() *scope {
+	s := &scope{tables: map[string]string{}, columns: map[string]string{}}
+	n.scopes = append(n.scopes, s)
+	return s
+}
+
+func (n *normalizer) pop() {
+	n.scopes = n.scopes[:len(n.scopes)-1]
+}
+
+func (n *normalizer) statement(stmt Statement) {
+	switch s := stmt.(type) {
+	case *Select:
+		sc := n.push()
+		defer n.pop()
+		n.tableExprs(s.From)
+		for _, f := range s.Fields {
+			if f.Alias != "" {
+				alias := n.alias("c", &sc.renamed)
+				sc.columns[f.Alias] = alias
+				f.Alias = alias
+			}
+			f.Expr = n.expr(f.Expr, false)
+		}
+		n.joins(s.From)
+		s.Where = n.expr(s.Where, false)
+		for i := range s.GroupBy {
+			s.GroupBy[i] = n.expr(s.GroupBy[i], true)
+		}
+		s.Having = n.expr(s.Having, true)
+		n.orderBy(s.OrderBy)
+		n.limit(s.Limit)
+	case *Union:
+		n.statement(s.Left)
+		n.statement(s.Right)
+	case *Insert:
+		if len(s.Rows) > 0 {
+			row := s.Rows[0]
+			for i := range row {
+				row[i] = n.expr(row[i], false)
+			}
+			if isValues(row) {
+				row = []Expr{&Value{Text: "?+"}}
+			}
+			s.Rows = [][]Expr{row}
+		}
+		if s.Select != nil {
+			n.statement(s.Select)
+		}
+		n.assignments(s.Set)
+		n.assignments(s.OnDuplicate)
+	case *Update:
+		n.push()
+		defer n.pop()
+		n.tableExprs(s.Tables)
+		n.joins(s.Tables)
+		n.assignments(s.Set)
+		s.Where = n.expr(s.Where, false)
+		n.orderBy(s.OrderBy)
+		n.limit(s.Limit)
+	case *Delete:
+		n.push()
+		defer n.pop()
+		n.tableExprs([]TableExpr{s.Table})
+		s.Where = n.expr(s.Where, false)
+		n.orderBy(s.OrderBy)
+		n.limit(s.Limit)
+	}
+}
+
+// tableExprs renames the table aliases, and canonicalizes derived tables.
+func (n *normalizer) tableExprs(tables []TableExpr) {
+	sc := n.scopes[len(n.scopes)-1]
+	rename := func(alias string) string {
+		if alias == "" {
+			return ""
+		}
+		sc.tables[alias] = n.alias("t", &n.tables)
+		return sc.tables[alias]
+	}
+	for _, t := range tables {
+		switch t := t.(type) {
+		case *Table:
+			t.Alias = rename(t.Alias)
+		case *DerivedTable:
+			n.statement(t.Select)
+			t.Alias = rename(t.Alias)
+		case *Join:
+			n.tableExprs([]TableExpr{t.Left, t.Right})
+		}
+	}
+}
+
+// joins canonicalizes the join conditions, after all aliases are renamed.
+func (n *normalizer) joins(tables []TableExpr) {
+	for _, t := range tables {
+		if j, ok := t.(*Join); ok {
+			n.joins([]TableExpr{j.Left, j.Right})
+			j.On = n.expr(j.On, false)
+		}
+	}
+}
+
+func (n *normalizer) orderBy(orders []*Order) {
+	for _, o := range orders {
+		o.Expr = n.expr(o.Expr, true)
+	}
+}
+
+func (n *normalizer) limit(l *Limit) {
+	if l != nil {
+		l.Count = n.expr(l.Count, false)
+		l.Offset = n.expr(l.Offset, false)
+	}
+}
+
+func (n *normalizer) assignments(set []*Assignment) {
+	for _, a := range set {
+		n.expr(a.Column, false)
+		a.Value = n.expr(a.Value, false)
+	}
+}
+
+// commuted is the operator with its operands swapped.
+var commuted = map[string]string{
+	"=": "=", "<=>": "<=>", "!=": "!=",
+	"<": ">", "<=": ">=", ">": "<", ">=": "<=",
+	"+": "+", "*": "*", "&": "&", "|": "|", "^": "^",
+}
+
+// expr returns the canonical expression. If aliases is true, columns can be
+// select list aliases, like in ORDER BY.
+func (n *normalizer) expr(e Expr, aliases bool) Expr {
+	switch e := e.(type) {
+	case nil:
+		return nil
+	case *Value:
+		e.Text = "?"
+	case *Column:
+		if e.Db == "" && e.Table != "" {
+			e.Table = n.lookup(e.Table, func(s *scope) map[string]string { return s.tables })
+		}
+		if aliases && e.Table == "" {
+			e.Name = n.lookup(e.Name, func(s *scope) map[string]string { return s.columns })
+		}
+	case *Star:
+		if e.Table != "" {
+			e.Table = n.lookup(e.Table, func(s *scope) map[string]string { return s.tables })
+		}
+	case *Unary:
+		e.Expr = n.expr(e.Expr, aliases)
+		if _, ok := e.Expr.(*Value); ok && (e.Op == "-" || e.Op == "+") {
+			return e.Expr
+		}
+	case *Binary:
+		if e.Op == "<>" {
+			e.Op = "!="
+		}
+		e.Left = n.expr(e.Left, aliases)
+		e.Right = n.expr(e.Right, aliases)
+		if op, ok := commuted[e.Op]; ok && less(e.Right, e.Left) {
+			e.Op, e.Left, e.Right = op, e.Right, e.Left
+		}
+	case *Logical:
+		var exprs []Expr
+		for _, x := range e.Exprs {
+			x = n.expr(x, aliases)
+			if l, ok := x.(*Logical); ok && l.Op == e.Op {
+				// a AND (b AND c) is a AND b AND c.
+				exprs = append(exprs, l.Exprs...)
+			} else {
+				exprs = append(exprs, x)
+			}
+		}
+		sort.SliceStable(exprs, func(i, j int) bool { return less(exprs[i], exprs[j]) })
+		e.Exprs = exprs
+	case *In:
+		e.Expr = n.expr(e.Expr, aliases)
+		if e.Select != nil {
+			n.statement(e.Select)
+		}
+		for i := range e.List {
+			e.List[i] = n.expr(e.List[i], aliases)
+		}
+		if isValues(e.List) {
+			e.List = []Expr{&Value{Text: "?+"}}
+		}
+	case *Between:
+		e.Expr = n.expr(e.Expr, aliases)
+		e.Low = n.expr(e.Low, aliases)
+		e.High = n.expr(e.High, aliases)
+	case *Is:
+		e.Expr = n.expr(e.Expr, aliases)
+	case *Func:
+		for i := range e.Args {
+			e.Args[i] = n.expr(e.Args[i], aliases)
+		}
+	case *Subquery:
+		n.statement(e.Select)
+	case *Exists:
+		n.statement(e.Select)
+	case *Case:
+		e.Operand = n.expr(e.Operand, aliases)
+		for _, w := range e.Whens {
+			w.Cond = n.expr(w.Cond, aliases)
+			w.Result = n.expr(w.Result, aliases)
+		}
+		e.Else = n.expr(e.Else, aliases)
+	case *Tuple:
+		for i := range e.Exprs {
+			e.Exprs[i] = n.expr(e.Exprs[i], aliases)
+		}
+		if isValues(e.Exprs) {
+			return &Value{Text: "?"}
+		}
+	case *Interval:
+		e.Expr = n.expr(e.Expr, aliases)
+	}
+	return e
+}
+
+// lookup returns the canonical alias of name in the innermost scope that
+// declares it, or name if no scope declares it.
+func (n *normalizer) lookup(name string, aliases func(*scope) map[string]string) string {
+	for i := len(n.scopes) - 1; i >= 0; i-- {
+		if alias, ok := aliases(n.scopes[i])[name]; ok {
+			return alias
+		}
+	}
+	return name
+}
+
+// isValues returns true if all expressions are canonical values.
+func isValues(exprs []Expr) bool {
+	for _, e := range exprs {
+		if _, ok := e.(*Value); !ok {
+			return false
+		}
+	}
+	return len(exprs) > 0
+}
+
+// less orders canonical expressions: values last, others by their SQL.
+func less(a, b Expr) bool {
+	_, av := a.(*Value)
+	_, bv := b.(*Value)
+	if av != bv {
+		return bv
+	}
+	return a.String() < b.String()
+}
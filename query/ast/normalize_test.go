@@ -0,0 +1,171 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package ast_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/percona/go-mysql/query"
+	"github.com/percona/go-mysql/query/ast"
+)
+
+func TestNormalize(t *testing.T) {
+	// All queries in a group have the same normalized form.
+	tests := []struct {
+		queries  []string
+		expected string
+	}{
+		{
+			// Commutative predicate order and redundant parentheses.
+			queries: []string{
+				"SELECT a,b FROM t WHERE x=1 AND y=2",
+				"select a, b from t where (y = 'b') and ((x=5))",
+				"SELECT a, b FROM t WHERE 2 = y AND x = 1",
+			},
+			expected: "select a, b from t where x = ? and y = ?",
+		},
+		{
+			// Nested ANDs and ORs are flattened before sorting.
+			queries: []string{
+				"select * from t where a = 1 and (b = 2 and (c = 3 or d = 4))",
+				"select * from t where (d = 1 or c = 2) and b = 3 and a = 4",
+			},
+			expected: "select * from t where a = ? and b = ? and (c = ? or d = ?)",
+		},
+		{
+			// Table and select list aliases.
+			queries: []string{
+				"SELECT u.id, u.name AS n FROM users u JOIN orders o ON o.user_id = u.id WHERE u.id IN (1,2,3) ORDER BY n DESC LIMIT 10, 20",
+				"select x.id, x.name as nm from users as x inner join orders y on x.id = y.user_id where x.id in (4) order by nm desc limit 20 offset 10",
+			},
+			expected: "select t1.id, t1.name as c1 from users as t1 join orders as t2 on t1.id = t2.user_id where t1.id in(?+) order by c1 desc limit ? offset ?",
+		},
+		{
+			// Aliases skip the names of tables, so they do not collide.
+			queries: []string{
+				"SELECT * FROM t1 JOIN t2 x ON t1.a = x.a",
+				"select * from t1 join t2 as y on y.a = t1.a",
+			},
+			expected: "select * from t1 join t2 as t3 on t1.a = t3.a",
+		},
+		{
+			// Tables named like aliases are renamed like other tables.
+			queries:  []string{"SELECT * FROM t1 AS t2 JOIN t2 AS t1 ON t1.a = t2.b"},
+			expected: "select * from t1 as t3 join t2 as t4 on t3.b = t4.a",
+		},
+		{
+			queries:  []string{"SELECT * FROM t2 AS t1 JOIN t1 AS t2 ON t1.a = t2.b"},
+			expected: "select * from t2 as t3 join t1 as t4 on t3.a = t4.b",
+		},
+		{
+			// Select list aliases skip the names of columns.
+			queries:  []string{"SELECT a AS x, c1 FROM t ORDER BY x"},
+			expected: "select a as c2, c1 from t order by c2",
+		},
+		{
+			// Comparisons are flipped to keep values on the right.
+			queries: []string{
+				"select * from t where 5 < a and b >= 3",
+				"select * from t where b >= 3 and a > 5",
+			},
+			expected: "select * from t where a > ? and b >= ?",
+		},
+		{
+			// <> is !=, and negative numbers are values.
+			queries: []string{
+				"delete from t where id <> -5",
+				"DELETE FROM t WHERE -6 != id",
+			},
+			expected: "delete from t where id != ?",
+		},
+		{
+			// IN list arity, including row constructors.
+			queries: []string{
+				"select * from t where (a, b) in ((1, 2), (3, 4)) and c in (1)",
+				"select * from t where c in (1, 2, 3) and (a, b) in ((1, 2))",
+			},
+			expected: "select * from t where (a, b) in(?+) and c in(?+)",
+		},
+		{
+			// Multi-row inserts.
+			queries: []string{
+				"INSERT INTO t (a,b) VALUES (1,'x'),(2,'y')",
+				"insert into t(a, b) values (3, 'z')",
+			},
+			expected: "insert into t(a, b) values (?+)",
+		},
+		{
+			// Correlated subqueries use the aliases of the outer query.
+			queries: []string{
+				"select a from t x where exists (select 1 from u y where y.a = x.a)",
+				"select a from t p where exists (select 2 from u q where p.a = q.a)",
+			},
+			expected: "select a from t as t1 where exists(select ? from u as t2 where t1.a = t2.a)",
+		},
+		{
+			// Aliases of derived tables and joins in UPDATE.
+			queries: []string{
+				"update t a join (select id from u) b on a.id = b.id set a.x = 1",
+				"update t as m join (select id from u) as n on n.id = m.id set m.x = 2",
+			},
+			expected: "update t as t1 join (select id from u) as t2 on t1.id = t2.id set t1.x = ?",
+		},
+	}
+	for _, test := range tests {
+		for _, q := range test.queries {
+			assert.Equal(t, test.expected, ast.Normalize(q), q)
+		}
+	}
+}
+
+func TestNormalizeFallback(t *testing.T) {
+	// Queries that cannot be parsed are fingerprinted.
+	for _, q := range []string{
+		"SHOW TABLES",
+		"with x as (select 1) select * from x",
+		"select /*!40001 SQL_NO_CACHE */ * from t where a = 1",
+		"select a from t where",
+	} {
+		assert.Equal(t, query.Fingerprint(q), ast.Normalize(q), q)
+	}
+}
+
+func FuzzNormalize(f *testing.F) {
+	f.Add("select a, b from t x join u y on x.id = y.id where x.a in (1, 2) and not (y.b > 3 or y.c is null)")
+	f.Add("insert into t (a) values (1), (2) on duplicate key update a = values(a)")
+	f.Add("update t set a = -1 where b = 'x' order by c desc limit 1")
+	f.Add("select case when a then b end, count(distinct c), interval 1 day from (select 1) d union select 2")
+	f.Fuzz(func(t *testing.T, q string) {
+		ast.Normalize(q)
+	})
+}
@@ -0,0 +1,777 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package ast
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/percona/go-mysql/query"
+)
+
+// Parse parses a SELECT, INSERT, REPLACE, UPDATE, or DELETE statement. It
+// returns an error if the statement is not in the subset that is parsed, like
+// WITH, multi-table DELETE, or version comments. Identifiers are lowercased
+// and unquoted.
+func Parse(q string) (stmt Statement, err error) {
+	p := parser{q: q}
+	lx := query.NewLexer(q)
+	for t := lx.Next(); t.Type != query.TokenEOF; t = lx.Next() {
+		switch t.Type {
+		case query.TokenSpace, query.TokenComment, query.TokenHint:
+			continue
+		case query.TokenVersionComment:
+			return nil, fmt.Errorf("offset %d: version comments are not supported", t.Start)
+		}
+		p.tokens = append(p.tokens, t)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(parseError)
+			if !ok {
+				panic(r)
+			}
+			stmt, err = nil, e
+		}
+	}()
+	stmt = p.statement()
+	p.accept(";")
+	if t := p.peek(); t.Type != query.TokenEOF {
+		p.unexpected()
+	}
+	return stmt, nil
+}
+
+// parseError is panicked by the parser and returned by Parse.
+type parseError struct {
+	offset int
+	msg    string
+}
+
+func (e parseError) Error() string {
+	return fmt.Sprintf("offset %d: %s", e.offset, e.msg)
+}
+
+// parser is a recursive descent parser. Its methods panic with a parseError
+// on syntax errors, which Parse recovers.
+type parser struct {
+	q      string
+	tokens []query.Token // significant tokens
+	pos    int
+}
+
+func (p *parser) peek() query.Token {
+	return p.peekAt(0)
+}
+
+func (p *parser) peekAt(n int) query.Token {
+	if p.pos+n >= len(p.tokens) {
+		return query.Token{Type: query.TokenEOF, Start: len(p.q), End: len(p.q)}
+	}
+	return p.tokens[p.pos+n]
+}
+
+func (p *parser) next() query.Token {
+	t := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return t
+}
+
+// is returns true if the token is the word, ignoring case, or the operator.
+func is(t query.Token, s string) bool {
+	if t.Type == query.TokenOperator {
+		return t.Text == s
+	}
+	return t.Is(s)
+}
+
+// at returns true if the next token is one of the words or operators.
+func (p *parser) at(s ...string) bool {
+	t := p.peek()
+	for _, w := range s {
+		if is(t, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// accept consumes the next token if it is the word or operator.
+func (p *parser) accept(s string) bool {
+	if p.at(s) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) {
+	if !p.accept(s) {
+		p.fail(fmt.Sprintf("expected %s", s))
+	}
+}
+
+func (p *parser) fail(msg string) {
+	t := p.peek()
+	if t.Type == query.TokenEOF {
+		msg += ", found end of query"
+	} else {
+		msg += fmt.Sprintf(", found %q", t.Text)
+	}
+	panic(parseError{offset: t.Start, msg: msg})
+}
+
+func (p *parser) unexpected() {
+	p.fail("unexpected token")
+}
+
+// lower returns the next token lowercased and consumes it.
+func (p *parser) lower() string {
+	return strings.ToLower(p.next().Text)
+}
+
+// options consumes the words that are options and returns them lowercased.
+func (p *parser) options(words ...string) []string {
+	var options []string
+	for p.at(words...) {
+		options = append(options, p.lower())
+	}
+	return options
+}
+
+// ident consumes an identifier and returns it lowercased and unquoted.
+// Keywords are identifiers only after a dot, like t.key.
+func (p *parser) ident(afterDot bool) string {
+	t := p.peek()
+	switch {
+	case t.Type == query.TokenIdent, afterDot && t.Type == query.TokenKeyword:
+		p.pos++
+		return strings.ToLower(t.Text)
+	case t.Type == query.TokenQuotedIdent:
+		p.pos++
+		q := t.Text[0:1]
+		name := strings.TrimSuffix(t.Text[1:], q)
+		return strings.ToLower(strings.ReplaceAll(name, q+q, q))
+	}
+	p.fail("expected identifier")
+	return ""
+}
+
+// alias consumes [AS] alias, if any.
+func (p *parser) alias() string {
+	if p.accept("as") {
+		if t := p.peek(); t.Type == query.TokenString && len(t.Text) > 1 && t.Text[0] == t.Text[len(t.Text)-1] {
+			p.pos++
+			return strings.ToLower(t.Text[1 : len(t.Text)-1])
+		}
+		return p.ident(false)
+	}
+	if t := p.peek(); t.Type == query.TokenIdent || t.Type == query.TokenQuotedIdent {
+		return p.ident(false)
+	}
+	return ""
+}
+
+func (p *parser) statement() Statement {
+	switch {
+	case p.at("select"):
+		return p.selectStatement()
+	case p.at("insert", "replace"):
+		return p.insert()
+	case p.at("update"):
+		return p.update()
+	case p.at("delete"):
+		return p.delete()
+	}
+	p.fail("expected SELECT, INSERT, REPLACE, UPDATE, or DELETE")
+	return nil
+}
+
+// selectStatement parses a SELECT, or a UNION of SELECTs.
+func (p *parser) selectStatement() Statement {
+	var s Statement = p.selectOne()
+	for p.accept("union") {
+		all := p.accept("all")
+		if !all {
+			p.accept("distinct")
+		}
+		s = &Union{Left: s, Right: p.selectOne(), All: all}
+	}
+	return s
+}
+
+var selectOptions = []string{
+	"all", "distinct", "distinctrow", "high_priority", "straight_join", "sql_small_result",
+	"sql_big_result", "sql_buffer_result", "sql_no_cache", "sql_cache", "sql_calc_found_rows",
+}
+
+func (p *parser) selectOne() *Select {
+	p.expect("select")
+	s := &Select{}
+	for _, o := range p.options(selectOptions...) {
+		switch o {
+		case "all":
+			// The default.
+		case "distinctrow":
+			s.Options = append(s.Options, "distinct")
+		default:
+			s.Options = append(s.Options, o)
+		}
+	}
+	for {
+		f := &Field{}
+		if p.accept("*") {
+			f.Expr = &Star{}
+		} else {
+			f.Expr = p.expr()
+			f.Alias = p.alias()
+		}
+		s.Fields = append(s.Fields, f)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if p.accept("from") {
+		s.From = p.tableExprs()
+	}
+	s.Where = p.where()
+	if p.accept("group") {
+		p.expect("by")
+		s.GroupBy = p.exprs()
+	}
+	if p.accept("having") {
+		s.Having = p.expr()
+	}
+	s.OrderBy = p.orderBy()
+	s.Limit = p.limit()
+	switch {
+	case p.at("for") && (is(p.peekAt(1), "update") || is(p.peekAt(1), "share")):
+		s.Lock = p.lower() + " " + p.lower()
+	case p.accept("lock"):
+		p.expect("in")
+		p.expect("share")
+		p.expect("mode")
+		s.Lock = "lock in share mode"
+	}
+	return s
+}
+
+func (p *parser) insert() *Insert {
+	s := &Insert{Verb: p.lower()}
+	s.Options = p.options("low_priority", "delayed", "high_priority", "ignore")
+	p.accept("into")
+	s.Table = p.table(false)
+	if p.at("(") && !is(p.peekAt(1), "select") {
+		p.next()
+		for {
+			s.Columns = append(s.Columns, p.ident(false))
+			if !p.accept(",") {
+				break
+			}
+		}
+		p.expect(")")
+	}
+	switch {
+	case p.accept("values"), p.accept("value"):
+		for {
+			p.expect("(")
+			row := []Expr{}
+			if !p.at(")") {
+				row = p.exprs()
+			}
+			p.expect(")")
+			s.Rows = append(s.Rows, row)
+			if !p.accept(",") {
+				break
+			}
+		}
+	case p.accept("set"):
+		s.Set = p.assignments()
+	case p.at("("):
+		p.next()
+		s.Select = p.selectStatement()
+		p.expect(")")
+	default:
+		s.Select = p.selectStatement()
+	}
+	if p.accept("on") {
+		p.expect("duplicate")
+		p.expect("key")
+		p.expect("update")
+		s.OnDuplicate = p.assignments()
+	}
+	return s
+}
+
+func (p *parser) update() *Update {
+	p.expect("update")
+	s := &Update{Options: p.options("low_priority", "ignore")}
+	s.Tables = p.tableExprs()
+	p.expect("set")
+	s.Set = p.assignments()
+	s.Where = p.where()
+	s.OrderBy = p.orderBy()
+	s.Limit = p.limit()
+	return s
+}
+
+func (p *parser) delete() *Delete {
+	p.expect("delete")
+	s := &Delete{Options: p.options("low_priority", "quick", "ignore")}
+	p.expect("from")
+	s.Table = p.table(true)
+	s.Where = p.where()
+	s.OrderBy = p.orderBy()
+	s.Limit = p.limit()
+	return s
+}
+
+func (p *parser) where() Expr {
+	if p.accept("where") {
+		return p.expr()
+	}
+	return nil
+}
+
+func (p *parser) orderBy() []*Order {
+	if !p.accept("order") {
+		return nil
+	}
+	p.expect("by")
+	var orders []*Order
+	for {
+		o := &Order{Expr: p.expr()}
+		if !p.accept("asc") {
+			o.Desc = p.accept("desc")
+		}
+		orders = append(orders, o)
+		if !p.accept(",") {
+			return orders
+		}
+	}
+}
+
+func (p *parser) limit() *Limit {
+	if !p.accept("limit") {
+		return nil
+	}
+	l := &Limit{Count: p.primary()}
+	if p.accept(",") {
+		// LIMIT offset, count
+		l.Offset, l.Count = l.Count, p.primary()
+	} else if p.accept("offset") {
+		l.Offset = p.primary()
+	}
+	return l
+}
+
+func (p *parser) assignments() []*Assignment {
+	var set []*Assignment
+	for {
+		c, ok := p.primary().(*Column)
+		if !ok {
+			p.fail("expected column")
+		}
+		p.expect("=")
+		set = append(set, &Assignment{Column: c, Value: p.expr()})
+		if !p.accept(",") {
+			return set
+		}
+	}
+}
+
+// tableExprs parses table references separated by commas.
+func (p *parser) tableExprs() []TableExpr {
+	var tables []TableExpr
+	for {
+		tables = append(tables, p.tableExpr())
+		if !p.accept(",") {
+			return tables
+		}
+	}
+}
+
+// tableExpr parses a table reference and the joins that follow it.
+func (p *parser) tableExpr() TableExpr {
+	t := p.tableFactor()
+	for {
+		var typ string
+		switch {
+		case p.accept("join"):
+			typ = "join"
+		case p.accept("inner"), p.accept("cross"):
+			p.expect("join")
+			typ = "join"
+		case p.accept("straight_join"):
+			typ = "straight_join"
+		case p.at("left", "right"):
+			typ = p.lower()
+			p.accept("outer")
+			p.expect("join")
+			typ += " join"
+		case p.accept("natural"):
+			typ = "natural"
+			if p.at("left", "right") {
+				typ += " " + p.lower()
+				p.accept("outer")
+			} else {
+				p.accept("inner")
+			}
+			p.expect("join")
+			typ += " join"
+		default:
+			return t
+		}
+		j := &Join{Type: typ, Left: t, Right: p.tableFactor()}
+		if p.accept("on") {
+			j.On = p.expr()
+		} else if p.accept("using") {
+			p.expect("(")
+			for {
+				j.Using = append(j.Using, p.ident(false))
+				if !p.accept(",") {
+					break
+				}
+			}
+			p.expect(")")
+		}
+		t = j
+	}
+}
+
+func (p *parser) tableFactor() TableExpr {
+	if p.accept("(") {
+		if !p.at("select") {
+			p.fail("expected SELECT")
+		}
+		d := &DerivedTable{Select: p.selectStatement()}
+		p.expect(")")
+		if d.Alias = p.alias(); d.Alias == "" {
+			p.fail("expected alias")
+		}
+		return d
+	}
+	return p.table(true)
+}
+
+// table parses [db.]table [[AS] alias].
+func (p *parser) table(alias bool) *Table {
+	t := &Table{Name: p.ident(false)}
+	if p.accept(".") {
+		t.Db, t.Name = t.Name, p.ident(true)
+	}
+	if alias {
+		t.Alias = p.alias()
+	}
+	return t
+}
+
+func (p *parser) exprs() []Expr {
+	var exprs []Expr
+	for {
+		exprs = append(exprs, p.expr())
+		if !p.accept(",") {
+			return exprs
+		}
+	}
+}
+
+func (p *parser) expr() Expr {
+	return p.logical(precOr)
+}
+
+// logical parses OR, XOR, and AND, which have precedence prec and higher.
+func (p *parser) logical(prec int) Expr {
+	if prec > precAnd {
+		return p.not()
+	}
+	ops := map[int][]string{precOr: {"or", "||"}, precXor: {"xor"}, precAnd: {"and", "&&"}}[prec]
+	op := map[int]string{precOr: "or", precXor: "xor", precAnd: "and"}[prec]
+	e := p.logical(prec + 1)
+	if !p.at(ops...) {
+		return e
+	}
+	l := &Logical{Op: op, Exprs: []Expr{e}}
+	for p.at(ops...) {
+		p.next()
+		l.Exprs = append(l.Exprs, p.logical(prec+1))
+	}
+	return l
+}
+
+func (p *parser) not() Expr {
+	if p.accept("not") {
+		return &Unary{Op: "not", Expr: p.not()}
+	}
+	return p.predicate()
+}
+
+var compareOps = []string{"=", "<=>", "!=", "<>", "<", "<=", ">", ">="}
+
+// predicate parses comparisons, IS, IN, BETWEEN, LIKE, and REGEXP.
+func (p *parser) predicate() Expr {
+	e := p.binary(precBitOr)
+	for {
+		switch {
+		case p.at(compareOps...):
+			op := p.next().Text
+			if p.at("any", "all", "some") {
+				p.unexpected()
+			}
+			e = &Binary{Op: op, Left: e, Right: p.binary(precBitOr)}
+		case p.accept("is"):
+			is := &Is{Expr: e, Not: p.accept("not")}
+			if !p.at("null", "true", "false", "unknown") {
+				p.fail("expected NULL, TRUE, FALSE, or UNKNOWN")
+			}
+			is.Value = p.lower()
+			e = is
+		default:
+			n := p.peekAt(1)
+			not := p.at("not") &&
+				(is(n, "in") || is(n, "between") || is(n, "like") || is(n, "regexp") || is(n, "rlike"))
+			if not {
+				p.expect("not")
+			}
+			switch {
+			case p.accept("in"):
+				in := &In{Expr: e, Not: not}
+				p.expect("(")
+				if p.at("select") {
+					in.Select = p.selectStatement()
+				} else {
+					in.List = p.exprs()
+				}
+				p.expect(")")
+				e = in
+			case p.accept("between"):
+				b := &Between{Expr: e, Not: not, Low: p.binary(precBitOr)}
+				p.expect("and")
+				b.High = p.binary(precBitOr)
+				e = b
+			case p.at("like", "regexp", "rlike"):
+				op := p.lower()
+				if op == "rlike" {
+					op = "regexp"
+				}
+				if not {
+					op = "not " + op
+				}
+				e = &Binary{Op: op, Left: e, Right: p.binary(precBitOr)}
+				if p.at("escape") {
+					p.unexpected()
+				}
+			default:
+				if not {
+					p.unexpected()
+				}
+				return e
+			}
+		}
+	}
+}
+
+// binaryOps are the binary operators by precedence, excluding comparisons.
+var binaryOps = map[int][]string{
+	precBitOr:  {"|"},
+	precBitAnd: {"&"},
+	precShift:  {"<<", ">>"},
+	precAdd:    {"+", "-"},
+	precMul:    {"*", "/", "div", "%", "mod"},
+	precBitXor: {"^"},
+}
+
+// binary parses binary operators with precedence prec and higher.
+func (p *parser) binary(prec int) Expr {
+	if prec > precBitXor {
+		return p.unary()
+	}
+	e := p.binary(prec + 1)
+	for p.at(binaryOps[prec]...) {
+		op := p.lower()
+		e = &Binary{Op: op, Left: e, Right: p.binary(prec + 1)}
+	}
+	return e
+}
+
+func (p *parser) unary() Expr {
+	t := p.peek()
+	if t.Type == query.TokenOperator {
+		switch t.Text {
+		case "-", "+":
+			// -1 is one value, like in Fingerprint.
+			if n := p.peekAt(1); n.Type == query.TokenNumber && n.Start == t.End {
+				p.pos += 2
+				return &Value{Text: t.Text + n.Text}
+			}
+			fallthrough
+		case "~", "!":
+			p.pos++
+			return &Unary{Op: t.Text, Expr: p.unary()}
+		}
+	}
+	return p.primary()
+}
+
+// keywordFuncs are keywords that are functions without parentheses.
+var keywordFuncs = []string{
+	"current_date", "current_time", "current_timestamp", "current_user",
+	"localtime", "localtimestamp", "utc_date", "utc_time", "utc_timestamp",
+}
+
+func (p *parser) primary() Expr {
+	t := p.peek()
+	switch t.Type {
+	case query.TokenString:
+		// 'a' 'b' is 'ab'.
+		for p.peek().Type == query.TokenString {
+			p.pos++
+		}
+		return &Value{Text: p.q[t.Start:p.tokens[p.pos-1].End]}
+	case query.TokenNumber, query.TokenHex, query.TokenBit, query.TokenPlaceholder:
+		p.pos++
+		return &Value{Text: t.Text}
+	case query.TokenVariable:
+		p.pos++
+		if p.at(":=") {
+			p.unexpected()
+		}
+		return &Variable{Name: t.Text}
+	case query.TokenOperator:
+		if !p.accept("(") {
+			break
+		}
+		if p.at("select") {
+			s := &Subquery{Select: p.selectStatement()}
+			p.expect(")")
+			return s
+		}
+		exprs := p.exprs()
+		p.expect(")")
+		if len(exprs) == 1 {
+			return exprs[0]
+		}
+		return &Tuple{Exprs: exprs}
+	case query.TokenKeyword, query.TokenIdent, query.TokenQuotedIdent:
+		return p.word()
+	}
+	p.fail("expected expression")
+	return nil
+}
+
+// word parses an expression that begins with a word: a literal like NULL, a
+// function call, a column, CASE, EXISTS, or INTERVAL.
+func (p *parser) word() Expr {
+	t := p.peek()
+	n := p.peekAt(1)
+	switch {
+	case p.at("null", "true", "false"):
+		p.pos++
+		return &Value{Text: strings.ToLower(t.Text)}
+	case p.at("date", "time", "timestamp") && n.Type == query.TokenString:
+		// DATE '2020-01-01'
+		p.pos += 2
+		return &Value{Text: strings.ToLower(t.Text) + " " + n.Text}
+	case p.accept("exists"):
+		p.expect("(")
+		e := &Exists{Select: p.selectStatement()}
+		p.expect(")")
+		return e
+	case p.accept("case"):
+		c := &Case{}
+		if !p.at("when") {
+			c.Operand = p.expr()
+		}
+		for p.accept("when") {
+			w := &When{Cond: p.expr()}
+			p.expect("then")
+			w.Result = p.expr()
+			c.Whens = append(c.Whens, w)
+		}
+		if p.accept("else") {
+			c.Else = p.expr()
+		}
+		p.expect("end")
+		return c
+	case p.accept("interval"):
+		i := &Interval{Expr: p.binary(precBitOr)}
+		if u := p.peek(); u.Type != query.TokenIdent && u.Type != query.TokenKeyword {
+			p.fail("expected interval unit")
+		}
+		i.Unit = p.lower()
+		return i
+	case t.Type != query.TokenQuotedIdent && is(n, "("):
+		return p.function()
+	case p.at(keywordFuncs...):
+		p.pos++
+		return &Keyword{Name: strings.ToLower(t.Text)}
+	case t.Type == query.TokenKeyword:
+		p.unexpected()
+	}
+
+	// [[db.]table.]column or table.*
+	parts := []string{p.ident(false)}
+	for len(parts) < 3 && p.accept(".") {
+		if p.accept("*") {
+			return &Star{Table: parts[len(parts)-1]}
+		}
+		parts = append(parts, p.ident(true))
+	}
+	c := &Column{Name: parts[len(parts)-1]}
+	if len(parts) > 1 {
+		c.Table = parts[len(parts)-2]
+	}
+	if len(parts) > 2 {
+		c.Db = parts[0]
+	}
+	return c
+}
+
+// function parses a function call like COUNT(*) or CONCAT(a, b).
+func (p *parser) function() Expr {
+	f := &Func{Name: p.lower()}
+	p.expect("(")
+	switch {
+	case p.accept(")"):
+		return f
+	case p.at("*") && is(p.peekAt(1), ")"):
+		p.next()
+		f.Args = []Expr{&Star{}}
+	default:
+		f.Distinct = p.accept("distinct")
+		f.Args = p.exprs()
+	}
+	p.expect(")")
+	return f
+}
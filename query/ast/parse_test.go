@@ -0,0 +1,141 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package ast_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/go-mysql/query/ast"
+)
+
+func TestParse(t *testing.T) {
+	// Parse then String formats the query, without normalizing it.
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{
+			query:    "SELECT a,b FROM t WHERE x=1 AND y='b'",
+			expected: "select a, b from t where x = 1 and y = 'b'",
+		},
+		{
+			query:    "SELECT DISTINCTROW `Order`.ID AS 'id' FROM db.`order` `Order` WHERE ((x > 1)) ORDER BY id ASC, y DESC LIMIT 5, 10",
+			expected: "select distinct `order`.id as id from db.`order` as `order` where x > 1 order by id, y desc limit 10 offset 5",
+		},
+		{
+			query:    "select count(*), count(distinct a), max(a+1)*2 from t group by b having count(*) > 1",
+			expected: "select count(*), count(distinct a), max(a + 1) * 2 from t group by b having count(*) > 1",
+		},
+		{
+			query:    "select * from a left outer join b using (id) cross join c natural join d straight_join e on e.id = a.id",
+			expected: "select * from a left join b using (id) join c natural join d straight_join e on e.id = a.id",
+		},
+		{
+			query:    "select a from t where not (a > 5 or b < 3) and c not between 1 and 2 and d is not null and e not like 'x%' and f rlike 'y'",
+			expected: "select a from t where not (a > 5 or b < 3) and c not between 1 and 2 and d is not null and e not like 'x%' and f regexp 'y'",
+		},
+		{
+			query:    "select a from t where a in (select b from u) and exists (select 1 from v) and (a, b) not in ((1, 2), (3, 4))",
+			expected: "select a from t where a in(select b from u) and exists(select 1 from v) and (a, b) not in((1, 2), (3, 4))",
+		},
+		{
+			query:    "select case a when 1 then 'x' end, date_add(now(), interval 1 day), current_timestamp, @a, date '2020-01-01' from t for update",
+			expected: "select case a when 1 then 'x' end, date_add(now(), interval 1 day), current_timestamp, @a, date '2020-01-01' from t for update",
+		},
+		{
+			query:    "select -1, - -1, -a, -(-a), 1 - (2 - 3), (1 - 2) - 3, (a or b) and c, a or (b and c)",
+			expected: "select -1, - -1, -a, -(-a), 1 - (2 - 3), 1 - 2 - 3, (a or b) and c, a or b and c",
+		},
+		{
+			query:    "select a from t1 union select a from t2 union all select a from t3;",
+			expected: "select a from t1 union select a from t2 union all select a from t3",
+		},
+		{
+			query:    "select * from (select a from t) x where x.a = 1",
+			expected: "select * from (select a from t) as x where x.a = 1",
+		},
+		{
+			query:    "INSERT IGNORE INTO t (a,b) VALUES (1,'x'),(2,'y') ON DUPLICATE KEY UPDATE b=VALUES(b)",
+			expected: "insert ignore into t(a, b) values (1, 'x'), (2, 'y') on duplicate key update b = values(b)",
+		},
+		{
+			query:    "replace into t set a = 1, b = now()",
+			expected: "replace into t set a = 1, b = now()",
+		},
+		{
+			query:    "insert into t (a) select b from u",
+			expected: "insert into t(a) select b from u",
+		},
+		{
+			query:    "UPDATE t1 a JOIN t2 b ON a.id=b.id SET a.x = b.x + 1 WHERE b.y <> 3",
+			expected: "update t1 as a join t2 as b on a.id = b.id set a.x = b.x + 1 where b.y <> 3",
+		},
+		{
+			query:    "/* comment */ DELETE /*+ hint */ QUICK FROM t WHERE id = ? ORDER BY id LIMIT 1",
+			expected: "delete quick from t where id = ? order by id limit 1",
+		},
+	}
+	for _, test := range tests {
+		stmt, err := ast.Parse(test.query)
+		require.NoError(t, err, test.query)
+		assert.Equal(t, test.expected, stmt.String(), test.query)
+
+		// The formatted query is parsed to the same tree.
+		again, err := ast.Parse(stmt.String())
+		require.NoError(t, err, stmt.String())
+		assert.Equal(t, stmt, again, stmt.String())
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		err   string
+	}{
+		{"SHOW TABLES", `offset 0: expected SELECT, INSERT, REPLACE, UPDATE, or DELETE, found "SHOW"`},
+		{"select a from", "offset 13: expected identifier, found end of query"},
+		{"select a from t where", "offset 21: expected expression, found end of query"},
+		{"select a from t where a in (1, 2", "offset 32: expected ), found end of query"},
+		{"select a from t extra tokens", `offset 22: unexpected token, found "tokens"`},
+		{"select /*!40001 SQL_NO_CACHE */ * from t", "offset 7: version comments are not supported"},
+		{"with x as (select 1) select * from x", `offset 0: expected SELECT, INSERT, REPLACE, UPDATE, or DELETE, found "with"`},
+		{"delete a from a join b on a.id = b.id", `offset 7: expected from, found "a"`},
+		{"select a from t where a = any (select b from u)", `offset 26: unexpected token, found "any"`},
+	}
+	for _, test := range tests {
+		stmt, err := ast.Parse(test.query)
+		assert.Nil(t, stmt, test.query)
+		assert.EqualError(t, err, test.err, test.query)
+	}
+}
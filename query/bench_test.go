@@ -0,0 +1,187 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/go-mysql/log"
+	"github.com/percona/go-mysql/log/slow"
+	"github.com/percona/go-mysql/query"
+	"github.com/percona/go-mysql/test"
+)
+
+// slowLogQueries returns the queries of the sample slow logs, a realistic
+// corpus for benchmarks.
+func slowLogQueries(tb testing.TB) []string {
+	files, err := filepath.Glob(filepath.Join(test.RootDir(), "test", "slow-logs", "*.log"))
+	require.NoError(tb, err)
+	var queries []string
+	for _, name := range files {
+		file, err := os.Open(name)
+		require.NoError(tb, err)
+		p := slow.NewSlowLogParser(file, log.Options{DefaultLocation: time.UTC})
+		go p.Start()
+		for e := range p.EventChan() {
+			if e.Query != "" {
+				queries = append(queries, e.Query)
+			}
+		}
+		file.Close()
+	}
+	require.NotEmpty(tb, queries)
+	return queries
+}
+
+func TestAppendFingerprint(t *testing.T) {
+	queries := slowLogQueries(t)
+	var buf []byte
+	for _, q := range queries {
+		buf = query.AppendFingerprint(buf[:0], []byte(q))
+		assert.Equal(t, query.Fingerprint(q), string(buf), q)
+	}
+
+	// Appends to dst.
+	buf = query.AppendFingerprint([]byte("fp: "), []byte("SELECT 1"))
+	assert.Equal(t, "fp: select ?", string(buf))
+
+	// Allocation-free once dst is large enough.
+	qs := make([][]byte, len(queries))
+	for i, q := range queries {
+		qs[i] = []byte(q)
+	}
+	buf = make([]byte, 0, 64*1024)
+	fp := query.Fingerprinter{Lists: query.ListArity}
+	allocs := testing.AllocsPerRun(10, func() {
+		for _, q := range qs {
+			buf = query.AppendFingerprint(buf[:0], q)
+			buf = fp.AppendFingerprint(buf[:0], q)
+		}
+	})
+	assert.Zero(t, allocs)
+
+	// The fingerprint does not reference q, so q can be reused.
+	for _, q := range []string{
+		"SELECT a FROM t WHERE b = 'x' AND c IN (1, 2)",
+		"use db",
+		"administrator command: Quit",
+		"PREPARE s FROM 'SELECT * FROM t'",
+	} {
+		b := []byte(q)
+		for _, fp := range []query.Fingerprinter{{}, {Version: query.FingerprintV1}, {KeepCase: true}} {
+			buf = fp.AppendFingerprint(nil, b)
+			expected := string(buf)
+			for i := range b {
+				b[i] = 'X'
+			}
+			assert.Equal(t, expected, string(buf), q)
+			copy(b, q)
+		}
+	}
+}
+
+func BenchmarkFingerprint(b *testing.B) {
+	queries := slowLogQueries(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, q := range queries {
+			query.Fingerprint(q)
+		}
+	}
+}
+
+func BenchmarkAppendFingerprint(b *testing.B) {
+	queries := slowLogQueries(b)
+	qs := make([][]byte, len(queries))
+	for i, q := range queries {
+		qs[i] = []byte(q)
+	}
+	var buf []byte
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, q := range qs {
+			buf = query.AppendFingerprint(buf[:0], q)
+		}
+	}
+}
+
+// BenchmarkFingerprintValues fingerprints the longest query of the corpus, the
+// multi-row VALUES query of slow015.log, where skipping the rows dominates.
+func BenchmarkFingerprintValues(b *testing.B) {
+	var q []byte
+	for _, s := range slowLogQueries(b) {
+		if len(s) > len(q) {
+			q = []byte(s)
+		}
+	}
+	buf := make([]byte, 0, len(q))
+	b.SetBytes(int64(len(q)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf = query.AppendFingerprint(buf[:0], q)
+	}
+}
+
+// manyComments returns a query with n lines of comments between two columns.
+func manyComments(n int) string {
+	var b strings.Builder
+	b.WriteString("select a")
+	for i := 0; i < n; i++ {
+		b.WriteString(" -- c\n/* c */ # c\n")
+	}
+	b.WriteString(", b from t")
+	return b.String()
+}
+
+// BenchmarkFingerprintManyComments shows that the time per comment does not
+// grow with the number of comments.
+func BenchmarkFingerprintManyComments(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		q := manyComments(n)
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.SetBytes(int64(len(q)))
+			for i := 0; i < b.N; i++ {
+				query.Fingerprint(q)
+			}
+		})
+	}
+}
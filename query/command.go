@@ -0,0 +1,195 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query
+
+import (
+	"strings"
+)
+
+// adminPrefix begins administrator commands in slow logs, like
+// "# administrator command: Quit;".
+const adminPrefix = "administrator command:"
+
+// adminCommands are the names of MySQL commands in slow logs, by lowercase
+// name.
+var adminCommands = map[string]string{}
+
+func init() {
+	for _, name := range []string{
+		"Sleep", "Quit", "Init DB", "Query", "Field List", "Create DB",
+		"Drop DB", "Refresh", "Shutdown", "Statistics", "Processlist",
+		"Connect", "Kill", "Debug", "Ping", "Time", "Delayed insert",
+		"Change user", "Binlog Dump", "Table Dump", "Connect Out",
+		"Register Slave", "Register Replica", "Prepare", "Execute",
+		"Long Data", "Close stmt", "Reset stmt", "Set option", "Fetch",
+		"Daemon", "Binlog Dump GTID", "Reset Connection", "Clone",
+	} {
+		adminCommands[strings.ToLower(name)] = name
+	}
+}
+
+// FingerprintEvent returns the fingerprint of the query of a log event, like
+// Fingerprint. If admin is true, like for log.Event.Admin, q is an
+// administrator command, like Quit, so its fingerprint is
+// "administrator command: Quit", like pt-query-digest.
+func FingerprintEvent(q string, admin bool) string {
+	return Fingerprinter{ReplaceNumbersInWords: ReplaceNumbersInWords}.FingerprintEvent(q, admin)
+}
+
+// FingerprintEvent returns the fingerprint of the query of a log event, see
+// the package func FingerprintEvent.
+func (fp Fingerprinter) FingerprintEvent(q string, admin bool) string {
+	if admin {
+		return fp.Fingerprint(adminPrefix + " " + q)
+	}
+	return fp.Fingerprint(q)
+}
+
+// appendAdmin appends the fingerprint of q to dst if q is an administrator
+// command, optionally in a comment like "# administrator command: Quit;". The
+// fingerprint is the command with spaces collapsed, without the semicolon,
+// and with the case of MySQL, or lowercase if MySQL does not have it, like
+// "administrator command: Binlog Dump". It returns false if q is not an
+// administrator command.
+func appendAdmin(dst []byte, q string) ([]byte, bool) {
+	s := strings.TrimLeft(q, " \t\r\n")
+	if strings.HasPrefix(s, "#") {
+		s = strings.TrimLeft(s[1:], " \t")
+	}
+	if len(s) < len(adminPrefix) || !strings.EqualFold(s[:len(adminPrefix)], adminPrefix) {
+		return dst, false
+	}
+	dst = append(dst, adminPrefix...)
+	start := len(dst)
+	s = strings.TrimRight(s[len(adminPrefix):], "; \t\r\n")
+	space := true
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isSpaceByte(c) {
+			space = true
+			continue
+		}
+		if space {
+			dst = append(dst, ' ')
+			space = false
+		}
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		dst = append(dst, c)
+	}
+	if len(dst) > start {
+		if name, ok := adminCommands[string(dst[start+1:])]; ok {
+			dst = append(dst[:start+1], name...)
+		}
+	}
+	return dst, true
+}
+
+// command begins the fingerprint of statements that are commands, not
+// queries, where t is the first significant token and l is the lexer after
+// it. It returns true if the fingerprint is complete.
+func (f *fingerprinter) command(t Token, l Lexer) bool {
+	n := l.NextSignificant()
+	switch {
+	case t.Is("prepare") || (t.Is("execute") && n.Is("immediate")):
+		// The SQL in PREPARE s FROM 'sql' is fingerprinted in run.
+		f.prepare = true
+	case t.Is("execute"):
+		// EXECUTE args are values, like CALL args, so only the name matters.
+		f.writeCase(t.Text)
+		if isName(n) {
+			f.dst = append(f.dst, ' ')
+			f.appendCase(n.Text)
+		}
+		return true
+	case (t.Is("deallocate") || t.Is("drop")) && n.Is("prepare"):
+		// DROP PREPARE is a synonym.
+		if t.Is("drop") {
+			f.write("deallocate")
+		} else {
+			f.writeCase(t.Text)
+		}
+		f.dst = append(f.dst, ' ')
+		f.appendCase(n.Text)
+		if name := l.NextSignificant(); isName(name) {
+			f.dst = append(f.dst, ' ')
+			f.appendCase(name.Text)
+		}
+		return true
+	case t.Is("show"):
+		// SESSION, LIKE, and WHERE are handled in word.
+		f.show = true
+	}
+	return false
+}
+
+// isName returns true if t can be the name of a prepared statement.
+func isName(t Token) bool {
+	return t.Type == TokenIdent || t.Type == TokenKeyword || t.Type == TokenQuotedIdent
+}
+
+// prepared writes the fingerprint of the SQL in string t, like 'sql' in
+// PREPARE s FROM 'sql', instead of ?. The SQL is fingerprinted like a query,
+// so PREPARE s FROM 'CALL p(1)' is prepare s from call p. The literals in the
+// SQL have offsets in the query unless the string has escape sequences; then
+// they have the offsets of the string.
+func (f *fingerprinter) prepared(t Token) {
+	f.prepare = false
+	sql := unescape(t.Text)
+	n := 0
+	if f.lits != nil {
+		n = len(*f.lits)
+	}
+	escaped := sql != stringValue(t.Text)
+	base := 0
+	if !escaped {
+		base = f.base + t.Start + strings.IndexAny(t.Text, `'"`) + 1
+	}
+	if f.space && len(f.dst) > f.start {
+		f.dst = append(f.dst, ' ')
+	}
+	f.dst = f.fp.fingerprint(f.dst, sql, base, f.lits)
+	f.space = false
+	if f.lits != nil && escaped {
+		moveLiterals((*f.lits)[n:], f.base+t.Start, f.base+t.End)
+	}
+}
+
+// moveLiterals sets the offsets of lits, and the literals in their lists, to
+// start and end.
+func moveLiterals(lits []Literal, start, end int) {
+	for i := range lits {
+		lits[i].Start = start
+		lits[i].End = end
+		moveLiterals(lits[i].List, start, end)
+	}
+}
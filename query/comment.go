@@ -0,0 +1,181 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/percona/go-mysql/log"
+)
+
+// CommentLabels returns the labels in the comments of q, which applications
+// add to identify the code and request that executed the query:
+//   - sqlcommenter: /*action='show',traceparent='00-4bf9-00f0-01'*/, with
+//     URL-encoded keys and values, and values in single quotes
+//   - marginalia: /*application:shop,controller:orders,action:show*/
+//
+// Keys and values are separated by = or :, and pairs by commas. Values can be
+// quoted or not, like controller=orders. Only /* */ comments in which every
+// pair is valid are labels. A comment with a single pair is only a label if
+// the value is quoted or the key is a marginalia key, like application, so
+// other comments, like /* check: slow */ or /* TODO: fix */, are not. If a key
+// is in several comments, the last value wins. CommentLabels returns nil if q
+// has no labels.
+//
+// Fingerprint removes comments, so queries with different labels have the
+// same fingerprint.
+func CommentLabels(q string) log.Labels {
+	var ls log.Labels
+	l := Lexer{q: q}
+	for t := l.Next(); t.Type != TokenEOF; t = l.Next() {
+		if t.Type != TokenComment || !strings.HasPrefix(t.Text, "/*") {
+			continue
+		}
+		body := strings.TrimSuffix(t.Text[2:], "*/")
+		if pairs, ok := commentPairs(body); ok {
+			for i := 0; i < len(pairs); i += 2 {
+				ls = ls.Set(pairs[i], pairs[i+1])
+			}
+		}
+	}
+	return ls
+}
+
+// marginaliaKeys are the keys that marginalia and Rails query logs add, which
+// are labels even if they are the only pair in a comment.
+var marginaliaKeys = map[string]bool{
+	"action":                    true,
+	"application":               true,
+	"controller":                true,
+	"controller_with_namespace": true,
+	"database":                  true,
+	"db_host":                   true,
+	"hostname":                  true,
+	"job":                       true,
+	"line":                      true,
+	"namespaced_controller":     true,
+	"pid":                       true,
+	"socket":                    true,
+	"source_location":           true,
+}
+
+// commentPairs returns the keys and values in the body of a comment, like
+// key1, value1, key2, value2. It returns false if the body is not a list of
+// key-value pairs, or if it is a single pair with an unquoted value and a key
+// that is not in marginaliaKeys.
+func commentPairs(body string) ([]string, bool) {
+	var pairs []string
+	quoted := false
+	s := strings.TrimSpace(body)
+	for s != "" {
+		i := strings.IndexAny(s, "=:")
+		if i <= 0 {
+			return nil, false
+		}
+		key := strings.TrimSpace(s[:i])
+		if !isLabelKey(key) {
+			return nil, false
+		}
+		s = strings.TrimLeft(s[i+1:], " \t")
+
+		var value string
+		if strings.HasPrefix(s, "'") {
+			// 'value' with \' for quotes.
+			end := 1
+			for end < len(s) && s[end] != '\'' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, false
+			}
+			value = strings.ReplaceAll(s[1:end], `\'`, "'")
+			s = s[end+1:]
+			quoted = true
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			if value == "" || strings.ContainsAny(value, " \t\r\n'") {
+				return nil, false
+			}
+			s = s[end:]
+		}
+		pairs = append(pairs, unescapeURL(key), unescapeURL(value))
+
+		s = strings.TrimSpace(s)
+		if s == "" {
+			break
+		}
+		if s[0] != ',' {
+			return nil, false
+		}
+		s = strings.TrimSpace(s[1:])
+	}
+	switch len(pairs) {
+	case 0:
+		return nil, false
+	case 2:
+		return pairs, quoted || marginaliaKeys[pairs[0]]
+	}
+	return pairs, true
+}
+
+// isLabelKey returns true if key can be a label key, like db_driver or
+// route%2Fname.
+func isLabelKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if c := key[i]; !isIdentByte(c) && c != '.' && c != '-' && c != '%' {
+			return false
+		}
+	}
+	return true
+}
+
+// unescapeURL returns s with %XX escapes replaced, or s if it has invalid
+// escapes. Like sqlcommenter, + is not a space.
+func unescapeURL(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	if u, err := url.PathUnescape(s); err == nil {
+		return u
+	}
+	return s
+}
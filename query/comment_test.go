@@ -0,0 +1,99 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/percona/go-mysql/log"
+	"github.com/percona/go-mysql/query"
+)
+
+func TestCommentLabels(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    string
+		expected log.Labels
+	}{
+		{
+			name:     "sqlcommenter",
+			query:    "SELECT * FROM t /*controller='orders',db_driver='go%2Fsql',traceparent='00-4bf9-00f0-01'*/",
+			expected: log.LabelsFromPairs("controller", "orders", "db_driver", "go/sql", "traceparent", "00-4bf9-00f0-01"),
+		},
+		{
+			name:     "sqlcommenter escaped quote",
+			query:    `SELECT 1 /*route='it\'s',name='a%20b+c'*/`,
+			expected: log.LabelsFromPairs("name", "a b+c", "route", "it's"),
+		},
+		{
+			name:     "marginalia",
+			query:    "/*application:shop,controller:orders,action:show,line:/app/x.rb:12*/ SELECT 1",
+			expected: log.LabelsFromPairs("action", "show", "application", "shop", "controller", "orders", "line", "/app/x.rb:12"),
+		},
+		{
+			name:     "unquoted values with spaces",
+			query:    "SELECT 1 /* controller=orders, action=show, traceparent='00-1' */",
+			expected: log.LabelsFromPairs("action", "show", "controller", "orders", "traceparent", "00-1"),
+		},
+		{
+			name:     "several comments",
+			query:    "/* application:shop */ SELECT 1 /* application:cart,env:prod */",
+			expected: log.LabelsFromPairs("application", "cart", "env", "prod"),
+		},
+		{
+			name:     "single quoted pair",
+			query:    "SELECT 1 /* traceparent='00-1' */",
+			expected: log.LabelsFromPairs("traceparent", "00-1"),
+		},
+		{
+			name:     "not labels",
+			query:    "SELECT 1 /* check: slow query */ -- a:b\n /*+ BKA(t) */ /*!40001 a:b */ /* a='b */",
+			expected: nil,
+		},
+		{
+			name:     "single unquoted pair",
+			query:    "SELECT 1 /* check: slow */ /* TODO: fix */ /* http://example.com */",
+			expected: nil,
+		},
+		{
+			name:     "string is not a comment",
+			query:    "SELECT '/* a:b */'",
+			expected: nil,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, query.CommentLabels(tc.query))
+		})
+	}
+}
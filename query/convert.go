@@ -0,0 +1,201 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query
+
+import (
+	"strings"
+)
+
+// ConvertToSelect returns a SELECT that reads the rows that q reads, like
+// pt-query-digest --convert-to-select, so that the plan of a write can be
+// explained without running it:
+//   - UPDATE t SET a = 1 WHERE b = 2 is select a = 1 from t where b = 2
+//   - DELETE FROM t WHERE b = 2 is select 1 from t where b = 2, and
+//     multi-table DELETE selects from its table list
+//   - INSERT ... SELECT and REPLACE ... SELECT are their SELECT, without
+//     ON DUPLICATE KEY UPDATE
+//   - SELECT, TABLE, and VALUES are returned as is
+//
+// Common table expressions are kept. It returns false if q cannot be
+// converted, like INSERT ... VALUES, DDL, SELECT ... INTO, and UPDATE that sets
+// a column to DEFAULT, which is not a value in SELECT.
+func ConvertToSelect(q string) (string, bool) {
+	q = strings.TrimRight(q, " \t\r\n;")
+	d := newDescriber(q)
+	if len(d.tokens) == 0 {
+		return "", false
+	}
+
+	c := converter{describer: d, q: q}
+	i := 0
+	prefix := ""
+	if d.is(0, "with") {
+		i = d.cteNames(0)
+		if i >= len(d.tokens) {
+			return "", false
+		}
+		prefix = q[d.tokens[0].Start:d.tokens[i].Start]
+	}
+	var s string
+	var ok bool
+	switch {
+	case d.is(i, "select", "table", "values") || d.tok(i).Text == "(":
+		s, ok = c.query(i)
+	case d.is(i, "update"):
+		s, ok = c.update(i)
+	case d.is(i, "delete"):
+		s, ok = c.delete(i)
+	case d.is(i, "insert", "replace"):
+		s, ok = c.insert(i)
+	}
+	if !ok {
+		return "", false
+	}
+	return prefix + s, true
+}
+
+// converter holds the state of one ConvertToSelect call.
+type converter struct {
+	*describer
+	q string
+}
+
+// next returns the index of the first token at depth 0 in [i, end of query)
+// that is one of the words, or -1.
+func (c *converter) next(i int, words ...string) int {
+	depth := 0
+	for ; i < len(c.tokens); i++ {
+		switch c.tokens[i].Text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+		if depth == 0 && c.is(i, words...) {
+			return i
+		}
+	}
+	return -1
+}
+
+// from returns the query from the start of the token at i.
+func (c *converter) from(i int) string {
+	return c.q[c.tokens[i].Start:]
+}
+
+// query returns SELECT, TABLE, and VALUES at i as is, unless it selects INTO
+// variables or files.
+func (c *converter) query(i int) (string, bool) {
+	if c.next(i, "into") >= 0 {
+		return "", false
+	}
+	return c.from(i), true
+}
+
+// update converts UPDATE at i.
+func (c *converter) update(i int) (string, bool) {
+	set := c.next(i, "set")
+	if set < 0 || set+1 >= len(c.tokens) {
+		return "", false
+	}
+	i++
+	for c.is(i, "low_priority", "ignore") {
+		i++
+	}
+	if i >= set {
+		return "", false
+	}
+	tables := strings.TrimSpace(c.q[c.tokens[i].Start:c.tokens[set].Start])
+
+	end := c.next(set, "where", "order", "limit")
+	assignments, rest := c.from(set+1), ""
+	if end >= 0 {
+		assignments = c.q[c.tokens[set+1].Start:c.tokens[end].Start]
+		rest = " " + c.from(end)
+	}
+	for j := c.next(set, "default"); j >= 0; j = c.next(j+1, "default") {
+		if c.tok(j+1).Text != "(" { // DEFAULT(col) is a function
+			return "", false
+		}
+	}
+	return "select " + strings.TrimSpace(assignments) + " from " + tables + rest, true
+}
+
+// delete converts DELETE at i.
+func (c *converter) delete(i int) (string, bool) {
+	i++
+	for c.is(i, "low_priority", "quick", "ignore") {
+		i++
+	}
+	from := i
+	if c.is(i, "from") {
+		// DELETE FROM t1, t2 USING t1 JOIN t2 WHERE ...
+		if using := c.next(i, "using", "where"); using >= 0 && c.is(using, "using") {
+			from = using
+		}
+	} else {
+		// DELETE t1, t2 FROM t1 JOIN t2 WHERE ...
+		from = c.next(i, "from")
+	}
+	if from < 0 || from+1 >= len(c.tokens) || !c.is(from, "from", "using") {
+		return "", false
+	}
+	return "select 1 from " + c.from(from+1), true
+}
+
+// insert converts INSERT ... SELECT and REPLACE ... SELECT at i.
+func (c *converter) insert(i int) (string, bool) {
+	start := -1
+	for j := i + 1; j < len(c.tokens) && start < 0; j++ {
+		switch {
+		case c.tokens[j].Text == "(":
+			if c.is(j+1, "select", "with") {
+				start = j
+			} else {
+				j = c.closeParen(j) // column list or partitions
+			}
+		case c.is(j, "select", "with", "table"):
+			start = j
+		case c.is(j, "values", "value", "set"):
+			return "", false
+		}
+	}
+	if start < 0 {
+		return "", false
+	}
+	// Skip ON of joins in the SELECT.
+	for on := c.next(start, "on"); on >= 0; on = c.next(on+1, "on") {
+		if c.is(on+1, "duplicate") {
+			return strings.TrimSpace(c.q[c.tokens[start].Start:c.tokens[on].Start]), true
+		}
+	}
+	return c.from(start), true
+}
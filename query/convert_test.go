@@ -0,0 +1,138 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/percona/go-mysql/query"
+)
+
+func TestConvertToSelect(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    string
+		expected string // "" if not convertible
+	}{
+		{
+			name:     "update",
+			query:    "UPDATE LOW_PRIORITY t SET a = 1, b = b + 1 WHERE id = 5 ORDER BY id LIMIT 10;",
+			expected: "select a = 1, b = b + 1 from t WHERE id = 5 ORDER BY id LIMIT 10",
+		},
+		{
+			name:     "multi-table update",
+			query:    "UPDATE t1 JOIN t2 ON t1.a = t2.a SET t1.b = DEFAULT(t1.b), t2.c = (SELECT MAX(x) FROM u)",
+			expected: "select t1.b = DEFAULT(t1.b), t2.c = (SELECT MAX(x) FROM u) from t1 JOIN t2 ON t1.a = t2.a",
+		},
+		{
+			name:  "update set default",
+			query: "UPDATE t SET a = DEFAULT WHERE id = 1",
+		},
+		{
+			name:     "delete",
+			query:    "DELETE QUICK FROM t WHERE id = 1 LIMIT 1",
+			expected: "select 1 from t WHERE id = 1 LIMIT 1",
+		},
+		{
+			name:     "multi-table delete",
+			query:    "DELETE t1, t2 FROM t1 JOIN t2 ON t1.id = t2.id WHERE t1.a = 1",
+			expected: "select 1 from t1 JOIN t2 ON t1.id = t2.id WHERE t1.a = 1",
+		},
+		{
+			name:     "multi-table delete using",
+			query:    "DELETE FROM t1, t2 USING t1 JOIN t2 JOIN t3 WHERE t1.id = t2.id",
+			expected: "select 1 from t1 JOIN t2 JOIN t3 WHERE t1.id = t2.id",
+		},
+		{
+			name:     "insert select",
+			query:    "INSERT INTO t (a, b) SELECT x, y FROM u WHERE z = 1 ON DUPLICATE KEY UPDATE b = VALUES(b)",
+			expected: "SELECT x, y FROM u WHERE z = 1",
+		},
+		{
+			name:     "insert select join on duplicate key",
+			query:    "INSERT INTO t SELECT a.x FROM a JOIN b ON a.id=b.id ON DUPLICATE KEY UPDATE x=VALUES(x)",
+			expected: "SELECT a.x FROM a JOIN b ON a.id=b.id",
+		},
+		{
+			name:     "replace select",
+			query:    "REPLACE INTO t PARTITION (p0) (SELECT * FROM u)",
+			expected: "(SELECT * FROM u)",
+		},
+		{
+			name:     "insert with",
+			query:    "INSERT INTO t WITH c AS (SELECT 1) SELECT * FROM c",
+			expected: "WITH c AS (SELECT 1) SELECT * FROM c",
+		},
+		{
+			name:  "insert values",
+			query: "INSERT INTO t (a) VALUES (1)",
+		},
+		{
+			name:  "insert set",
+			query: "INSERT INTO t SET a = (SELECT 1)",
+		},
+		{
+			name:     "with update",
+			query:    "WITH c AS (SELECT id FROM u) UPDATE t JOIN c USING (id) SET t.a = 1",
+			expected: "WITH c AS (SELECT id FROM u) select t.a = 1 from t JOIN c USING (id)",
+		},
+		{
+			name:     "select",
+			query:    "SELECT a FROM t WHERE b = 1 FOR UPDATE",
+			expected: "SELECT a FROM t WHERE b = 1 FOR UPDATE",
+		},
+		{
+			name:  "select into",
+			query: "SELECT a INTO @x FROM t",
+		},
+		{
+			name:  "ddl",
+			query: "CREATE TABLE t (a INT)",
+		},
+		{
+			name:  "truncated",
+			query: "UPDATE t",
+		},
+		{
+			name:  "empty",
+			query: "",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, ok := query.ConvertToSelect(tc.query)
+			assert.Equal(t, tc.expected != "", ok)
+			assert.Equal(t, tc.expected, s)
+		})
+	}
+}
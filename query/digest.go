@@ -0,0 +1,252 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query
+
+import (
+	"strings"
+)
+
+// DigestText returns q normalized in the style of MySQL Performance Schema
+// DIGEST_TEXT, to help match slow log classes with rows of
+// events_statements_summary_by_digest by hand or by similarity:
+//   - Keywords are uppercase, and identifiers are quoted with backticks
+//   - Tokens are separated by a single space, like COUNT ( * )
+//   - Values, including NULL and signed numbers, are replaced with ?
+//   - Comments and optimizer hints are removed, and the code in version
+//     comments is normalized like the query
+//   - Lists of values are reduced: ?, ... for values, (?) and (...) for
+//     rows, /* , ... */ for more rows, and IN (...) for IN lists
+//
+// Like in MySQL, functions that MySQL lexes as keywords, like COUNT and NOW,
+// are uppercase, and other functions, like `sleep`, are identifiers. It is not
+// the DIGEST_TEXT of a server: MySQL normalizes the tokens of its own parser,
+// so DigestText can differ for words that are keywords in MySQL but not in
+// DigestText, and it does not truncate long digests
+// (performance_schema_max_digest_length).
+func DigestText(q string) string {
+	d := digester{}
+	d.add(q)
+	return strings.Join(d.out, " ")
+}
+
+// Reduced tokens, see sql/sql_digest.cc in MySQL.
+const (
+	digestValue         = "?"
+	digestValueList     = "?, ..."
+	digestRowSingle     = "(?)"
+	digestRowSingleList = "(?) /* , ... */"
+	digestRowMulti      = "(...)"
+	digestRowMultiList  = "(...) /* , ... */"
+	digestIn            = "IN (...)"
+)
+
+// digestKeywords are words that MySQL digests as keywords but are not
+// keywords in Lexer because they are not reserved words.
+var digestKeywords = map[string]bool{}
+
+// digestFunctions are functions that MySQL lexes as keywords if they are
+// followed by "(", see sql_functions in sql/lex.h.
+var digestFunctions = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`
+		avg status variables names tables columns databases processlist global session local
+		full engine engines indexes warnings errors master slave replica logs plugins privileges
+		grants charset collation sql_no_cache sql_cache sql_buffer_result ascii date day format
+		hour microsecond minute month password quarter second time timestamp timestampadd
+		timestampdiff user week weight_string year
+	`) {
+		digestKeywords[w] = true
+	}
+	for _, w := range strings.Fields(`
+		adddate bit_and bit_or bit_xor cast count curdate curtime date_add date_sub extract
+		group_concat json_arrayagg json_objectagg max mid min now position session_user std stddev
+		stddev_pop stddev_samp st_collect subdate substr substring sum sysdate system_user trim
+		variance var_pop var_samp
+	`) {
+		digestFunctions[w] = true
+	}
+}
+
+// digester holds the state of one DigestText call.
+type digester struct {
+	out  []string
+	prev Token // previous significant token
+}
+
+// add adds the normalized tokens of q.
+func (d *digester) add(q string) {
+	l := Lexer{q: q}
+	for {
+		t := l.Next()
+		switch t.Type {
+		case TokenEOF:
+			return
+		case TokenSpace, TokenComment, TokenHint:
+			continue
+		case TokenVersionComment:
+			// /*!50001 code */ is executed, so it is normalized like the query.
+			start := 3
+			for start < len(t.Text) && (isDigitByte(t.Text[start]) || t.Text[start] == '!') {
+				start++
+			}
+			end := len(t.Text)
+			if strings.HasSuffix(t.Text, "*/") && end-2 >= start {
+				end -= 2
+			}
+			d.add(t.Text[start:end])
+			continue
+		case TokenString, TokenNumber, TokenHex, TokenBit, TokenPlaceholder:
+			d.value()
+		case TokenKeyword:
+			if t.Is("null") && !(d.prev.Is("is") || d.prev.Is("not")) {
+				d.value()
+			} else {
+				d.push(strings.ToUpper(t.Text))
+			}
+		case TokenIdent:
+			next := l
+			if w := strings.ToLower(t.Text); digestKeywords[w] || digestFunctions[w] && next.Next().Text == "(" {
+				d.push(strings.ToUpper(t.Text))
+			} else {
+				d.push("`" + t.Text + "`")
+			}
+		case TokenQuotedIdent:
+			d.push(t.Text)
+		case TokenVariable:
+			d.push(digestVariable(t.Text))
+		case TokenOperator:
+			switch t.Text {
+			case ")":
+				d.closeParen()
+			case "<>":
+				d.push("!=")
+			default:
+				d.push(t.Text)
+			}
+		default:
+			d.push(t.Text)
+		}
+		d.prev = t
+	}
+}
+
+func (d *digester) push(s string) {
+	d.out = append(d.out, s)
+}
+
+// last returns the nth last output token, or "" if there is none.
+func (d *digester) last(n int) string {
+	if len(d.out) < n {
+		return ""
+	}
+	return d.out[len(d.out)-n]
+}
+
+func (d *digester) pop(n int) {
+	d.out = d.out[:len(d.out)-n]
+}
+
+// value adds a value, reducing - ? to ? and ? , ? to ?, ... like MySQL.
+func (d *digester) value() {
+	if l := d.last(1); (l == "-" || l == "+") && !digestOperand(d.last(2)) {
+		d.pop(1)
+	}
+	if d.last(1) == "," {
+		if l := d.last(2); l == digestValue || l == digestValueList {
+			d.pop(2)
+			d.push(digestValueList)
+			return
+		}
+	}
+	d.push(digestValue)
+}
+
+// closeParen adds ")", reducing rows and IN lists like MySQL.
+func (d *digester) closeParen() {
+	if d.last(2) != "(" {
+		d.push(")")
+		return
+	}
+	row, list := digestRowSingle, digestRowSingleList
+	switch d.last(1) {
+	case digestValue:
+	case digestValueList:
+		row, list = digestRowMulti, digestRowMultiList
+	default:
+		d.push(")")
+		return
+	}
+	d.pop(2)
+
+	// (?) , (?) -> (?) /* , ... */
+	if d.last(1) == "," && (d.last(2) == row || d.last(2) == list) {
+		d.pop(2)
+		d.push(list)
+		return
+	}
+	if d.last(1) == "IN" {
+		d.pop(1)
+		d.push(digestIn)
+		return
+	}
+	d.push(row)
+}
+
+// digestOperand returns true if the output token can precede a binary + or -.
+func digestOperand(s string) bool {
+	switch s {
+	case "", "(", ",", "=", "<", ">", "<=", ">=", "!=", "<=>", "+", "-", "*", "/", "%", ":=":
+		return false
+	}
+	if s == ")" || s == digestValue || s == digestValueList || strings.HasPrefix(s, "`") || strings.HasPrefix(s, "@") {
+		return true
+	}
+	// Keywords, like SELECT -1 and LIMIT -1, are not operands, except values.
+	return s == "NULL" || s == "TRUE" || s == "FALSE"
+}
+
+// digestVariable returns @@`var` for system variables and @`var` for user
+// variables.
+func digestVariable(v string) string {
+	prefix := "@"
+	if strings.HasPrefix(v, "@@") {
+		prefix = "@@"
+	}
+	name := strings.TrimPrefix(v, prefix)
+	if name == "" || name[0] == '`' || name[0] == '\'' || name[0] == '"' {
+		return v
+	}
+	if scope, n, ok := strings.Cut(name, "."); ok && prefix == "@@" {
+		return prefix + strings.ToUpper(scope) + " . `" + n + "`"
+	}
+	return prefix + "`" + name + "`"
+}
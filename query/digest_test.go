@@ -0,0 +1,104 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/go-mysql/query"
+)
+
+// The corpus contains queries and the DIGEST_TEXT expected by the rules of
+// sql/sql_digest.cc in MySQL 8.0. It is hand-written, not captured from a
+// server.
+func TestDigestTextCorpus(t *testing.T) {
+	bytes, err := os.ReadFile("testdata/digest_text.json")
+	require.NoError(t, err)
+	var corpus []struct {
+		Query      string `json:"query"`
+		DigestText string `json:"digest_text"`
+	}
+	require.NoError(t, json.Unmarshal(bytes, &corpus))
+	require.NotEmpty(t, corpus)
+	for _, c := range corpus {
+		assert.Equal(t, c.DigestText, query.DigestText(c.Query), c.Query)
+	}
+}
+
+func TestDigestText(t *testing.T) {
+	type testCase struct {
+		name     string
+		query    string
+		expected string
+	}
+	testCases := []testCase{
+		{
+			name:     "values",
+			query:    "SELECT 1, 'a', 0xFF, NULL, -2, 3 - 4",
+			expected: "SELECT ?, ... - ?",
+		},
+		{
+			name:     "version comment and hint",
+			query:    "SELECT /*+ BKA(t) */ /*!40001 SQL_NO_CACHE */ a FROM t",
+			expected: "SELECT SQL_NO_CACHE `a` FROM `t`",
+		},
+		{
+			name:     "subquery in IN",
+			query:    "SELECT a FROM t WHERE b IN (SELECT c FROM u WHERE d = 1)",
+			expected: "SELECT `a` FROM `t` WHERE `b` IN ( SELECT `c` FROM `u` WHERE `d` = ? )",
+		},
+		{
+			name:     "functions",
+			query:    "SELECT NOW(), GROUP_CONCAT(a), sleep(1), count, max (b) FROM t WHERE DATE(d) = 1",
+			expected: "SELECT NOW ( ) , GROUP_CONCAT ( `a` ) , `sleep` (?) , `count` , `max` ( `b` ) FROM `t` WHERE DATE ( `d` ) = ?",
+		},
+		{
+			name:     "not equal",
+			query:    "SELECT a FROM t WHERE b <> 1",
+			expected: "SELECT `a` FROM `t` WHERE `b` != ?",
+		},
+		{
+			name:     "variables",
+			query:    "SET @a = @@global.max_connections",
+			expected: "SET @`a` = @@GLOBAL . `max_connections`",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, query.DigestText(tc.query))
+		})
+	}
+}
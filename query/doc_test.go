@@ -42,3 +42,13 @@ func ExampleFingerprint() {
 	f := query.Fingerprint(q)
 	fmt.Println(f)
 }
+
+func ExampleFingerprinter() {
+	fp := query.Fingerprinter{
+		ReplaceNumbersInWords: true,
+		Lists:                 query.ListArity,
+	}
+	f := fp.Fingerprint("SELECT c FROM db1.t WHERE id IN (1, 2, 3)")
+	fmt.Println(f)
+	// Output: select c from db?.t where id in(?, ?, ?)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/percona/go-mysql/query"
+)
+
+// FuzzFingerprint checks the invariants documented by Fingerprint. The seed
+// corpus is the queries of the sample slow logs and some commands, and inputs
+// found by the fuzzer are in testdata/fuzz/FuzzFingerprint.
+func FuzzFingerprint(f *testing.F) {
+	for _, q := range slowLogQueries(f) {
+		f.Add(q)
+	}
+	f.Add("PREPARE s FROM 'SELECT * FROM t WHERE a = ? AND b = ''x'''")
+	f.Add("EXECUTE s USING @a, @b")
+	f.Add("SHOW SESSION VARIABLES LIKE 'a%'")
+	f.Add("# administrator command: Binlog Dump;")
+	f.Fuzz(func(t *testing.T, q string) {
+		done := make(chan string, 1)
+		go func() { done <- query.Fingerprint(q) }()
+		var fp string
+		select {
+		case fp = <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Fingerprint(%q) does not return", q)
+		}
+		if len(fp) > 2*len(q) {
+			t.Errorf("Fingerprint(%q) = %q is more than twice as long", q, fp)
+		}
+		if utf8.ValidString(q) && !utf8.ValidString(fp) {
+			t.Errorf("Fingerprint(%q) = %q is not valid UTF-8", q, fp)
+		}
+		if fp2 := query.Fingerprint(fp); fp2 != fp {
+			t.Errorf("Fingerprint(%q) = %q, but Fingerprint(%q) = %q", q, fp, fp, fp2)
+		}
+	})
+}
+
+func FuzzId(f *testing.F) {
+	for _, q := range slowLogQueries(f) {
+		f.Add(query.Fingerprint(q))
+	}
+	f.Fuzz(func(t *testing.T, fp string) {
+		id := query.Id(fp)
+		if len(id) != 16 || strings.Trim(id, "0123456789ABCDEF") != "" {
+			t.Errorf("Id(%q) = %q, expected 16 uppercase hex digits", fp, id)
+		}
+	})
+}
@@ -0,0 +1,171 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A FingerprintVersion identifies the rules that make fingerprints. The
+// fingerprints of a version do not change: changes to Fingerprint that change
+// fingerprints are made in a new version, except fixes for queries that could
+// not be fingerprinted or whose fingerprints broke the invariants documented
+// by Fingerprint. TestIDSpecGolden has golden fingerprints and IDs of every
+// version.
+type FingerprintVersion int
+
+const (
+	// FingerprintV1 is the first versioned fingerprint, made by the lexer-based
+	// Fingerprint. It is compatible with the fingerprints of the previous
+	// fingerprinter for all queries in its tests.
+	FingerprintV1 FingerprintVersion = 1
+
+	// FingerprintV2 is FingerprintV1 with fingerprints of commands: the SQL of
+	// PREPARE and EXECUTE IMMEDIATE is fingerprinted, EXECUTE arguments and
+	// SHOW filters are removed, DROP PREPARE is DEALLOCATE PREPARE, and
+	// administrator commands are made consistent, see FingerprintEvent.
+	FingerprintV2 FingerprintVersion = 2
+
+	// LatestFingerprintVersion is the version of Fingerprint and the zero
+	// Fingerprinter. It was FingerprintV1 before FingerprintV2, so the
+	// fingerprints and IDs of commands changed for callers that do not set
+	// a version.
+	LatestFingerprintVersion = FingerprintV2
+)
+
+// A HashAlgorithm makes query IDs from fingerprints.
+type HashAlgorithm byte
+
+const (
+	// HashMD5 makes the right-most 16 hex digits of the MD5 checksum of the
+	// fingerprint, in uppercase, like Id. It is compatible with pt-query-digest
+	// and previous versions of this package. MD5 is not used for security:
+	// query IDs are identifiers, not secrets.
+	HashMD5 HashAlgorithm = iota
+
+	// HashXXH64 makes the 16 hex digits of the XXH64 hash (seed 0) of the
+	// fingerprint, in uppercase. It is the fastest.
+	HashXXH64
+
+	// HashSHA256 makes the 64 hex digits of the SHA-256 checksum of the
+	// fingerprint, in lowercase.
+	HashSHA256
+)
+
+var hashName = map[HashAlgorithm]string{
+	HashMD5:    "md5",
+	HashXXH64:  "xxh64",
+	HashSHA256: "sha256",
+}
+
+func (h HashAlgorithm) String() string {
+	if name, ok := hashName[h]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ID returns the query ID of the fingerprint.
+func (h HashAlgorithm) ID(fingerprint string) string {
+	switch h {
+	case HashXXH64:
+		return fmt.Sprintf("%016X", xxhash64([]byte(fingerprint)))
+	case HashSHA256:
+		sum := sha256.Sum256([]byte(fingerprint))
+		return hex.EncodeToString(sum[:])
+	}
+	sum := md5.Sum([]byte(fingerprint))
+	return strings.ToUpper(hex.EncodeToString(sum[8:]))
+}
+
+// An IDSpec is a fingerprint version and hash algorithm. The fingerprints and
+// IDs of a valid IDSpec do not change when the package is updated, so they
+// can be stored and compared across releases. Make IDs of a query like:
+//
+//	spec := query.IDSpec{Version: query.FingerprintV1, Hash: query.HashXXH64}
+//	id := spec.ID(spec.Fingerprint(q))
+type IDSpec struct {
+	Version FingerprintVersion
+	Hash    HashAlgorithm
+}
+
+// ParseIDSpec parses an IDSpec string like "v1/xxh64", see IDSpec.String.
+func ParseIDSpec(s string) (IDSpec, error) {
+	v, h, ok := strings.Cut(s, "/")
+	if !ok || !strings.HasPrefix(v, "v") {
+		return IDSpec{}, fmt.Errorf("invalid ID spec %q: expected version/hash like v1/md5", s)
+	}
+	n, err := strconv.Atoi(v[1:])
+	if err != nil {
+		return IDSpec{}, fmt.Errorf("invalid ID spec %q: invalid version: %s", s, err)
+	}
+	spec := IDSpec{Version: FingerprintVersion(n), Hash: 255}
+	for hash, name := range hashName {
+		if h == name {
+			spec.Hash = hash
+		}
+	}
+	if err := spec.Validate(); err != nil {
+		return IDSpec{}, err
+	}
+	return spec, nil
+}
+
+// Validate returns an error if the version or hash algorithm is unknown.
+func (s IDSpec) Validate() error {
+	if s.Version < FingerprintV1 || s.Version > LatestFingerprintVersion {
+		return fmt.Errorf("invalid ID spec %s: unknown fingerprint version %d", s, s.Version)
+	}
+	if _, ok := hashName[s.Hash]; !ok {
+		return fmt.Errorf("invalid ID spec %s: unknown hash algorithm %d", s, s.Hash)
+	}
+	return nil
+}
+
+// String returns the version and hash algorithm like "v1/xxh64".
+func (s IDSpec) String() string {
+	return fmt.Sprintf("v%d/%s", s.Version, s.Hash)
+}
+
+// Fingerprint returns the fingerprint of q made with the rules of the version.
+func (s IDSpec) Fingerprint(q string) string {
+	return Fingerprinter{Version: s.Version}.Fingerprint(q)
+}
+
+// ID returns the query ID of the fingerprint.
+func (s IDSpec) ID(fingerprint string) string {
+	return s.Hash.ID(fingerprint)
+}
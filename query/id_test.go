@@ -0,0 +1,231 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/go-mysql/query"
+)
+
+func TestHashXXH64(t *testing.T) {
+	// Test vectors of the reference implementation.
+	vectors := map[string]string{
+		"":     "EF46DB3751D8E999",
+		"a":    "D24EC4F1A98C6E5B",
+		"as":   "1C330FB2D66BE179",
+		"asd":  "631C37CE72A97393",
+		"asdf": "415872F599CEA71E",
+		"abc":  "44BC2CF5AD770999",
+		"Call me Ishmael. Some years ago--never mind how long precisely-": "02A2E85470D6FD96",
+	}
+	for s, expected := range vectors {
+		assert.Equal(t, expected, query.HashXXH64.ID(s), s)
+	}
+}
+
+// Golden fingerprints and IDs must never change: they are the compatibility
+// guarantee of each fingerprint version and hash algorithm. Every version and
+// hash algorithm must have golden IDs, so add them for a new version.
+func TestIDSpecGolden(t *testing.T) {
+	type testCase struct {
+		query        string
+		fingerprints map[query.FingerprintVersion]string
+		ids          map[string]string // by IDSpec string
+	}
+	testCases := []testCase{
+		{
+			query: "SELECT * FROM t WHERE a = 'x'",
+			fingerprints: map[query.FingerprintVersion]string{
+				query.FingerprintV1: "select * from t where a = ?",
+				query.FingerprintV2: "select * from t where a = ?",
+			},
+			ids: map[string]string{
+				"v1/md5":    "84AFF9783CD458FF",
+				"v1/xxh64":  "242458339350A212",
+				"v1/sha256": "233ddc91cd773861f6518467ce0a6c5b56843df45543b76e98054c0cbbd63e6d",
+				"v2/md5":    "84AFF9783CD458FF",
+				"v2/xxh64":  "242458339350A212",
+				"v2/sha256": "233ddc91cd773861f6518467ce0a6c5b56843df45543b76e98054c0cbbd63e6d",
+			},
+		},
+		{
+			query: "SELECT c FROM t WHERE id IN (1, 2, 3) ORDER BY c ASC LIMIT 10",
+			fingerprints: map[query.FingerprintVersion]string{
+				query.FingerprintV1: "select c from t where id in(?+) order by c limit ?",
+				query.FingerprintV2: "select c from t where id in(?+) order by c limit ?",
+			},
+			ids: map[string]string{
+				"v1/md5":    "0A7B202825027EB0",
+				"v1/xxh64":  "4507A2438F9C8995",
+				"v1/sha256": "739564c8ec592f4abcb84d8b630182b4717ba1cbacc52d2cbff6d5e7cf76c342",
+				"v2/md5":    "0A7B202825027EB0",
+				"v2/xxh64":  "4507A2438F9C8995",
+				"v2/sha256": "739564c8ec592f4abcb84d8b630182b4717ba1cbacc52d2cbff6d5e7cf76c342",
+			},
+		},
+		{
+			query: "INSERT INTO t (a, b) VALUES (1, 'x'), (2, NULL) ON DUPLICATE KEY UPDATE b = VALUES(b)",
+			fingerprints: map[query.FingerprintVersion]string{
+				query.FingerprintV1: "insert into t (a, b) values(?+) on duplicate key update b = values(b)",
+				query.FingerprintV2: "insert into t (a, b) values(?+) on duplicate key update b = values(b)",
+			},
+			ids: map[string]string{
+				"v1/md5":    "44B714F72408C65E",
+				"v1/xxh64":  "C5F872F5B9A7D898",
+				"v1/sha256": "15f4c9db5ee0d0a3a363ee6e2eedb9959a9a089ad0668116a37d9b7f74516392",
+				"v2/md5":    "44B714F72408C65E",
+				"v2/xxh64":  "C5F872F5B9A7D898",
+				"v2/sha256": "15f4c9db5ee0d0a3a363ee6e2eedb9959a9a089ad0668116a37d9b7f74516392",
+			},
+		},
+		{
+			// Comments, signs, and 0X, which fuzzing fixed.
+			query: "SELECT a -- comment\n, b # other\nFROM t WHERE x = --1 AND y = 0X1F",
+			fingerprints: map[query.FingerprintVersion]string{
+				query.FingerprintV1: "select a , b from t where x = ? and y = 0X1f",
+				query.FingerprintV2: "select a , b from t where x = ? and y = 0X1f",
+			},
+			ids: map[string]string{
+				"v1/md5":    "F80BED31538FD012",
+				"v1/xxh64":  "5E082A9237147E2A",
+				"v1/sha256": "dee4dd3ce05ab8972fe9ea8e7eb55b3039cf649cef79953d4fa29b7e3fcacd46",
+				"v2/md5":    "F80BED31538FD012",
+				"v2/xxh64":  "5E082A9237147E2A",
+				"v2/sha256": "dee4dd3ce05ab8972fe9ea8e7eb55b3039cf649cef79953d4fa29b7e3fcacd46",
+			},
+		},
+		{
+			// V2 fingerprints commands.
+			query: "SHOW SESSION STATUS LIKE 'Threads%'",
+			fingerprints: map[query.FingerprintVersion]string{
+				query.FingerprintV1: "show session status like ?",
+				query.FingerprintV2: "show status",
+			},
+			ids: map[string]string{
+				"v1/md5":    "0B14E77CA2C09D3E",
+				"v1/xxh64":  "18C2C83F2AB1211E",
+				"v1/sha256": "978a391ba6d041ba1d551fd36bb349335308c7c2c2a835c38f292da0eedf0c48",
+				"v2/md5":    "31DA25F95494CA95",
+				"v2/xxh64":  "30342266357E4781",
+				"v2/sha256": "fdc16f8747aedafd4e7d147b07139e88ae997386aa8e7012f249ce291e698a4e",
+			},
+		},
+		{
+			query: "PREPARE stmt FROM 'SELECT * FROM t WHERE id = ?'",
+			fingerprints: map[query.FingerprintVersion]string{
+				query.FingerprintV1: "prepare stmt from ?",
+				query.FingerprintV2: "prepare stmt from select * from t where id = ?",
+			},
+			ids: map[string]string{
+				"v1/md5":    "EFAAA0C991F086B3",
+				"v1/xxh64":  "A608B9B7EA9F6A3C",
+				"v1/sha256": "f9abcce82d1df641af15d5c57850e0e2d48266e7f960be95d622d32a87b00696",
+				"v2/md5":    "6C8FD113FFB20FDA",
+				"v2/xxh64":  "FA4335F8459E953C",
+				"v2/sha256": "615b830b93b00926e229efe7601487a320dfb89f1b4ff35dcdd190b61c0e572e",
+			},
+		},
+		{
+			query: "administrator command: binlog  dump;",
+			fingerprints: map[query.FingerprintVersion]string{
+				query.FingerprintV1: "administrator command: binlog  dump;",
+				query.FingerprintV2: "administrator command: Binlog Dump",
+			},
+			ids: map[string]string{
+				"v1/md5":    "44A92CA25A859DD0",
+				"v1/xxh64":  "B1BD2DFE6F40E5D3",
+				"v1/sha256": "926f6b73490d5f6a55ef45a8c1ee0dd65babe172817d433d0cf4561f847f9f16",
+				"v2/md5":    "CBC895EB5A636382",
+				"v2/xxh64":  "B4B8A4EFDF0C9BE6",
+				"v2/sha256": "9c77d5ec738ae1edee0fd9ef36c4c681ec3004bd3d3465619ebd70671fe3aa53",
+			},
+		},
+		{
+			query: "",
+			fingerprints: map[query.FingerprintVersion]string{
+				query.FingerprintV1: "",
+				query.FingerprintV2: "",
+			},
+			ids: map[string]string{
+				"v1/md5":    "E9800998ECF8427E",
+				"v1/xxh64":  "EF46DB3751D8E999",
+				"v1/sha256": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+				"v2/md5":    "E9800998ECF8427E",
+				"v2/xxh64":  "EF46DB3751D8E999",
+				"v2/sha256": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			},
+		},
+	}
+	hashes := []query.HashAlgorithm{query.HashMD5, query.HashXXH64, query.HashSHA256}
+	for _, tc := range testCases {
+		for v := query.FingerprintV1; v <= query.LatestFingerprintVersion; v++ {
+			expectedFingerprint, ok := tc.fingerprints[v]
+			require.True(t, ok, "no golden v%d fingerprint of %q", v, tc.query)
+			for _, hash := range hashes {
+				spec := query.IDSpec{Version: v, Hash: hash}
+				expected, ok := tc.ids[spec.String()]
+				require.True(t, ok, "no golden %s ID of %q", spec, tc.query)
+				f := spec.Fingerprint(tc.query)
+				assert.Equal(t, expectedFingerprint, f, spec.String())
+				assert.Equal(t, expected, spec.ID(f), spec.String())
+			}
+		}
+		// Id is HashMD5.
+		assert.Equal(t, tc.ids["v1/md5"], query.Id(tc.fingerprints[query.FingerprintV1]))
+	}
+}
+
+func TestParseIDSpec(t *testing.T) {
+	spec, err := query.ParseIDSpec("v1/xxh64")
+	require.NoError(t, err)
+	assert.Equal(t, query.IDSpec{Version: query.FingerprintV1, Hash: query.HashXXH64}, spec)
+	assert.Equal(t, "v1/xxh64", spec.String())
+
+	spec, err = query.ParseIDSpec("v1/md5")
+	require.NoError(t, err)
+	assert.Equal(t, query.IDSpec{Version: query.FingerprintV1, Hash: query.HashMD5}, spec)
+
+	spec, err = query.ParseIDSpec("v2/sha256")
+	require.NoError(t, err)
+	assert.Equal(t, query.IDSpec{Version: query.FingerprintV2, Hash: query.HashSHA256}, spec)
+
+	for _, s := range []string{"", "v1", "1/md5", "v0/md5", "v999/md5", "v1/crc32", "vX/md5"} {
+		_, err := query.ParseIDSpec(s)
+		assert.Error(t, err, s)
+	}
+
+	assert.Error(t, query.IDSpec{}.Validate())
+	assert.NoError(t, query.IDSpec{Version: query.LatestFingerprintVersion}.Validate())
+}
@@ -0,0 +1,131 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query
+
+import (
+	"regexp"
+	"strings"
+)
+
+// An IdentScope is the kind of identifiers an IdentRule applies to.
+type IdentScope byte
+
+const (
+	IdentAll      IdentScope = iota // all identifiers, including columns and aliases
+	IdentDatabase                   // database names, like db in db.t and db.t.col
+	IdentTable                      // table names, like t in db.t and t.col
+)
+
+// An IdentRule replaces identifiers in its scope that match Pattern with
+// Replacement, like Pattern.ReplaceAllString, so $1 is the first submatch.
+// Quoted identifiers are matched without backticks. For example, this rule makes
+// tables partitioned by name, like events_2024_01, one table in fingerprints:
+//
+//	query.IdentRule{
+//		Scope:       query.IdentTable,
+//		Pattern:     regexp.MustCompile(`^events_\d{4}_\d{2}$`),
+//		Replacement: "events_?",
+//	}
+//
+// Names are in the scope of IdentTable and IdentDatabase where they are tables
+// and databases, like FROM db.t, and where they qualify columns, like db.t.col
+// and t.col. A table alias that qualifies columns is in the IdentTable scope,
+// too.
+type IdentRule struct {
+	Scope       IdentScope
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// identScopes returns the scope of database and table names in q by token
+// offset. Other identifiers are not in the map.
+func identScopes(q string) map[int]IdentScope {
+	idents := map[int]IdentScope{}
+	for _, span := range split(q) {
+		d := newDescriber(q[span[0]:span[1]])
+		d.idents = map[int]IdentScope{}
+		d.statement(0)
+		for start, scope := range d.idents {
+			idents[span[0]+start] = scope
+		}
+	}
+
+	// Qualified columns: db.t.col and t.col.
+	var tokens []Token
+	l := Lexer{q: q}
+	for t := l.NextSignificant(); t.Type != TokenEOF; t = l.NextSignificant() {
+		tokens = append(tokens, t)
+	}
+	for i := 0; i < len(tokens); i++ {
+		if !isName(tokens[i]) || (i > 0 && tokens[i-1].Text == ".") {
+			continue
+		}
+		n := 1
+		for j := i + 1; j+1 < len(tokens) && tokens[j].Text == "." && (isName(tokens[j+1]) || tokens[j+1].Text == "*"); j += 2 {
+			n++
+		}
+		if _, ok := idents[tokens[i].Start]; ok {
+			continue // table name, like db.t in FROM db.t
+		}
+		switch n {
+		case 2:
+			idents[tokens[i].Start] = IdentTable
+		case 3:
+			idents[tokens[i].Start] = IdentDatabase
+			idents[tokens[i+2].Start] = IdentTable
+		}
+	}
+	return idents
+}
+
+// replaceIdent returns the identifier t with the first matching rule of the
+// fingerprinter applied, and true, or false if no rule matches.
+func (f *fingerprinter) replaceIdent(t Token) (string, bool) {
+	scope, ok := f.idents[t.Start]
+	if !ok {
+		scope = IdentAll
+	}
+	name := t.Text
+	if t.Type == TokenQuotedIdent {
+		name = unquoteIdent(name)
+	}
+	for _, r := range f.fp.IdentRules {
+		if (r.Scope != IdentAll && r.Scope != scope) || r.Pattern == nil || !r.Pattern.MatchString(name) {
+			continue
+		}
+		name = r.Pattern.ReplaceAllString(name, r.Replacement)
+		if t.Type == TokenQuotedIdent {
+			name = "`" + strings.ReplaceAll(name, "`", "``") + "`"
+		}
+		return name, true
+	}
+	return "", false
+}
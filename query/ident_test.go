@@ -0,0 +1,91 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/percona/go-mysql/query"
+)
+
+func TestIdentRules(t *testing.T) {
+	fp := query.Fingerprinter{IdentRules: []query.IdentRule{
+		{Scope: query.IdentDatabase, Pattern: regexp.MustCompile(`^shard_\d+$`), Replacement: "shard_?"},
+		{Scope: query.IdentTable, Pattern: regexp.MustCompile(`^events_(\d{4})_\d{2}$`), Replacement: "events_${1}_?"},
+		{Pattern: regexp.MustCompile(`^tmp\d+$`), Replacement: "tmp?"},
+	}}
+	testCases := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{
+			name:     "tables and databases",
+			query:    "SELECT * FROM shard_7.events_2024_01 JOIN `events_2024_02` e ON e.a = 1",
+			expected: "select * from shard_?.events_2024_? join `events_2024_?` e on e.a = ?",
+		},
+		{
+			name:     "qualified columns",
+			query:    "SELECT events_2024_01.id, shard_7.events_2024_01.x FROM events_2024_01",
+			expected: "select events_2024_?.id, shard_?.events_2024_?.x from events_2024_?",
+		},
+		{
+			name:     "out of scope",
+			query:    "SELECT shard_1, events_2024_01 FROM shard_1 WHERE col1 = 1",
+			expected: "select shard_1, events_2024_01 from shard_1 where col1 = ?",
+		},
+		{
+			name:     "all identifiers",
+			query:    "SELECT tmp1.a, tmp2 FROM tmp3",
+			expected: "select tmp?.a, tmp? from tmp?",
+		},
+		{
+			name:     "insert and update",
+			query:    "INSERT INTO shard_3.events_2024_05 (a) VALUES (1); UPDATE shard_3.t SET a = 1",
+			expected: "insert into shard_?.events_2024_? (a) values(?+); update shard_?.t set a = ?",
+		},
+		{
+			name:     "subquery",
+			query:    "SELECT * FROM t WHERE a IN (SELECT b FROM shard_9.u)",
+			expected: "select * from t where a in(select b from shard_?.u)",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := fp.Fingerprint(tc.query)
+			assert.Equal(t, tc.expected, f)
+			assert.Equal(t, f, fp.Fingerprint(f))
+		})
+	}
+}
@@ -0,0 +1,474 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query
+
+import (
+	"strings"
+)
+
+// A TokenType is the type of a Token.
+type TokenType byte
+
+const (
+	TokenEOF            TokenType = iota // end of query
+	TokenSpace                           // spaces, tabs, newlines, and \x00
+	TokenKeyword                         // reserved word like SELECT or FROM
+	TokenIdent                           // identifier like t1 or COUNT
+	TokenQuotedIdent                     // `quoted identifier`
+	TokenString                          // 'string', "string", N'string', or _utf8mb4'string'
+	TokenNumber                          // 1, 1.5, .5, or 1e-9; a sign is a separate operator token
+	TokenHex                             // 0xFF or x'FF'
+	TokenBit                             // 0b01 or b'01'
+	TokenOperator                        // = <=> + , ( ) ; . etc.
+	TokenPlaceholder                     // ? in prepared statements and fingerprints
+	TokenVariable                        // @var, @'var', or @@var
+	TokenComment                         // -- comment, # comment, or /* comment */
+	TokenHint                            // /*+ optimizer hint */
+	TokenVersionComment                  // /*!50001 MySQL-specific code */
+	TokenOther                           // any other byte, like \x01
+)
+
+var tokenTypeName = map[TokenType]string{
+	TokenEOF:            "EOF",
+	TokenSpace:          "Space",
+	TokenKeyword:        "Keyword",
+	TokenIdent:          "Ident",
+	TokenQuotedIdent:    "QuotedIdent",
+	TokenString:         "String",
+	TokenNumber:         "Number",
+	TokenHex:            "Hex",
+	TokenBit:            "Bit",
+	TokenOperator:       "Operator",
+	TokenPlaceholder:    "Placeholder",
+	TokenVariable:       "Variable",
+	TokenComment:        "Comment",
+	TokenHint:           "Hint",
+	TokenVersionComment: "VersionComment",
+	TokenOther:          "Other",
+}
+
+func (t TokenType) String() string {
+	if name, ok := tokenTypeName[t]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// A Token is a typed slice of a query.
+type Token struct {
+	Type  TokenType
+	Text  string // q[Start:End]
+	Start int    // byte offset of first byte in query
+	End   int    // byte offset after last byte in query
+}
+
+// Is returns true if the token is a keyword or identifier equal to word,
+// ignoring case.
+func (t Token) Is(word string) bool {
+	return (t.Type == TokenKeyword || t.Type == TokenIdent) && strings.EqualFold(t.Text, word)
+}
+
+// Significant returns true unless the token is space or a comment. Hints and
+// version comments are significant because they can change the query.
+func (t Token) Significant() bool {
+	return t.Type != TokenSpace && t.Type != TokenComment
+}
+
+// A Lexer splits a query into tokens. Like Fingerprint, it does not parse SQL,
+// so it never fails: every byte of the query is in exactly one token, and
+// concatenating the Text of all tokens yields the query. Unterminated quotes
+// and comments extend to the end of the query.
+//
+// Words are keywords if they are MySQL 8.0 reserved words or begin common
+// statements (BEGIN, COMMIT, etc.); all other words are identifiers, including
+// function names like COUNT. Double-quoted text is a string, as in MySQL's
+// default SQL mode.
+type Lexer struct {
+	q    string
+	pos  int
+	prev TokenType // last significant token type
+	last byte      // last byte of last significant token
+}
+
+// NewLexer returns a new Lexer for the query.
+func NewLexer(q string) *Lexer {
+	return &Lexer{q: q}
+}
+
+// Tokenize returns all tokens of the query, excluding the final TokenEOF.
+func Tokenize(q string) []Token {
+	var tokens []Token
+	l := Lexer{q: q}
+	for {
+		t := l.Next()
+		if t.Type == TokenEOF {
+			return tokens
+		}
+		tokens = append(tokens, t)
+	}
+}
+
+// lexed, if not nil, counts the tokens scanned by all lexers. Tests set it to
+// check that the number of tokens scanned is linear in the query length.
+var lexed *int
+
+// Next returns the next token, or a TokenEOF token at the end of the query.
+func (l *Lexer) Next() Token {
+	start := l.pos
+	typ := l.scan()
+	if lexed != nil {
+		*lexed++
+	}
+	t := Token{
+		Type:  typ,
+		Text:  l.q[start:l.pos],
+		Start: start,
+		End:   l.pos,
+	}
+	if t.Significant() && typ != TokenEOF {
+		l.prev = typ
+		l.last = l.q[l.pos-1]
+	}
+	return t
+}
+
+// NextSignificant returns the next token that is not space or a comment.
+func (l *Lexer) NextSignificant() Token {
+	for {
+		if t := l.Next(); t.Significant() {
+			return t
+		}
+	}
+}
+
+// scan advances pos past the next token and returns its type.
+func (l *Lexer) scan() TokenType {
+	q := l.q
+	n := len(q)
+	if l.pos >= n {
+		return TokenEOF
+	}
+	c := q[l.pos]
+
+	switch {
+	case isSpaceByte(c) || c == 0:
+		// \x00 is padding in some logs, not part of the query.
+		for l.pos < n && (isSpaceByte(q[l.pos]) || q[l.pos] == 0) {
+			l.pos++
+		}
+		return TokenSpace
+	case c == '#':
+		l.skipLine()
+		return TokenComment
+	case c == '-' && l.pos+1 < n && q[l.pos+1] == '-' && (l.pos+2 == n || q[l.pos+2] <= ' '):
+		// A space or control char after -- is required.
+		l.skipLine()
+		return TokenComment
+	case c == '/' && l.pos+1 < n && q[l.pos+1] == '*':
+		typ := TokenComment
+		if l.pos+2 < n {
+			switch q[l.pos+2] {
+			case '!':
+				typ = TokenVersionComment
+			case '+':
+				typ = TokenHint
+			case 'M':
+				if l.pos+3 < n && q[l.pos+3] == '!' { // MariaDB /*M!100100 ... */
+					typ = TokenVersionComment
+				}
+			}
+		}
+		if end := strings.Index(q[l.pos+2:], "*/"); end >= 0 {
+			l.pos += 2 + end + 2
+		} else {
+			l.pos = n
+		}
+		return typ
+	case c == '\'' || c == '"':
+		l.skipQuoted(c)
+		return TokenString
+	case c == '`':
+		l.skipQuoted(c)
+		return TokenQuotedIdent
+	case c == '(' || c == ')' || c == ',' || c == ';':
+		// Not the first byte of any multi-byte operator.
+		l.pos++
+		return TokenOperator
+	case c == '?':
+		l.pos++
+		return TokenPlaceholder
+	case c == '@':
+		l.pos++
+		if l.pos < n && q[l.pos] == '@' {
+			l.pos++
+			l.skipIdent()
+			// @@global.var, @@session.var
+			if l.pos+1 < n && q[l.pos] == '.' && isIdentByte(q[l.pos+1]) {
+				l.pos++
+				l.skipIdent()
+			}
+		} else if l.pos < n && (q[l.pos] == '\'' || q[l.pos] == '"' || q[l.pos] == '`') {
+			l.skipQuoted(q[l.pos])
+		} else {
+			l.skipIdent()
+		}
+		return TokenVariable
+	case c >= '0' && c <= '9' && l.pos > 0 && q[l.pos-1] == '.' && l.prev == TokenOperator && l.last == '.':
+		// Qualified names like t.1 are identifiers.
+		l.skipIdent()
+		return TokenIdent
+	case c >= '0' && c <= '9':
+		return l.scanNumber()
+	case c == '.' && l.pos+1 < n && q[l.pos+1] >= '0' && q[l.pos+1] <= '9' && !l.afterOperand():
+		return l.scanNumber()
+	case isIdentByte(c):
+		return l.scanWord()
+	}
+
+	for _, op := range operators {
+		if op[0] == c && strings.HasPrefix(q[l.pos:], op) {
+			l.pos += len(op)
+			return TokenOperator
+		}
+	}
+	l.pos++
+	if strings.IndexByte("=<>!+-*/%&|^~(),;.:{}[]\\", c) >= 0 {
+		return TokenOperator
+	}
+	return TokenOther
+}
+
+// operators are multi-byte operators, longest first.
+var operators = []string{"<=>", "->>", "<=", ">=", "<>", "!=", ":=", "||", "&&", "<<", ">>", "->"}
+
+// afterOperand returns true if the previous significant token can be followed
+// by a qualifier, like t in t.5 or a version comment.
+func (l *Lexer) afterOperand() bool {
+	switch l.prev {
+	case TokenIdent, TokenQuotedIdent, TokenKeyword:
+		return true
+	case TokenOperator:
+		return l.last == ')'
+	}
+	return false
+}
+
+// scanNumber scans a number, hex or bit value, or an identifier that begins
+// with digits like 123abc.
+func (l *Lexer) scanNumber() TokenType {
+	q := l.q
+	n := len(q)
+	start := l.pos
+
+	// 0xFF and 0b01; 0xZZ is an identifier.
+	if q[start] == '0' && start+2 < n && (q[start+1] == 'x' || q[start+1] == 'b') {
+		j := start + 2
+		for j < n && ((q[start+1] == 'x' && isHexByte(q[j])) || (q[start+1] == 'b' && (q[j] == '0' || q[j] == '1'))) {
+			j++
+		}
+		if j > start+2 && (j == n || !isIdentByte(q[j])) {
+			l.pos = j
+			if q[start+1] == 'x' {
+				return TokenHex
+			}
+			return TokenBit
+		}
+	}
+
+	j := start
+	for j < n && isDigitByte(q[j]) {
+		j++
+	}
+	fraction := false
+	if j < n && q[j] == '.' {
+		fraction = true
+		j++
+		for j < n && isDigitByte(q[j]) {
+			j++
+		}
+	}
+	if j < n && (q[j] == 'e' || q[j] == 'E') {
+		k := j + 1
+		if k < n && (q[k] == '+' || q[k] == '-') {
+			k++
+		}
+		if k < n && isDigitByte(q[k]) {
+			j = k
+			for j < n && isDigitByte(q[j]) {
+				j++
+			}
+		}
+	}
+	if !fraction && j < n && isIdentByte(q[j]) {
+		// 123abc and 1e10abc are identifiers.
+		l.pos = start
+		l.skipIdent()
+		return TokenIdent
+	}
+	l.pos = j
+	return TokenNumber
+}
+
+// scanWord scans a keyword, identifier, or string with a prefix like x'FF',
+// N'str', or _utf8mb4'str'.
+func (l *Lexer) scanWord() TokenType {
+	q := l.q
+	start := l.pos
+	l.skipIdent()
+	word := q[start:l.pos]
+	if l.pos < len(q) && q[l.pos] == '\'' {
+		switch {
+		case word == "x" || word == "X":
+			l.skipQuoted('\'')
+			return TokenHex
+		case word == "b" || word == "B":
+			l.skipQuoted('\'')
+			return TokenBit
+		case word == "n" || word == "N" || (len(word) > 1 && word[0] == '_'):
+			l.skipQuoted('\'')
+			return TokenString
+		}
+	}
+	if isKeyword(word) {
+		return TokenKeyword
+	}
+	return TokenIdent
+}
+
+// skipIdent advances pos past identifier bytes.
+func (l *Lexer) skipIdent() {
+	for l.pos < len(l.q) && isIdentByte(l.q[l.pos]) {
+		l.pos++
+	}
+}
+
+// skipQuoted advances pos past a quoted value that begins at pos. The value
+// ends at the first quote char that is not escaped with a backslash or doubled.
+// Backslash is not an escape char in `quoted identifiers`.
+func (l *Lexer) skipQuoted(quote byte) {
+	q := l.q
+	n := len(q)
+	l.pos++
+	for l.pos < n {
+		c := q[l.pos]
+		if c == '\\' && quote != '`' {
+			l.pos += 2
+			continue
+		}
+		l.pos++
+		if c == quote {
+			if l.pos < n && q[l.pos] == quote {
+				l.pos++
+				continue
+			}
+			return
+		}
+	}
+	l.pos = n
+}
+
+// skipLine advances pos past the end of the line, including the newline.
+func (l *Lexer) skipLine() {
+	if i := strings.IndexByte(l.q[l.pos:], '\n'); i >= 0 {
+		l.pos += i + 1
+	} else {
+		l.pos = len(l.q)
+	}
+}
+
+func isSpaceByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == '\v'
+}
+
+func isDigitByte(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexByte(c byte) bool {
+	return isDigitByte(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// isIdentByte returns true for bytes of unquoted identifiers. All bytes of
+// multi-byte UTF-8 characters are >= 0x80.
+func isIdentByte(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || isDigitByte(c) || c == '_' || c == '$' || c >= 0x80
+}
+
+// isKeyword returns true if the word is in keywords, ignoring case.
+func isKeyword(word string) bool {
+	var buf [32]byte
+	if len(word) > len(buf) {
+		return false
+	}
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		buf[i] = c
+	}
+	return keywords[string(buf[:len(word)])]
+}
+
+// keywords are MySQL 8.0 reserved words and words that begin common statements.
+var keywords = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`
+		accessible add all alter analyze and as asc asensitive before between bigint binary blob both by
+		call cascade case change char character check collate column condition constraint continue convert
+		create cross cube cume_dist current_date current_time current_timestamp current_user cursor
+		database databases day_hour day_microsecond day_minute day_second dec decimal declare default
+		delayed delete dense_rank desc describe deterministic distinct distinctrow div double drop dual
+		each else elseif empty enclosed escaped except exists exit explain false fetch first_value float
+		float4 float8 for force foreign from fulltext function generated get grant group grouping groups
+		having high_priority hour_microsecond hour_minute hour_second if ignore in index infile inner inout
+		insensitive insert int int1 int2 int3 int4 int8 integer intersect interval into io_after_gtids
+		io_before_gtids is iterate join json_table key keys kill lag last_value lateral lead leading leave
+		left like limit linear lines load localtime localtimestamp lock long longblob longtext loop
+		low_priority master_bind master_ssl_verify_server_cert match maxvalue mediumblob mediumint
+		mediumtext middleint minute_microsecond minute_second mod modifies natural not no_write_to_binlog
+		nth_value ntile null numeric of on optimize optimizer_costs option optionally or order out outer
+		outfile over partition percent_rank precision primary procedure purge range rank read reads
+		read_write real recursive references regexp release rename repeat replace require resignal restrict
+		return revoke right rlike row rows row_number schema schemas second_microsecond select sensitive
+		separator set show signal smallint spatial specific sql sqlexception sqlstate sqlwarning
+		sql_big_result sql_calc_found_rows sql_small_result ssl starting stored straight_join system table
+		terminated then tinyblob tinyint tinytext to trailing trigger true undo union unique unlock unsigned
+		update usage use using utc_date utc_time utc_timestamp values varbinary varchar varcharacter varying
+		virtual when where while window with write xor year_month zerofill
+
+		begin commit deallocate do duplicate end execute flush handler offset prepare rollback savepoint
+		start transaction truncate
+	`) {
+		keywords[w] = true
+	}
+}
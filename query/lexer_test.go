@@ -0,0 +1,217 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/percona/go-mysql/query"
+)
+
+// types returns the type and text of significant tokens.
+func types(q string) []string {
+	var got []string
+	for _, t := range query.Tokenize(q) {
+		if t.Type != query.TokenSpace {
+			got = append(got, t.Type.String()+" "+t.Text)
+		}
+	}
+	return got
+}
+
+func TestTokenize(t *testing.T) {
+	type testCase struct {
+		name     string
+		query    string
+		expected []string
+	}
+	testCases := []testCase{
+		{
+			name:  "keywords and identifiers",
+			query: "SELECT COUNT(*) FROM `my``tbl` t1",
+			expected: []string{
+				"Keyword SELECT", "Ident COUNT", "Operator (", "Operator *", "Operator )",
+				"Keyword FROM", "QuotedIdent `my``tbl`", "Ident t1",
+			},
+		},
+		{
+			name:  "strings",
+			query: `'it''s' "a\"b" N'n' _utf8mb4'u' _latin1 'x'`,
+			expected: []string{
+				"String 'it''s'", `String "a\"b"`, "String N'n'", "String _utf8mb4'u'",
+				"Ident _latin1", "String 'x'",
+			},
+		},
+		{
+			name:  "numbers",
+			query: "1 -2 3.5 5001. .5 6e-30 1E+9 123abc t.5",
+			expected: []string{
+				"Number 1", "Operator -", "Number 2", "Number 3.5", "Number 5001.", "Number .5",
+				"Number 6e-30", "Number 1E+9", "Ident 123abc", "Ident t", "Operator .", "Ident 5",
+			},
+		},
+		{
+			name:  "hex and bit",
+			query: "0xFF x'0A' X'0a' 0b01 b'10' 0xZZ",
+			expected: []string{
+				"Hex 0xFF", "Hex x'0A'", "Hex X'0a'", "Bit 0b01", "Bit b'10'", "Ident 0xZZ",
+			},
+		},
+		{
+			name:  "operators",
+			query: "a<=>b<=c>=d<>e!=f:=g||h&&i<<j>>k->'$.x'->>'$.y'",
+			expected: []string{
+				"Ident a", "Operator <=>", "Ident b", "Operator <=", "Ident c", "Operator >=", "Ident d",
+				"Operator <>", "Ident e", "Operator !=", "Ident f", "Operator :=", "Ident g", "Operator ||",
+				"Ident h", "Operator &&", "Ident i", "Operator <<", "Ident j", "Operator >>", "Ident k",
+				"Operator ->", "String '$.x'", "Operator ->>", "String '$.y'",
+			},
+		},
+		{
+			name:  "variables and placeholders",
+			query: "@a := @@GLOBAL.read_only + @'q v' + ?",
+			expected: []string{
+				"Variable @a", "Operator :=", "Variable @@GLOBAL.read_only", "Operator +",
+				"Variable @'q v'", "Operator +", "Placeholder ?",
+			},
+		},
+		{
+			name:  "comments",
+			query: "a # one\nb -- two\nc--d /* three */ /*+ BKA(t) */ /*!50001 x */ /*M!100100 y */",
+			expected: []string{
+				"Ident a", "Comment # one\n", "Ident b", "Comment -- two\n", "Ident c", "Operator -",
+				"Operator -", "Ident d", "Comment /* three */", "Hint /*+ BKA(t) */",
+				"VersionComment /*!50001 x */", "VersionComment /*M!100100 y */",
+			},
+		},
+		{
+			name:     "unterminated",
+			query:    "select 'abc /* x",
+			expected: []string{"Keyword select", "String 'abc /* x"},
+		},
+		{
+			name:     "nul",
+			query:    "a\x00b",
+			expected: []string{"Ident a", "Ident b"},
+		},
+		{
+			name:     "other",
+			query:    "a\x01",
+			expected: []string{"Ident a", "Other \x01"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, types(tc.query))
+		})
+	}
+}
+
+func TestTokenizeOffsets(t *testing.T) {
+	q := "SELECT a, 'b' FROM t -- c\nWHERE x=1 /* d */"
+	tokens := query.Tokenize(q)
+	var b strings.Builder
+	end := 0
+	for _, tok := range tokens {
+		assert.Equal(t, end, tok.Start)
+		assert.Equal(t, q[tok.Start:tok.End], tok.Text)
+		b.WriteString(tok.Text)
+		end = tok.End
+	}
+	assert.Equal(t, q, b.String())
+}
+
+func TestLexerNext(t *testing.T) {
+	l := query.NewLexer("select  1")
+	assert.Equal(t, query.Token{Type: query.TokenKeyword, Text: "select", Start: 0, End: 6}, l.Next())
+	assert.Equal(t, query.Token{Type: query.TokenSpace, Text: "  ", Start: 6, End: 8}, l.Next())
+	assert.Equal(t, query.Token{Type: query.TokenNumber, Text: "1", Start: 8, End: 9}, l.Next())
+	assert.Equal(t, query.TokenEOF, l.Next().Type)
+	assert.Equal(t, query.TokenEOF, l.Next().Type)
+
+	l = query.NewLexer(" /* c */ Select")
+	tok := l.NextSignificant()
+	assert.True(t, tok.Is("SELECT"))
+	assert.False(t, tok.Is("FROM"))
+}
+
+func TestFingerprintLexer(t *testing.T) {
+	type testCase struct {
+		name     string
+		query    string
+		expected string
+	}
+	testCases := []testCase{
+		{
+			name:     "single value in list",
+			query:    "SELECT * FROM t WHERE x IN(1)",
+			expected: "select * from t where x in(?+)",
+		},
+		{
+			name:     "several lists without spaces",
+			query:    "select * from t where a in(1) and b in(2)",
+			expected: "select * from t where a in(?+) and b in(?+)",
+		},
+		{
+			name:     "unary and binary minus",
+			query:    "select -1, a-1, a - -1, 2 - 1",
+			expected: "select ?, a-?, a - ?, ? - ?",
+		},
+		{
+			name:     "hex, bit, and prefixed strings",
+			query:    "select x'FF', 0x1F, b'01', 0b1, _utf8mb4'x', N'y'",
+			expected: "select ?, ?, ?, ?, ?, ?",
+		},
+		{
+			name:     "multi-row values",
+			query:    "insert into t values (1),(2) , (3)",
+			expected: "insert into t values(?+)",
+		},
+		{
+			name:     "unterminated quote",
+			query:    "select 'abc",
+			expected: "select ?",
+		},
+		{
+			name:     "unicode identifiers",
+			query:    "SELECT Ünï FROM t",
+			expected: "select ünï from t",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, query.Fingerprint(tc.query))
+		})
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query
+
+import (
+	"strings"
+)
+
+// A LiteralType is the type of a Literal.
+type LiteralType byte
+
+const (
+	LiteralString LiteralType = iota + 1 // 'string', "string", N'string', or _utf8mb4'string'
+	LiteralNumber                        // 1, -1, 1.5, or 1e-9
+	LiteralHex                           // 0xFF or x'FF'
+	LiteralBit                           // 0b01 or b'01'
+	LiteralNull                          // NULL, except in IS NULL and IS NOT NULL
+	LiteralList                          // IN or VALUES list, or row or tuple in a list
+)
+
+var literalType = map[TokenType]LiteralType{
+	TokenString: LiteralString,
+	TokenNumber: LiteralNumber,
+	TokenHex:    LiteralHex,
+	TokenBit:    LiteralBit,
+}
+
+var literalTypeName = map[LiteralType]string{
+	LiteralString: "String",
+	LiteralNumber: "Number",
+	LiteralHex:    "Hex",
+	LiteralBit:    "Bit",
+	LiteralNull:   "Null",
+	LiteralList:   "List",
+}
+
+func (t LiteralType) String() string {
+	if name, ok := literalTypeName[t]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// A Literal is a value replaced with ? in a fingerprint.
+//
+// An IN list is one LiteralList with the literals in the list. Multi-row
+// VALUES is one LiteralList with a LiteralList for each row. Tuples in a list,
+// like (1, 2) in IN ((1, 2), (3, 4)), are LiteralList, too, but parentheses of
+// function calls are not. Values that are not literals, like columns and
+// placeholders, are not in lists, so a list can be shorter than it is in the
+// query. Literals in subqueries in IN lists are not grouped.
+type Literal struct {
+	Type  LiteralType
+	Text  string    // as written in the query, with quotes and sign, like 'it''s' or -1
+	Start int       // byte offset of first byte in query
+	End   int       // byte offset after last byte in query
+	List  []Literal `json:",omitempty"` // literals in list, if Type is LiteralList
+}
+
+// Value returns the value of the literal: strings without prefix and quotes,
+// and with escape sequences replaced, hex and bit values without prefix and
+// quotes, and other literals as written. Lists return "".
+func (l Literal) Value() string {
+	switch l.Type {
+	case LiteralString:
+		return unescape(l.Text)
+	case LiteralHex:
+		return hexValue(l.Text)
+	case LiteralBit:
+		return bitValue(l.Text)
+	case LiteralList:
+		return ""
+	}
+	return l.Text
+}
+
+// FingerprintLiterals returns the fingerprint of q, like Fingerprint, and the
+// literals replaced with ? in order.
+func FingerprintLiterals(q string) (string, []Literal) {
+	return Fingerprinter{ReplaceNumbersInWords: ReplaceNumbersInWords}.FingerprintLiterals(q)
+}
+
+// FingerprintLiterals returns the fingerprint of q, like Fingerprint, and the
+// literals replaced with ? in order.
+func (fp Fingerprinter) FingerprintLiterals(q string) (string, []Literal) {
+	lits := []Literal{}
+	f := fp.fingerprint(nil, q, 0, &lits)
+	return string(f), lits
+}
+
+// listLiterals returns the literals in a list without its parentheses. base is
+// the offset of the list in the query.
+func listLiterals(list string, base int) []Literal {
+	type group struct {
+		lits  []Literal
+		start int
+		tuple bool // tuple, not function call
+	}
+	stack := []group{{tuple: true}}
+	l := Lexer{q: list}
+	var prev Token
+	sign := -1 // offset of unary sign
+	for t := l.NextSignificant(); t.Type != TokenEOF; t = l.NextSignificant() {
+		g := &stack[len(stack)-1]
+		switch {
+		case t.Text == "(":
+			tuple := prev.Type != TokenIdent && prev.Type != TokenKeyword && prev.Type != TokenQuotedIdent
+			stack = append(stack, group{start: t.Start, tuple: tuple})
+		case t.Text == ")" && len(stack) > 1:
+			inner := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			g = &stack[len(stack)-1]
+			if inner.tuple {
+				g.lits = append(g.lits, Literal{
+					Type:  LiteralList,
+					Text:  list[inner.start:t.End],
+					Start: base + inner.start,
+					End:   base + t.End,
+					List:  inner.lits,
+				})
+			} else {
+				g.lits = append(g.lits, inner.lits...)
+			}
+		case (t.Text == "-" || t.Text == "+") && !isOperand(prev):
+			sign = t.Start
+		case literalType[t.Type] != 0 || t.Is("null"):
+			start := t.Start
+			if sign >= 0 && sign+1 == t.Start && t.Type == TokenNumber {
+				start = sign
+			}
+			typ := literalType[t.Type]
+			if typ == 0 {
+				typ = LiteralNull
+			}
+			g.lits = append(g.lits, Literal{Type: typ, Text: list[start:t.End], Start: base + start, End: base + t.End})
+		}
+		if t.Text != "-" && t.Text != "+" {
+			sign = -1
+		}
+		prev = t
+	}
+	// Unterminated parentheses: the literals of each group follow those of
+	// the group it is in, so they are joined once, not once per group.
+	lits := stack[0].lits
+	for _, g := range stack[1:] {
+		lits = append(lits, g.lits...)
+	}
+	return lits
+}
+
+// unescape returns the value of a quoted string: without prefix like N or
+// _utf8mb4, without quotes, and with doubled quotes and MySQL escape sequences
+// replaced. \% and \_ keep the backslash, like MySQL.
+func unescape(s string) string {
+	s = stringValue(s)
+	if !strings.ContainsAny(s, `\'"`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case '0':
+				b.WriteByte(0)
+			case 'b':
+				b.WriteByte('\b')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'Z':
+				b.WriteByte(0x1a)
+			case '%', '_':
+				b.WriteByte('\\')
+				b.WriteByte(s[i])
+			default:
+				b.WriteByte(s[i])
+			}
+		case (c == '\'' || c == '"') && i+1 < len(s) && s[i+1] == c:
+			b.WriteByte(c)
+			i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
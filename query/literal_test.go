@@ -0,0 +1,128 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/percona/go-mysql/query"
+)
+
+func TestFingerprintLiterals(t *testing.T) {
+	q := "SELECT * FROM t WHERE a = 'it''s' AND b = -1 AND c IN (1, 'x', NULL, f(2)) AND d IS NULL LIMIT 10"
+	f, lits := query.FingerprintLiterals(q)
+	assert.Equal(t, query.Fingerprint(q), f)
+	assert.Equal(t, []query.Literal{
+		{Type: query.LiteralString, Text: "'it''s'", Start: 26, End: 33},
+		{Type: query.LiteralNumber, Text: "-1", Start: 42, End: 44},
+		{Type: query.LiteralList, Text: "(1, 'x', NULL, f(2))", Start: 54, End: 74, List: []query.Literal{
+			{Type: query.LiteralNumber, Text: "1", Start: 55, End: 56},
+			{Type: query.LiteralString, Text: "'x'", Start: 58, End: 61},
+			{Type: query.LiteralNull, Text: "NULL", Start: 63, End: 67},
+			{Type: query.LiteralNumber, Text: "2", Start: 71, End: 72},
+		}},
+		{Type: query.LiteralNumber, Text: "10", Start: 95, End: 97},
+	}, lits)
+	for _, l := range lits {
+		assert.Equal(t, l.Text, q[l.Start:l.End])
+	}
+	assert.Equal(t, "it's", lits[0].Value())
+}
+
+func TestFingerprintLiteralsValues(t *testing.T) {
+	q := "INSERT INTO t VALUES (1, 'a'), (2, 0xFF)"
+	f, lits := query.FingerprintLiterals(q)
+	assert.Equal(t, "insert into t values(?+)", f)
+	assert.Equal(t, []query.Literal{
+		{Type: query.LiteralList, Text: "(1, 'a'), (2, 0xFF)", Start: 21, End: 40, List: []query.Literal{
+			{Type: query.LiteralList, Text: "(1, 'a')", Start: 21, End: 29, List: []query.Literal{
+				{Type: query.LiteralNumber, Text: "1", Start: 22, End: 23},
+				{Type: query.LiteralString, Text: "'a'", Start: 25, End: 28},
+			}},
+			{Type: query.LiteralList, Text: "(2, 0xFF)", Start: 31, End: 40, List: []query.Literal{
+				{Type: query.LiteralNumber, Text: "2", Start: 32, End: 33},
+				{Type: query.LiteralHex, Text: "0xFF", Start: 35, End: 39},
+			}},
+		}},
+	}, lits)
+	assert.Equal(t, "ff", lits[0].List[1].List[1].Value())
+}
+
+func TestFingerprintLiteralsSubquery(t *testing.T) {
+	q := "select * from t where id in (select id from u where x = 5) and (a, b) in ((1,2),(3,4))"
+	f, lits := query.FingerprintLiterals(q)
+	assert.Equal(t, "select * from t where id in(select id from u where x = ?) and (a, b) in(?+)", f)
+	assert.Len(t, lits, 2)
+	assert.Equal(t, query.Literal{Type: query.LiteralNumber, Text: "5", Start: 56, End: 57}, lits[0])
+	assert.Equal(t, query.LiteralList, lits[1].Type)
+	assert.Len(t, lits[1].List, 2)
+	assert.Equal(t, "(3,4)", lits[1].List[1].Text)
+
+	// No literals
+	f, lits = query.FingerprintLiterals("select a from t where b = ?")
+	assert.Equal(t, "select a from t where b = ?", f)
+	assert.Empty(t, lits)
+}
+
+func TestLiteralValue(t *testing.T) {
+	type testCase struct {
+		lit      query.Literal
+		expected string
+	}
+	testCases := []testCase{
+		{query.Literal{Type: query.LiteralString, Text: `'a\'b\nc\%'`}, "a'b\nc\\%"},
+		{query.Literal{Type: query.LiteralString, Text: `"say ""hi"""`}, `say "hi"`},
+		{query.Literal{Type: query.LiteralString, Text: `_utf8mb4'x'`}, "x"},
+		{query.Literal{Type: query.LiteralHex, Text: "x'0A'"}, "0a"},
+		{query.Literal{Type: query.LiteralBit, Text: "0b101"}, "101"},
+		{query.Literal{Type: query.LiteralNumber, Text: "-1.5e3"}, "-1.5e3"},
+		{query.Literal{Type: query.LiteralNull, Text: "null"}, "null"},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, tc.lit.Value(), tc.lit.Text)
+	}
+	assert.Equal(t, "String", query.LiteralString.String())
+}
+
+func TestFingerprintLiteralsPrepare(t *testing.T) {
+	q := "PREPARE s FROM 'SELECT a FROM t WHERE b = 1'"
+	fp, lits := query.FingerprintLiterals(q)
+	assert.Equal(t, "prepare s from select a from t where b = ?", fp)
+	assert.Equal(t, []query.Literal{{Type: query.LiteralNumber, Text: "1", Start: 42, End: 43}}, lits)
+	assert.Equal(t, "1", q[42:43])
+
+	// Offsets in the unquoted SQL are not offsets in the query.
+	q = "PREPARE s FROM 'SELECT a FROM t WHERE b = ''x'''"
+	_, lits = query.FingerprintLiterals(q)
+	assert.Equal(t, []query.Literal{{Type: query.LiteralString, Text: "'x'", Start: 15, End: 48}}, lits)
+}
@@ -45,100 +45,124 @@ package query
 	like s/\d+/?/g and s/'[^']*'/?/g but the details, exceptions, and specials
 	cases make that only a partial, crude solution because, for example, with
 	col = 'It\s' an escaped quote char.' now the regex needs to handle inner,
-	escaped quotes so it becomes more complicated and slower. There are many
-	more difficult problems like this. Consequently, neither regex nor this
-	solution can be simple, but at least this solution is very fast compared
-	to regex because it makes a single pass through the query whereas regex
-	makes as many passes as there are regexes and, worst case, a single regex
-	can make several passes due to backtracking. To handle problems like these,
-	this solution is a simple state machine. In the previous example problem,
-	once the first quote char (') is seen we enter the inQuote state. In this
-	state, if an escape char (\) is seen, this is remembered and the next char
-	is ignored. This allows us to correctly detect the real ending quote char:
-	it's the first unescaped quote char. Consequently, we can simply ignore
-	everything else in the quoted value. The same basic logic applies to numbers
-	and value lists.
-
-	With that principle and basic logic in mind, you'll notice three major code
-	blocks:
-		1. Skip parts of the query for certain states.
-		2. Change state based on rune and current state.
-		3. Copy a slice of the query into the fingerprint.
-	The order is important because it enforces the basic logic: once we enter
-	certain states we must process and finish them first because in these states
-	we ignore everything but whatever ends the state. As mentioned above, the
-	inQuote state is handled in the first block. When in this state, only the
-	real ending quote char ends it. Consequently nothing will trick the parser;
-	for example the quoted value in col="INSERT INTO t VALUES ('no problem')"
-	will not be mistaken for another query and another quoted value. --
-	The second block is primarily where cpFromOffset and cpToOffset are set
-	which are used by the third block to copy that range of the query into the
-	fingerprint. The second block stops, switches state, copies, and lets
-	the code enter the first block which skips through number, quoted value, or
-	value list that the second block found.
+	escaped quotes so it becomes more complicated and slower.
+
+	Instead, the Lexer makes a single pass through the query and splits it into
+	tokens: words, quoted values, numbers, operators, comments, and so on. The
+	Lexer handles the difficult details like escaped quote chars, so once the
+	first quote char (') is seen, the whole quoted value is a single TokenString
+	and nothing in it will trick the fingerprinter; for example the quoted value
+	in col="INSERT INTO t VALUES ('no problem')" will not be mistaken for another
+	query and another quoted value.
+
+	Fingerprint then copies tokens into the fingerprint, one by one, and handles
+	the few tokens that matter:
+		1. Values (numbers, quoted strings, etc.) are replaced with ?.
+		2. Value lists after IN and VALUES are replaced with (?+).
+		3. Whitespace and comments are collapsed into a single space.
+	Everything else is lowercased and copied.
 */
 
 import (
-	"crypto/md5"
 	"fmt"
 	"io"
+	"math"
+	"os"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
+	"unsafe"
 )
 
-const (
-	unknown             byte = iota
-	inWord                   // \S+
-	inNumber                 // [0-9a-fA-Fx.-]
-	inSpace                  // space, tab, \r, \n
-	inOp                     // [=<>!] (usually precedes a number)
-	opOrNumber               // + in 2 + 2 or +3e-9
-	inQuote                  // '...' or "..."
-	subOrOLC                 // - or start of -- comment
-	inDash                   // -- begins a one-line comment if followed by space
-	inOLC                    // -- comment (at least one space after dash is required)
-	divOrMLC                 // / operator or start of /* comment */
-	mlcOrMySQLCode           // /* comment */ or /*! MySQL-specific code */
-	inMLC                    // /* comment */
-	inValues                 // VALUES (1), ..., (N)
-	moreValuesOrUnknown      // , (2nd+) or ON DUPLICATE KEY or end of query
-	orderBy                  // ORDER BY
-	onDupeKeyUpdate          // ON DUPLICATE KEY UPDATE
-	inNumberInWord           // e.g. db23
-	inBackticks              // `table-1`
-	inMySQLCode              // /*! MySQL-specific code */
-)
-
-var stateName map[byte]string = map[byte]string{
-	0:  "unknown",
-	1:  "inWord",
-	2:  "inNumber",
-	3:  "inSpace",
-	4:  "inOp",
-	5:  "opOrNumber",
-	6:  "inQuote",
-	7:  "subOrOLC",
-	8:  "inDash",
-	9:  "inOLC",
-	10: "divOrMLC",
-	11: "mlcOrMySQLCode",
-	12: "inMLC",
-	13: "inValues",
-	14: "moreValuesOrUnknown",
-	15: "orderBy",
-	16: "onDupeKeyUpdate",
-	17: "inNumberInWord",
-	18: "inBackTicks",
-	19: "inMySQLCode",
-}
-
 // Debug prints very verbose tracing information to STDOUT.
+//
+// Deprecated: Set Fingerprinter.Debug instead. Changing a package variable
+// affects all users of the package and is not safe for concurrent use.
 var Debug bool = false
 
 // ReplaceNumbersInWords enables replacing numbers in words. For example:
 // `SELECT c FROM org235.t` -> `SELECT c FROM org?.t`. For more examples
 // look at test query_test.go/TestFingerprintWithNumberInDbName.
+//
+// Deprecated: Set Fingerprinter.ReplaceNumbersInWords instead. Changing a
+// package variable affects all users of the package and is not safe for
+// concurrent use.
 var ReplaceNumbersInWords = false
 
+// A ListMode is how a Fingerprinter replaces IN and VALUES lists.
+type ListMode byte
+
+const (
+	// ListCollapse replaces each list with (?+), and multi-row VALUES with
+	// a single (?+): in(?+), values(?+).
+	ListCollapse ListMode = iota
+
+	// ListArity replaces each value with ?, keeping the number of values
+	// and rows: in(?, ?, ?), values(?, ?), (?, ?).
+	ListArity
+
+	// ListBuckets replaces each list with (?+) and the order of magnitude of
+	// the number of values in IN lists, or rows in VALUES, so a few values
+	// and a huge batch are different classes: in(?+/1-10) is 1 to 9 values,
+	// in(?+/10-100) is 10 to 99 values, and values(?+/1k-10k) is 1,000 to
+	// 9,999 rows.
+	ListBuckets
+)
+
+// A Fingerprinter returns fingerprints, the canonical forms of queries. The zero
+// value is ready to use and makes the same fingerprints as Fingerprint with the
+// default package settings. Options change how much of the query is kept, so
+// fingerprints made with different options must not be mixed. A Fingerprinter
+// is safe for concurrent use.
+type Fingerprinter struct {
+	// ReplaceNumbersInWords replaces numbers in unquoted identifiers, for
+	// example: `SELECT c FROM org235.t` -> `select c from org?.t`. Use
+	// IdentRules to replace numbers in some identifiers only.
+	ReplaceNumbersInWords bool
+
+	// KeepASC keeps ASC in ORDER BY clauses. By default, it is removed because
+	// ORDER BY col ASC is the same as ORDER BY col.
+	KeepASC bool
+
+	// KeepComments keeps comments as is. A comment that starts with -- or #
+	// is kept with the newline that ends it.
+	KeepComments bool
+
+	// KeepHints keeps optimizer hints like /*+ BKA(t) */ as is.
+	KeepHints bool
+
+	// StripVersionComments removes MySQL-specific code like /*!40001 SQL_NO_CACHE */.
+	// By default, it is kept and lowercased because it is executed.
+	StripVersionComments bool
+
+	// Lists is how IN and VALUES lists are replaced.
+	Lists ListMode
+
+	// KeepCase keeps the case of keywords and identifiers instead of
+	// lowercasing them.
+	KeepCase bool
+
+	// CollapseUnionRepeats replaces repeated identical UNION parts with a
+	// comment, like pt-fingerprint: `select ? union all select ? union all
+	// select ?` -> `select ? /*repeat union all*/`.
+	CollapseUnionRepeats bool
+
+	// IdentRules replace database, table, and other identifiers that match
+	// patterns, like shard_0 to shard_255, so sharded databases and tables
+	// partitioned by name are one class. The first matching rule is applied.
+	IdentRules []IdentRule
+
+	// Version is the version of the fingerprint rules. Zero means the latest
+	// version, LatestFingerprintVersion, so fingerprints can change when the
+	// package is updated. Set it, or use IDSpec, to keep fingerprints and IDs
+	// stable.
+	Version FingerprintVersion
+
+	// Debug, if set, receives very verbose tracing information.
+	Debug io.Writer
+}
+
 // Fingerprint returns the canonical form of q. The primary transformations are:
 //   - Replace values with ?
 //   - Collapse whitespace
@@ -149,693 +173,711 @@ var ReplaceNumbersInWords = false
 // original query without affecting its performance characteristics. For
 // example, "ORDER BY col ASC" is the same as "ORDER BY col", so "ASC" in the
 // fingerprint is removed.
+//
+// Queries come straight from logs, so q can be truncated, hostile, or not
+// SQL at all. For any q, Fingerprint does not panic and returns, and:
+//   - Fingerprint(Fingerprint(q)) == Fingerprint(q)
+//   - The fingerprint is not more than twice as long as q. It is usually
+//     shorter, but it cannot be bounded by the length of q: a value list
+//     like (1) is replaced with the longer (?+), which fingerprints have
+//     always had, and some lowercase letters are longer in UTF-8 than
+//     uppercase ones. Options like ListBuckets make it longer still.
+//   - Fingerprint takes time linear in the length of q.
+//   - Invalid UTF-8 in q is kept, not replaced.
+//
+// FuzzFingerprint checks these invariants.
+//
+// Fingerprint uses the default Fingerprinter, and the deprecated package
+// variables ReplaceNumbersInWords and Debug.
 func Fingerprint(q string) string {
-	q += " " // need range to run off end of original query
-	prevWord := ""
-	f := make([]byte, len(q))
-	fi := 0
-	pr := rune(0) // previous rune
-	s := unknown  // current state
-	sqlState := unknown
-	quoteChar := rune(0)
-	cpFromOffset := 0
-	cpToOffset := 0
-	addSpace := false
-	escape := false
-	parOpen := 0
-	parOpenTotal := 0
-	valueNo := 0
-	firstPar := 0
-
-	for qi, r := range q {
-		if Debug {
-			fmt.Printf("\n%d:%d %s/%s [%d:%d] %x %q\n", qi, fi, stateName[s], stateName[sqlState], cpFromOffset, cpToOffset, r, r)
-		}
-
-		/**
-		 * 1. Skip parts of the query for certain states.
-		 */
-
-		if s == inQuote {
-			// We're in a 'quoted value' or "quoted value".  The quoted value
-			// ends at the first non-escaped matching quote character (' or ").
-			if r != quoteChar {
-				// The only char inside a quoted value we need to track is \,
-				// the escape char.  This allows us to tell that the 2nd ' in
-				// '\'' is escaped, not the ending quote char.
-				if escape {
-					if Debug {
-						fmt.Println("Ignore quoted literal")
-					}
-					escape = false
-				} else if r == '\\' {
-					if Debug {
-						fmt.Println("Escape")
-					}
-					escape = true
-				} else {
-					if Debug {
-						fmt.Println("Ignore quoted value")
-					}
-				}
-			} else if escape {
-				// \' or \"
-				if Debug {
-					fmt.Println("Quote literal")
-				}
-				escape = false
-			} else {
-				// 'foo' -> ?
-				// "foo" -> ?
-				if Debug {
-					fmt.Println("Quote end")
-				}
-				escape = false
-
-				// qi = the closing quote char, so +1 to ensure we don't copy
-				// anything before this, i.e. quoted value is done, move on.
-				cpFromOffset = qi + 1
-
-				if sqlState == inValues {
-					// ('Hello world!', ...) -> VALUES (, ...)
-					// The inValues state uses this state to skip quoted values,
-					// so we don't replace them with ?; the inValues blocks will
-					// replace the entire value list with ?+.
-					s = inValues
-				} else {
-					f[fi] = '?'
-					fi++
-					s = unknown
-				}
-			}
-			continue
-		} else if s == inBackticks {
-			if r != '`' {
-				// The only char inside a quoted value we need to track is \,
-				// the escape char.  This allows us to tell that the 2nd ' in
-				// '\`' is escaped, not the ending quote char.
-				if escape {
-					if Debug {
-						fmt.Println("Ignore backtick literal")
-					}
-					escape = false
-				} else if r == '\\' {
-					if Debug {
-						fmt.Println("Escape")
-					}
-					escape = true
-				} else {
-					if Debug {
-						fmt.Println("Ignore quoted value")
-					}
-				}
-			} else if escape {
-				// \`
-				if Debug {
-					fmt.Println("Quote literal")
-				}
-				escape = false
-			} else {
-				if Debug {
-					fmt.Println("Quote end")
-				}
-				escape = false
+	fp := Fingerprinter{ReplaceNumbersInWords: ReplaceNumbersInWords}
+	if Debug {
+		fp.Debug = os.Stdout
+	}
+	return fp.Fingerprint(q)
+}
+
+// AppendFingerprint appends the fingerprint of q to dst and returns the
+// extended buffer, like Fingerprint. Reusing dst, like dst[:0], fingerprints
+// without allocating, which matters when fingerprinting every event of a log.
+// q must not be modified until AppendFingerprint returns; then it can be
+// reused.
+func AppendFingerprint(dst, q []byte) []byte {
+	fp := Fingerprinter{ReplaceNumbersInWords: ReplaceNumbersInWords}
+	if Debug {
+		fp.Debug = os.Stdout
+	}
+	return fp.AppendFingerprint(dst, q)
+}
 
-				// qi = the closing backtick, so +1 to ensure we don't copy
-				// anything before this, i.e. quoted value is done, move on.
-				// cpFromOffset = qi + 1
-				cpToOffset = qi + 1
+// Fingerprint returns the canonical form of q, see the package func Fingerprint.
+func (fp Fingerprinter) Fingerprint(q string) string {
+	return string(fp.appendFingerprint(nil, q))
+}
 
-				s = inWord
-			}
-			continue
+// AppendFingerprint appends the fingerprint of q to dst and returns the
+// extended buffer, see the package func AppendFingerprint.
+func (fp Fingerprinter) AppendFingerprint(dst, q []byte) []byte {
+	// q is not copied but read as a string, so q must not be modified during
+	// the call. Every byte of the fingerprint is copied to dst, and nothing
+	// references q when this returns, so the caller can reuse q.
+	return fp.appendFingerprint(dst, unsafe.String(unsafe.SliceData(q), len(q)))
+}
 
-		} else if s == inNumberInWord {
-			// Replaces number in words with ?
-			// e.g. `db37` to `db?`
-			// Parser can fall into inNumberInWord only if
-			// option ReplaceNumbersInWords is turned on
-			if r >= '0' && r <= '9' {
-				if Debug {
-					fmt.Println("Ignore digit in word")
-				}
-				continue
+// appendFingerprint appends the fingerprint of q to dst and returns the
+// extended buffer.
+func (fp Fingerprinter) appendFingerprint(dst []byte, q string) []byte {
+	return fp.fingerprint(dst, q, 0, nil)
+}
+
+// fingerprint appends the fingerprint of q to dst and returns the extended
+// buffer. If lits is not nil, the literals replaced in q are appended to it,
+// with offsets from base.
+func (fp Fingerprinter) fingerprint(dst []byte, q string, base int, lits *[]Literal) []byte {
+	v2 := fp.version() >= FingerprintV2
+	if v2 {
+		if dst, ok := appendAdmin(dst, q); ok {
+			return dst
+		}
+	} else if trimmed := strings.TrimLeft(q, " \t\r\n"); len(trimmed) >= 22 && strings.EqualFold(trimmed[:22], "administrator command:") {
+		return append(dst, q...)
+	}
+
+	f := fingerprinter{fp: fp, dst: dst, start: len(dst), lx: Lexer{q: q}, base: base, lits: lits}
+	if len(fp.IdentRules) > 0 {
+		f.idents = identScopes(q)
+	}
+	first := f.lx
+	if t := first.NextSignificant(); t.Is("use") {
+		return append(dst, "use ?"...)
+	} else if v2 && f.command(t, first) {
+		return f.dst
+	} else if t.Is("call") {
+		// Stored procedure args are values, so only the name matters.
+		f.writeCase(t.Text)
+		f.dst = append(f.dst, ' ')
+		for t = first.NextSignificant(); t.Type != TokenEOF; t = first.NextSignificant() {
+			if t.Text == "(" || t.Text == ";" {
+				break
+			}
+			f.appendCase(t.Text)
+		}
+		return f.dst
+	}
+
+	f.run()
+	if fp.CollapseUnionRepeats {
+		f.collapseUnionRepeats()
+	}
+	return f.dst
+}
+
+// version returns the version of the fingerprint rules of fp.
+func (fp Fingerprinter) version() FingerprintVersion {
+	if fp.Version == 0 {
+		return LatestFingerprintVersion
+	}
+	return fp.Version
+}
+
+// fingerprinter holds the state of one Fingerprint call.
+type fingerprinter struct {
+	fp    Fingerprinter
+	dst   []byte
+	start int // start of fingerprint in dst
+	lx    Lexer
+	base  int        // offset of q in original query
+	lits  *[]Literal // literals replaced, if not nil
+
+	space    bool   // pending space, written before the next token
+	lineEnd  bool   // pending space after -- or # comment, unless punctuation
+	prevWord string // previous significant token if keyword or identifier
+	prevSig  Token  // previous significant token
+	depth    int    // parentheses depth
+
+	orderBy      bool // in ORDER BY clause
+	orderByDepth int  // depth of ORDER BY clause
+	onDupe       bool // in ON DUPLICATE KEY UPDATE clause
+
+	signAt    int  // offset in dst of unary sign, or -1
+	signStart int  // offset in query of unary sign
+	signNext  int  // offset in query after unary sign
+	prevSign  bool // previous token was unary sign
+
+	prepare bool // in PREPARE or EXECUTE IMMEDIATE before the SQL string
+	show    bool // in SHOW statement
+
+	idents map[int]IdentScope // scope of names by offset, if IdentRules
+}
+
+func (f *fingerprinter) run() {
+	f.signAt = -1
+	for {
+		t := f.lx.Next()
+		if f.fp.Debug != nil {
+			fmt.Fprintf(f.fp.Debug, "%d:%d %s %q\n", t.Start, t.End, t.Type, t.Text)
+		}
+		if t.Type == TokenEOF {
+			return
+		}
+		if f.lineEnd && t.Significant() {
+			f.lineEnd = false
+			if !isPunctuationByte(t.Text[0]) {
+				f.space = true
 			}
-			// 123 -> ?, 0xff -> ?, 1e-9 -> ?, etc.
-			if Debug {
-				fmt.Println("Number in word end")
+		}
+
+		switch t.Type {
+		case TokenSpace:
+			f.space = true
+			continue
+		case TokenComment:
+			if f.fp.KeepComments {
+				f.write(t.Text)
+				if t.Text[0] != '/' {
+					// -- and # comments end with the newline.
+					continue
+				}
+			}
+			// /* comments */ separate tokens. -- and # comments end with the
+			// newline, which separates tokens unless they are punctuation,
+			// like foo-- bar\n,foo. The next token decides.
+			if t.Text[0] == '/' {
+				f.space = true
+			} else if len(f.dst) > f.start && !isPunctuationByte(f.dst[len(f.dst)-1]) {
+				f.lineEnd = true
 			}
-			f[fi] = '?'
-			fi++
-			cpFromOffset = qi
-			if isSpace(r) {
-				s = unknown
+			continue
+		case TokenHint:
+			if f.fp.KeepHints {
+				f.write(t.Text)
 			} else {
-				s = inWord
+				f.space = true
 			}
-		} else if s == inNumber {
-			// We're in a number which can be something simple like 123 or
-			// something trickier like 1e-9 or 0xFF.  The pathological case is
-			// like 12ff: this is valid hex number and a valid ident (e.g. table
-			// name).  We can't detect this; the best we can do is realize that
-			// 12ffz is not a number because of the z.
-			if (r >= '0' && r <= '9') || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F') || r == '.' || r == 'x' || r == '-' {
-				if Debug {
-					fmt.Println("Ignore digit")
-				}
+			continue
+		case TokenVersionComment:
+			if f.fp.StripVersionComments {
+				f.space = true
 				continue
 			}
-			if (r >= 'g' && r <= 'z') || (r >= 'G' && r <= 'Z') || r == '_' {
-				if Debug {
-					fmt.Println("Not a number")
-				}
-				cpToOffset = qi
-				s = inWord
-			} else if sqlState == inMySQLCode {
-				// If we are in /*![version] ... */, keep the version number
-				cpToOffset = qi
-				s = inWord
-				sqlState = unknown
+		}
+
+		sign := f.prevSign
+		f.prevSign = false
+		switch t.Type {
+		case TokenString:
+			if f.prepare && (f.prevSig.Is("from") || f.prevSig.Is("immediate")) {
+				f.prepared(t)
+				break
+			}
+			if f.prevSig.Type == TokenOperator && (f.prevSig.Text == "->" || f.prevSig.Text == "->>") {
+				// JSON path like col->'$.a' is not a value.
+				f.write(t.Text)
+				break
+			}
+			fallthrough
+		case TokenNumber, TokenHex, TokenBit, TokenPlaceholder:
+			start := t.Start
+			if sign && t.Start == f.signNext {
+				// -1 is one value, not an operator and a value.
+				f.dst = f.dst[:f.signAt]
+				f.space = false
+				start = f.signStart
+			}
+			f.write("?")
+			if f.lits != nil && t.Type != TokenPlaceholder {
+				*f.lits = append(*f.lits, Literal{
+					Type:  literalType[t.Type],
+					Text:  f.lx.q[start:t.End],
+					Start: f.base + start,
+					End:   f.base + t.End,
+				})
+			}
+		case TokenKeyword, TokenIdent:
+			f.word(t)
+		case TokenOperator:
+			switch t.Text {
+			case "(":
+				f.depth++
+			case ")":
+				f.depth--
+				if f.orderBy && f.depth < f.orderByDepth {
+					f.orderBy = false
+				}
+			case "+", "-":
+				if sign && t.Start == f.signNext {
+					// --1 is one value too.
+					f.signNext = t.End
+					f.prevSign = true
+				} else if !isOperand(f.prevSig) {
+					f.flushSpace()
+					f.signAt = len(f.dst)
+					f.signStart = t.Start
+					f.signNext = t.End
+					f.prevSign = true
+				}
+			}
+			f.write(t.Text)
+		case TokenOther:
+			f.write(t.Text)
+		case TokenQuotedIdent:
+			if s, ok := f.replaceIdent(t); ok {
+				f.writeCase(s)
 			} else {
-				// 123 -> ?, 0xff -> ?, 1e-9 -> ?, etc.
-				if Debug {
-					fmt.Println("Number end")
-				}
-				f[fi] = '?'
-				fi++
-				cpFromOffset = qi
-				cpToOffset = qi
-				s = unknown
+				f.writeCase(t.Text)
 			}
-		} else if s == inValues {
-			// We're in the (val1),...,(valN) after IN or VALUE[S].  A single
-			// () value ends when the parenthesis are balanced, but...
-			if r == ')' {
-				parOpen--
-				parOpenTotal++
-				if Debug {
-					fmt.Println("Close parenthesis", parOpen)
-				}
-			} else if r == '(' {
-				parOpen++
-				if Debug {
-					fmt.Println("Open parenthesis", parOpen)
-				}
-				if parOpen == 1 {
-					firstPar = qi
-				}
-			} else if r == '\'' || r == '"' {
-				// VALUES ('Hello world!') -> enter inQuote state to skip
-				// the quoted value so ')' in 'This ) is a trick' doesn't
-				// balance an outer parenthesis.
-				if Debug {
-					fmt.Println("Quote begin")
-				}
-				s = inQuote
-				quoteChar = r
-				continue
-			} else if isSpace(r) {
-				if Debug {
-					fmt.Println("Space")
-				}
-				continue
-			}
-			if parOpen > 0 {
-				// Parenthesis are not balanced yet; i.e. haven't reached
-				// closing ) for this value.
-				continue
-			}
-			if parOpenTotal == 0 {
-				// SELECT value FROM t
-				if Debug {
-					fmt.Println("Literal values not VALUES()")
-				}
-				s = inWord
-				continue
+		default: // variables, version comments
+			f.writeCase(t.Text)
+		}
+
+		if t.Type != TokenKeyword && t.Type != TokenIdent {
+			f.prevWord = ""
+		}
+		f.prevSig = t
+	}
+}
+
+// word handles keywords and identifiers.
+func (f *fingerprinter) word(t Token) {
+	prev := f.prevWord
+	f.prevWord = t.Text
+	switch {
+	case t.Is("null"):
+		if strings.EqualFold(prev, "is") || strings.EqualFold(prev, "not") {
+			f.writeCase(t.Text)
+			return
+		}
+		f.write("?")
+		if f.lits != nil {
+			*f.lits = append(*f.lits, Literal{Type: LiteralNull, Text: t.Text, Start: f.base + t.Start, End: f.base + t.End})
+		}
+		return
+	case t.Is("asc") && f.orderBy && !f.fp.KeepASC:
+		// ORDER BY col ASC is the same as ORDER BY col.
+		f.space = false
+		return
+	case f.show && (t.Is("session") || t.Is("local")) && strings.EqualFold(prev, "show"):
+		// SHOW SESSION STATUS is SHOW STATUS.
+		f.prevWord = prev
+		return
+	case f.show && (t.Is("like") || t.Is("where")):
+		// LIKE and WHERE filter the rows of SHOW, which is the same command
+		// for every filter.
+		f.lx.pos = len(f.lx.q)
+		f.space = false
+		return
+	case t.Is("by") && strings.EqualFold(prev, "order"):
+		f.orderBy = true
+		f.orderByDepth = f.depth
+	case t.Is("update") && strings.EqualFold(prev, "key"):
+		f.onDupe = true
+	case (t.Is("in") || t.Is("values") || t.Is("value")) && !f.onDupe:
+		next := f.lx
+		open := next.NextSignificant()
+		rows := open.Is("row") && !t.Is("in") // VALUES ROW(1), ROW(2)
+		if rows {
+			open = next.NextSignificant()
+		}
+		if open.Text == "(" {
+			f.writeCase(t.Text)
+			f.valueList(t.Is("in"), rows)
+			f.prevWord = ""
+			return
+		}
+	}
+
+	if t.Type == TokenIdent {
+		if s, ok := f.replaceIdent(t); ok {
+			f.writeCase(s)
+			return
+		}
+	}
+	if t.Type == TokenIdent && len(t.Text) > 1 && t.Text[0] == '0' && (t.Text[1] == 'X' || t.Text[1] == 'B') {
+		// 0X1 is an identifier, but 0x1 is a number.
+		f.write(t.Text[:2])
+		f.appendCase(t.Text[2:])
+		return
+	}
+	if f.fp.ReplaceNumbersInWords && t.Type == TokenIdent && !isDigitByte(t.Text[0]) {
+		f.flushSpace()
+		f.appendNumbersReplaced(t.Text)
+		return
+	}
+	f.writeCase(t.Text)
+}
+
+// valueList replaces the value list that begins with the next significant
+// token, which is "(", with (?+), or () if the list is empty. A subquery in the
+// list is fingerprinted instead. Multi-row lists like VALUES (1), (2) are
+// replaced with a single (?+) unless in is true. With ListArity, each value
+// is replaced with ? instead, and each row is kept. If rows is true, each row
+// begins with ROW, like VALUES ROW(1), ROW(2).
+func (f *fingerprinter) valueList(in, rows bool) {
+	arity := f.fp.Lists == ListArity
+	buckets := f.fp.Lists == ListBuckets
+	list := Literal{Type: LiteralList, Start: -1}
+	subquery := false
+	unclosed := false // (?+ of VALUES is closed when the rows are counted
+	bucket := ""      // first row if it is a bucket, like ?+/1-10
+	row := 0
+	for ; ; row++ {
+		if row > 0 && arity {
+			f.dst = append(f.dst, ", "...)
+		}
+		if rows {
+			kw := f.lx.NextSignificant()
+			if row == 0 {
+				f.dst = append(f.dst, ' ')
 			}
-			// (<anything>) -> (?+) only for first value, but check for subqueries
-			if Debug {
-				fmt.Println("Values end")
+			if row == 0 || arity {
+				f.appendCase(kw.Text)
 			}
-			valueNo++
-			if valueNo == 1 {
-				if qi-firstPar > 1 {
-					// Check if this is a subquery by looking for SELECT keyword
-					content := strings.ToLower(strings.TrimSpace(q[firstPar+1 : qi]))
-					if strings.HasPrefix(content, "select") {
-						// This is a subquery, fingerprint it recursively
-						subqueryFingerprint := Fingerprint(q[firstPar+1 : qi])
-						subqueryResult := "(" + subqueryFingerprint + ")"
-						if Debug {
-							fmt.Printf("Subquery detected: %s -> %s\n", q[firstPar+1:qi], subqueryFingerprint)
-						}
-						copy(f[fi:fi+len(subqueryResult)], subqueryResult)
-						fi += len(subqueryResult)
-					} else {
-						// Regular value list
-						copy(f[fi:fi+4], "(?+)")
-						fi += 4
-					}
+		}
+		open := f.lx.NextSignificant()
+		inner, end := f.closeParen(open.End)
+		f.lx.pos = end
+		f.lx.prev = TokenOperator
+		f.lx.last = ')'
+
+		if f.lits != nil {
+			if row == 0 {
+				sub := Lexer{q: inner}
+				subquery = isSubquery(sub.NextSignificant())
+			}
+			if list.Start < 0 {
+				list.Start = f.base + open.Start
+			}
+			list.End = f.base + end
+			if !subquery {
+				items := listLiterals(inner, f.base+open.End)
+				if in {
+					list.List = items
 				} else {
-					// INSERT INTO t VALUES ()
-					copy(f[fi:fi+2], "()")
-					fi += 2
+					list.List = append(list.List, Literal{
+						Type:  LiteralList,
+						Text:  f.lx.q[open.Start:end],
+						Start: f.base + open.Start,
+						End:   f.base + end,
+						List:  items,
+					})
 				}
-				firstPar = 0
 			}
-			// ... the difficult part is that there may be other values, e.g.
-			// (1), (2), (3).  So we enter the following state.  The values list
-			// ends when the next char is not a comma.
-			s = moreValuesOrUnknown
-			pr = r
-			cpFromOffset = qi + 1
-			parOpenTotal = 0
-			continue
-		} else if s == inMLC {
-			// We're in a /* mutli-line comments */.  Skip and ignore it all.
-			if pr == '*' && r == '/' {
-				// /* foo */ -> (nothing)
-				if Debug {
-					fmt.Printf("Multi-line comment end. pr: %s\n", string(pr))
-				}
-				s = unknown
-			} else {
-				pr = r
-				if Debug {
-					fmt.Println("Ignore multi-line comment content")
-				}
-			}
-			continue
-		} else if s == mlcOrMySQLCode {
-			// We're at the start of either a /* multi-line comment */ or some
-			// /*![version] some MySQL-specific code */.  The ! after the /*
-			// determines which one.
-			if r != '!' {
-				if Debug {
-					fmt.Println("Multi-line comment")
-				}
-				pr = r
-				s = inMLC
-				continue
-			} else {
-				// /*![version] SQL_NO_CACHE */ -> /*![version] SQL_NO_CACHE */ (no change)
-				if Debug {
-					fmt.Println("MySQL-specific code")
-				}
-				s = inWord
-				sqlState = inMySQLCode
-			}
-		} else if s == inOLC {
-			// We're in a -- one line comment.  A space after -- is required.
-			// It ends at the end of the line, but there can be more query after
-			// it like:
-			//   SELECT * -- comment
-			//   FROM t
-			// is really "SELECT * FROM t".
-			if r == 0x0A { // newline
-				if Debug {
-					fmt.Println("One-line comment end")
-				}
-				s = unknown
-			}
-			continue
-		} else if isSpace(r) && isSpace(pr) {
-			// All space is collapsed into a single space, so if this char is
-			// a space and the previous was too, then skip the extra space.
-			if Debug {
-				fmt.Println("Skip space")
-			}
-			// +1 here ensures we actually skip the extra space in certain
-			// cases like "select \n-- bar\n foo".  When a part of the query
-			// triggers a copy of preceding chars, if the only preceding char
-			// is a space then it's incorrectly copied, but +1 sets cpFromOffset
-			// to the same offset as the trigger char, thus avoiding the copy.
-			// For example in that ^ query, the offsets are:
-			//   0 's'
-			//   1 'e'
-			//   2 'l'
-			//   3 'e'
-			//   4 'c'
-			//   5 't'
-			//   6 ' '
-			//   7 '\n'
-			//   8 '-'
-			// After copying 'select ', we are here @ 7 and intend to skip the
-			// newline.  Next, the '-' @ 8 triggers a copy of any preceding
-			// chars.  So here if we set cpFromOffset = 7 then 7:8 is copied,
-			// the newline, but setting cpFromOffset = 7 + 1 is 8:8 and so
-			// nothing is copied as we want.  Actually, cpToOffset is still 6
-			// in this case, but 8:6 avoids the copy too.
-			cpFromOffset = qi + 1
-			pr = r
-			continue
 		}
 
-		/**
-		 * 2. Change state based on rune and current state.
-		 */
-
-		switch {
-		case r >= 0x30 && r <= 0x39: // 0-9
-			switch s {
-			case opOrNumber:
-				if Debug {
-					fmt.Println("+/-First digit")
-				}
-				cpToOffset = qi - 1
-				s = inNumber
-			case inOp:
-				if Debug {
-					fmt.Println("First digit after operator")
-				}
-				cpToOffset = qi
-				s = inNumber
-			case inWord:
-				if pr == '(' {
-					if Debug {
-						fmt.Println("Number in function")
-					}
-					cpToOffset = qi
-					s = inNumber
-				} else if pr == ',' {
-					// foo,4 -- 4 may be a number literal or a word/ident
-					if Debug {
-						fmt.Println("Number or word")
+		if row == 0 || arity {
+			sub := Lexer{q: inner}
+			switch first := sub.NextSignificant(); {
+			case first.Type == TokenEOF:
+				f.dst = append(f.dst, "()"...)
+			case isSubquery(first):
+				f.dst = append(f.dst, '(')
+				f.dst = f.fp.fingerprint(f.dst, inner, f.base+open.End, f.lits)
+				f.dst = append(f.dst, ')')
+			case arity:
+				f.dst = append(f.dst, '(')
+				for i := listLen(inner); i > 0; i-- {
+					f.dst = append(f.dst, '?')
+					if i > 1 {
+						f.dst = append(f.dst, ", "...)
 					}
-					s = inNumber
-					cpToOffset = qi
+				}
+				f.dst = append(f.dst, ')')
+			case buckets:
+				bucket = strings.TrimSpace(inner)
+				if !isBucket(bucket) {
+					// A bucket, like in(?+/10-100), is kept so that
+					// fingerprints of fingerprints do not change.
+					bucket = ""
+				}
+				f.dst = append(f.dst, "(?+"...)
+				if in {
+					f.dst = appendBucket(f.dst, listLen(inner), bucket)
+					f.dst = append(f.dst, ')')
 				} else {
-					if Debug {
-						fmt.Println("Number in word")
-					}
-					if ReplaceNumbersInWords {
-						s = inNumberInWord
-						cpToOffset = qi
-					}
+					unclosed = true
 				}
 			default:
-				if Debug {
-					fmt.Println("Number literal")
-				}
-				s = inNumber
-				cpToOffset = qi
-			}
-		case isSpace(r):
-			if s == unknown {
-				if Debug {
-					fmt.Println("Lost in space")
-				}
-				if fi > 0 && !isSpace(rune(f[fi-1])) {
-					if Debug {
-						fmt.Println("Add space")
-					}
-					f[fi] = ' '
-					fi++
-					// This is a common case: a space after skipping something,
-					// e.g. col = 'foo'<space>. We want only the first space,
-					// so advance cpFromOffset to whatever is after the space
-					// and if it's more space then space skipping block will
-					// handle it.
-				}
-				cpFromOffset = qi + 1
-			} else if s == inDash {
-				if Debug {
-					fmt.Println("One-line comment begin")
-				}
-				s = inOLC
-				if cpToOffset > 2 {
-					cpToOffset = qi - 2
-				}
-			} else if s == moreValuesOrUnknown {
-				if Debug {
-					fmt.Println("Space after values")
-				}
-				if valueNo == 1 {
-					f[fi] = ' '
-					fi++
-				}
-			} else {
-				if Debug {
-					fmt.Println("Word end")
-				}
-				word := strings.ToLower(q[cpFromOffset:qi])
-				// Only match USE if it is the first word in the query, otherwise,
-				// it could be a USE INDEX
-				if word == "use" && prevWord == "" {
-					return "use ?"
-				} else if (word == "null" && (prevWord != "is" && prevWord != "not")) || word == "null," {
-					if Debug {
-						fmt.Println("NULL as value")
-					}
-					f[fi] = '?'
-					fi++
-					if word[len(word)-1] == ',' {
-						f[fi] = ','
-						fi++
-					}
-					f[fi] = ' '
-					fi++
-					cpFromOffset = qi + 1
-				} else if prevWord == "order" && word == "by" {
-					if Debug {
-						fmt.Println("ORDER BY begin")
-					}
-					sqlState = orderBy
-				} else if sqlState == orderBy && wordIn(word, "asc", "asc,", "asc ") {
-					if Debug {
-						fmt.Println("ORDER BY ASC")
-					}
-					cpFromOffset = qi
-					if word[len(word)-1] == ',' {
-						fi--
-						f[fi] = ','
-						f[fi+1] = ' '
-						fi += 2
-					}
-				} else if prevWord == "key" && word == "update" {
-					if Debug {
-						fmt.Println("ON DUPLICATE KEY UPDATE begin")
-					}
-					sqlState = onDupeKeyUpdate
-				}
-				s = inSpace
-				cpToOffset = qi
-				addSpace = true
-			}
-		case r == '\'' || r == '"':
-			if pr != '\\' {
-				if s != inQuote {
-					if Debug {
-						fmt.Println("Quote begin")
-					}
-					s = inQuote
-					quoteChar = r
-					cpToOffset = qi
-					if pr == 'x' || pr == 'b' {
-						if Debug {
-							fmt.Println("Hex/binary value")
-						}
-						// We're at the first quote char of x'0F'
-						// (or b'0101', etc.), so -2 for the quote char and
-						// the x or b char to copy anything before and up to
-						// this value.
-						cpToOffset = -2
-					}
-				}
-			}
-		case r == '`':
-			if pr != '\\' {
-				if s != inBackticks {
-					if Debug {
-						fmt.Println("Beckticks begin")
-					}
-					s = inBackticks
-				}
+				f.dst = append(f.dst, "(?+)"...)
 			}
-		case r == '=' || r == '<' || r == '>' || r == '!':
-			if Debug {
-				fmt.Println("Operator")
-			}
-			if s != inWord && s != inOp {
-				cpFromOffset = qi
-			}
-			s = inOp
-		case r == '/':
-			if Debug {
-				fmt.Println("Op or multi-line comment")
-			}
-			s = divOrMLC
-		case r == '*' && s == divOrMLC:
-			if Debug {
-				fmt.Println("Multi-line comment or MySQL-specific code")
-			}
-			s = mlcOrMySQLCode
-		case r == '+':
-			if Debug {
-				fmt.Println("Operator or number")
+		}
+		if in {
+			break
+		}
+
+		// VALUES (1), (2), ...
+		next := f.lx
+		if comma := next.NextSignificant(); comma.Text != "," {
+			break
+		}
+		afterComma := next
+		if open := next.NextSignificant(); open.Text != "(" && !(rows && open.Is("row")) {
+			break
+		}
+		f.lx = afterComma
+	}
+	if unclosed {
+		if row > 0 {
+			bucket = ""
+		}
+		f.dst = appendBucket(f.dst, row+1, bucket)
+		f.dst = append(f.dst, ')')
+	}
+	if f.lits != nil && list.Start >= 0 && !subquery {
+		list.Text = f.lx.q[list.Start-f.base : list.End-f.base]
+		*f.lits = append(*f.lits, list)
+	}
+	f.prevSig = Token{Type: TokenOperator, Text: ")"}
+	f.space = false
+}
+
+// appendBucket appends the bucket of n values or rows to dst, like /10-100,
+// or /bucket without ?+ if bucket is not "".
+func appendBucket(dst []byte, n int, bucket string) []byte {
+	if bucket != "" {
+		return append(dst, bucket[2:]...)
+	}
+	lo := 1
+	for n >= lo*10 {
+		lo *= 10
+	}
+	dst = append(dst, '/')
+	dst = appendMagnitude(dst, lo)
+	dst = append(dst, '-')
+	return appendMagnitude(dst, lo*10)
+}
+
+// appendMagnitude appends n, a power of 10, to dst like 100, 1k, or 10m.
+func appendMagnitude(dst []byte, n int) []byte {
+	suffix := ""
+	for _, s := range []string{"k", "m", "g", "t", "p", "e"} {
+		if n < 1000 {
+			break
+		}
+		n /= 1000
+		suffix = s
+	}
+	dst = strconv.AppendInt(dst, int64(n), 10)
+	return append(dst, suffix...)
+}
+
+// isBucket returns true if s is a bucket made by appendBucket, like ?+/1-10.
+func isBucket(s string) bool {
+	if !strings.HasPrefix(s, "?+/") {
+		return false
+	}
+	var buf [32]byte
+	for n := 1; n <= math.MaxInt/100; n *= 10 {
+		if string(appendBucket(buf[:0], n, "")) == s[2:] {
+			return true
+		}
+	}
+	return false
+}
+
+// isSubquery returns true if the first token of a list begins a subquery.
+func isSubquery(first Token) bool {
+	return first.Is("select") || first.Is("with") || first.Is("table")
+}
+
+// listLen returns the number of values in a list without its parentheses,
+// like 1, (2, 3), 'a' which has 3 values.
+func listLen(list string) int {
+	l := Lexer{q: list}
+	n := 1
+	depth := 0
+	for t := l.NextSignificant(); t.Type != TokenEOF; t = l.NextSignificant() {
+		switch t.Text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case ",":
+			if depth == 0 {
+				n++
 			}
-			s = opOrNumber
-		case r == '-':
-			if pr == '-' {
-				if Debug {
-					fmt.Println("Dash")
-				}
-				s = inDash
+		}
+	}
+	return n
+}
+
+// closeParen returns the query between offset start and its matching ")",
+// and the offset after ")". If there is no matching ")", the rest of the
+// query is returned. Only strings, quoted identifiers, and comments, which
+// can contain parentheses, are lexed, so long value lists are skipped fast.
+func (f *fingerprinter) closeParen(start int) (string, int) {
+	q := f.lx.q
+	l := Lexer{q: q}
+	depth := 1
+	for i := start; i < len(q); {
+		switch q[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return q[start:i], i + 1
+			}
+		case '\'', '"', '`', '#', '-', '/':
+			l.pos = i
+			l.scan()
+			i = l.pos
+			continue
+		}
+		i++
+	}
+	return q[start:], len(q)
+}
+
+// flushSpace writes the pending space, if any.
+func (f *fingerprinter) flushSpace() {
+	if f.space && len(f.dst) > f.start {
+		if c := f.dst[len(f.dst)-1]; c != ' ' && c != '\n' {
+			f.dst = append(f.dst, ' ')
+		}
+	}
+	f.space = false
+}
+
+func (f *fingerprinter) write(s string) {
+	if len(s) > 0 && s[0] == '-' && len(f.dst) > f.start && f.dst[len(f.dst)-1] == '-' {
+		// -- followed by a space begins a comment.
+		f.space = true
+	}
+	f.flushSpace()
+	f.dst = append(f.dst, s...)
+}
+
+func (f *fingerprinter) writeCase(s string) {
+	f.flushSpace()
+	f.appendCase(s)
+}
+
+// appendCase appends s in lowercase, unless KeepCase is set.
+func (f *fingerprinter) appendCase(s string) {
+	if f.fp.KeepCase {
+		f.dst = append(f.dst, s...)
+		return
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				// Invalid UTF-8 is kept, not replaced with U+FFFD.
+				f.dst = append(f.dst, c)
 			} else {
-				if Debug {
-					fmt.Println("Operator or number")
-				}
-				s = opOrNumber
-			}
-		case r == '.':
-			if s == inNumber || s == inOp {
-				if Debug {
-					fmt.Println("Floating point number")
-				}
-				s = inNumber
-				cpToOffset = qi
-			}
-		case r == '(':
-			if prevWord == "call" {
-				// 'CALL foo(...)' -> 'call foo'
-				if Debug {
-					fmt.Println("CALL sp_name")
-				}
-				return "call " + q[cpFromOffset:qi]
-			} else if sqlState != onDupeKeyUpdate && (((s == inSpace || s == moreValuesOrUnknown) && (prevWord == "value" || prevWord == "values" || prevWord == "in")) || wordIn(q[cpFromOffset:qi], "value", "values", "in")) {
-				// VALUE(, VALUE (, VALUES(, VALUES (, IN(, or IN(
-				// but not after ON DUPLICATE KEY UPDATE
-				if Debug {
-					fmt.Println("Values begin")
-				}
-				s = inValues
-				sqlState = inValues
-				parOpen = 1
-				firstPar = qi
-				if valueNo == 0 {
-					cpToOffset = qi
-				}
-			} else if s != inWord {
-				if Debug {
-					fmt.Println("Random (")
-				}
-				valueNo = 0
-				cpFromOffset = qi
-				s = inWord
-			}
-		case r == ',' && s == moreValuesOrUnknown:
-			if Debug {
-				fmt.Println("More values")
-			}
-		case r == ':' && prevWord == "administrator":
-			// 'administrator command: Init DB' -> 'administrator command: Init DB' (no change)
-			if Debug {
-				fmt.Println("Admin cmd")
+				f.dst = utf8.AppendRune(f.dst, unicode.ToLower(r))
 			}
-			return q[0 : len(q)-1] // original query minus the trailing space we added
-		case r == '#':
-			if Debug {
-				fmt.Println("One-line comment begin")
-			}
-			addSpace = false
-			s = inOLC
-		default:
-			if s != inWord && s != inOp {
-				// If in a word or operator then keep copying the query, else
-				// previous chars were being ignored for some reason, but now
-				// we should start copying again, so set cpFromOffset.  Example:
-				// col=NOW(). 'col' will be set to copy, but then '=' will put
-				// us in inOp state which, if a value follows, will trigger a
-				// copy of "col=", but "NOW()" is not a value so "N" is caught
-				// here and since s=inOp still we do not copy yet (this block is
-				//  not entered).
-				if Debug {
-					fmt.Println("Random character")
-				}
-				valueNo = 0
-				cpFromOffset = qi
-
-				if sqlState == inValues {
-					// Values are comma-separated, so the first random char
-					// marks the end of the VALUE() or IN() list.
-					if Debug {
-						fmt.Println("No more values")
-					}
-					sqlState = unknown
-				}
-			}
-			s = inWord
+			i += size - 1
+			continue
 		}
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		f.dst = append(f.dst, c)
+	}
+}
 
-		/**
-		 * 3. Copy a slice of the query into the fingerprint.
-		 */
+// appendNumbersReplaced appends s with each run of digits replaced by ?,
+// like org235 -> org?.
+func (f *fingerprinter) appendNumbersReplaced(s string) {
+	for i := 0; i < len(s); {
+		j := i
+		for j < len(s) && !isDigitByte(s[j]) {
+			j++
+		}
+		f.appendCase(s[i:j])
+		if j == len(s) {
+			return
+		}
+		f.dst = append(f.dst, '?')
+		for j < len(s) && isDigitByte(s[j]) {
+			j++
+		}
+		i = j
+	}
+}
 
-		if cpToOffset > cpFromOffset {
-			l := cpToOffset - cpFromOffset
-			prevWord = strings.ToLower(q[cpFromOffset:cpToOffset])
-			if Debug {
-				fmt.Printf("copy '%s' (%d:%d, %d:%d) %d\n", prevWord, fi, fi+l, cpFromOffset, cpToOffset, l)
-			}
-			copy(f[fi:fi+l], prevWord)
-			fi += l
-			cpFromOffset = cpToOffset
-			valueKeywords := []string{"in", "value", "values"}
-			if wordIn(prevWord, valueKeywords...) && sqlState != onDupeKeyUpdate {
-				addSpace = false
-				// since we only consider the keywords if it exists at a top-level (except onDupeKeyUpdate),
-				// we only do the conversion below if the "keywords" actually open a value list (i.e., before opening a parenthesis).
-				if r == '(' || (isSpace(r) && qi+1 < len(q) && ((q[qi+1] == '(') || (q[qi+1] == ' '))) {
-					// IN ()     -> in(?+)
-					// VALUES () -> values(?+)
-					s = inValues
-					sqlState = inValues
-				} else {
-					f[fi] = ' '
-					fi++
-					cpFromOffset++
-				}
-			} else if addSpace {
-				if Debug {
-					fmt.Println("Add space")
-				}
-				f[fi] = ' '
-				fi++
-				cpFromOffset++
-				addSpace = false
-			}
+// collapseUnionRepeats replaces repeated identical parts of UNION and
+// UNION ALL in the fingerprint with /*repeat union*/ and /*repeat union all*/.
+func (f *fingerprinter) collapseUnionRepeats() {
+	fp := string(f.dst[f.start:])
+	var parts, seps []string
+	for {
+		i, sep := indexUnion(fp)
+		if i < 0 {
+			parts = append(parts, fp)
+			break
 		}
-		pr = r
+		parts = append(parts, fp[:i])
+		seps = append(seps, fp[i+1:i+len(sep)-1])
+		fp = fp[i+len(sep):]
+	}
+	if len(parts) == 1 {
+		return
 	}
 
-	// Remove trailing spaces.
-	for fi > 0 && isSpace(rune(f[fi-1])) {
-		fi--
+	f.dst = append(f.dst[:f.start], parts[0]...)
+	last := parts[0]
+	repeated := ""
+	for i, part := range parts[1:] {
+		if part == last {
+			if repeated != seps[i] {
+				f.dst = append(f.dst, " /*repeat "...)
+				f.dst = append(f.dst, strings.ToLower(seps[i])...)
+				f.dst = append(f.dst, "*/"...)
+				repeated = seps[i]
+			}
+			continue
+		}
+		f.dst = append(f.dst, ' ')
+		f.dst = append(f.dst, seps[i]...)
+		f.dst = append(f.dst, ' ')
+		f.dst = append(f.dst, part...)
+		last = part
+		repeated = ""
 	}
+}
 
-	// Clean up control characters, and return the fingerprint
-	return strings.Replace(string(f[0:fi]), "\x00", "", -1)
+// indexUnion returns the index of the first " union " or " union all " in the
+// fingerprint, ignoring case, and the separator with its spaces.
+func indexUnion(fp string) (int, string) {
+	for i := 0; i+7 <= len(fp); i++ {
+		if fp[i] != ' ' || !strings.EqualFold(fp[i:i+7], " union ") {
+			continue
+		}
+		if i+11 <= len(fp) && strings.EqualFold(fp[i+7:i+11], "all ") {
+			return i, fp[i : i+11]
+		}
+		return i, fp[i : i+7]
+	}
+	return -1, ""
 }
 
-func isSpace(r rune) bool {
-	return r == 0x20 || r == 0x09 || r == 0x0D || r == 0x0A
+// isPunctuationByte returns true for bytes of tokens that never combine with
+// adjacent tokens.
+func isPunctuationByte(c byte) bool {
+	return c == '(' || c == ')' || c == ',' || c == ';'
 }
 
-func wordIn(q string, words ...string) bool {
-	q = strings.ToLower(q)
-	for _, word := range words {
-		if q == word {
-			return true
-		}
+// isOperand returns true if the token can precede a binary + or -, like 1 in
+// 1 - 2. Otherwise, + and - are unary signs, like - in = -2.
+func isOperand(t Token) bool {
+	switch t.Type {
+	case TokenEOF:
+		return false
+	case TokenKeyword:
+		return t.Is("null") || t.Is("true") || t.Is("false")
+	case TokenOperator:
+		return t.Text == ")"
 	}
-	return false
+	return true
 }
 
 // Id returns the right-most 16 characters of the MD5 checksum of fingerprint.
-// Query IDs are the shortest way to uniquely identify queries.
+// Query IDs are the shortest way to uniquely identify queries. It is the same
+// as HashMD5.ID, and compatible with pt-query-digest. Use IDSpec to make IDs
+// that do not change when Fingerprint changes.
 func Id(fingerprint string) string {
-	id := md5.New()
-	io.WriteString(id, fingerprint)
-	h := fmt.Sprintf("%x", id.Sum(nil))
-	return strings.ToUpper(h[16:32])
+	return HashMD5.ID(fingerprint)
 }
@@ -31,10 +31,12 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package query_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/percona/go-mysql/log"
 	"github.com/percona/go-mysql/query"
 	_ "github.com/percona/go-mysql/test"
 )
@@ -455,6 +457,34 @@ func TestFingerprintTricky(t *testing.T) {
 	)
 }
 
+func TestFingerprintTokens(t *testing.T) {
+	// Fingerprints that changed when Fingerprint started using the lexer. before
+	// is the old fingerprint, which was wrong.
+	testCases := []struct {
+		query    string
+		before   string
+		expected string
+	}{
+		// The operator was removed with the number.
+		{"select a-1", "select a?", "select a-?"},
+		{"select a+1", "select a?", "select a+?"},
+		// Introducers are part of the string.
+		{"select N'abc'", "select n?", "select ?"},
+		{"select _utf8mb4'abc'", "select _utf8mb4?", "select ?"},
+		// '' is a quote in the string, not two strings.
+		{"select 'it''s'", "select ??", "select ?"},
+		// 123abc is an identifier, not a number.
+		{"select * from t where c = 123abc", "select * from t where c = ?", "select * from t where c = 123abc"},
+		// Unchanged: 1 in a.1 is an identifier.
+		{"select a.1 from t", "select a.1 from t", "select a.1 from t"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			assert.Equal(t, tc.expected, query.Fingerprint(tc.query), "before: %s", tc.before)
+		})
+	}
+}
+
 func TestNumbersInFunctions(t *testing.T) {
 	var q string
 
@@ -524,6 +554,15 @@ func TestFingerprintPanicChallenge2(t *testing.T) {
 	)
 }
 
+func TestFingerprintManyComments(t *testing.T) {
+	assert.Equal(t, "select a , b from t", query.Fingerprint(manyComments(3)))
+
+	// Each comment must not be re-lexed for every comment before it, so 8
+	// times the comments are at most 8 times the tokens lexed.
+	n := query.CountLexed(func() { query.Fingerprint(manyComments(1000)) })
+	assert.LessOrEqual(t, query.CountLexed(func() { query.Fingerprint(manyComments(8000)) }), 8*n)
+}
+
 func TestFingerprintDashesInNames(t *testing.T) {
 	q := "select field from `master-db-1`.`table-1` order by id, ?;"
 	assert.Equal(
@@ -715,3 +754,330 @@ func TestFingerprintSystemVariableQueries(t *testing.T) {
 		})
 	}
 }
+
+func TestFingerprinter(t *testing.T) {
+	type testCase struct {
+		name     string
+		fp       query.Fingerprinter
+		query    string
+		expected string
+	}
+	testCases := []testCase{
+		{
+			name:     "default",
+			query:    "SELECT /*+ BKA(t) */ /*!40001 SQL_NO_CACHE */ c FROM db1.t /* x */ WHERE a IN (1, 2) ORDER BY c ASC",
+			expected: "select /*!40001 sql_no_cache */ c from db1.t where a in(?+) order by c",
+		},
+		{
+			name:     "replace numbers in words",
+			fp:       query.Fingerprinter{ReplaceNumbersInWords: true},
+			query:    "SELECT c FROM org235.t",
+			expected: "select c from org?.t",
+		},
+		{
+			name:     "keep ASC",
+			fp:       query.Fingerprinter{KeepASC: true},
+			query:    "SELECT c FROM t ORDER BY c ASC",
+			expected: "select c from t order by c asc",
+		},
+		{
+			name:     "keep comments",
+			fp:       query.Fingerprinter{KeepComments: true},
+			query:    "SELECT c /* Foo=1 */ FROM t -- bar\n WHERE a = 1",
+			expected: "select c /* Foo=1 */ from t -- bar\nwhere a = ?",
+		},
+		{
+			name:     "keep hints",
+			fp:       query.Fingerprinter{KeepHints: true},
+			query:    "SELECT /*+ BKA(t) */ c FROM t",
+			expected: "select /*+ BKA(t) */ c from t",
+		},
+		{
+			name:     "strip version comments",
+			fp:       query.Fingerprinter{StripVersionComments: true},
+			query:    "SELECT /*!40001 SQL_NO_CACHE */ c FROM t",
+			expected: "select c from t",
+		},
+		{
+			name:     "list arity",
+			fp:       query.Fingerprinter{Lists: query.ListArity},
+			query:    "INSERT INTO t VALUES (1, 'a'), (2, 'b') ON DUPLICATE KEY UPDATE c = VALUES(c) + 1",
+			expected: "insert into t values(?, ?), (?, ?) on duplicate key update c = values(c) + ?",
+		},
+		{
+			name:     "list arity in",
+			fp:       query.Fingerprinter{Lists: query.ListArity},
+			query:    "SELECT * FROM t WHERE a IN (1, f(2, 3), 4) AND b IN ()",
+			expected: "select * from t where a in(?, ?, ?) and b in()",
+		},
+		{
+			name:     "list buckets",
+			fp:       query.Fingerprinter{Lists: query.ListBuckets},
+			query:    "SELECT * FROM t WHERE a IN (1, 2) AND b IN (" + strings.Repeat("1, ", 1499) + "1) AND c IN ()",
+			expected: "select * from t where a in(?+/1-10) and b in(?+/1k-10k) and c in()",
+		},
+		{
+			name:     "list buckets values",
+			fp:       query.Fingerprinter{Lists: query.ListBuckets},
+			query:    "INSERT INTO t VALUES (1, 'a')" + strings.Repeat(", (2, 'b')", 10) + " ON DUPLICATE KEY UPDATE c = VALUES(c)",
+			expected: "insert into t values(?+/10-100) on duplicate key update c = values(c)",
+		},
+		{
+			name:     "list buckets values row",
+			fp:       query.Fingerprinter{Lists: query.ListBuckets},
+			query:    "INSERT INTO t VALUES ROW(1, 2, 3)",
+			expected: "insert into t values row(?+/1-10)",
+		},
+		{
+			name:     "list buckets fingerprint",
+			fp:       query.Fingerprinter{Lists: query.ListBuckets},
+			query:    "select * from t where a in(?+/100k-1m) and b in(?+/10-100)",
+			expected: "select * from t where a in(?+/100k-1m) and b in(?+/10-100)",
+		},
+		{
+			name:     "keep case",
+			fp:       query.Fingerprinter{KeepCase: true},
+			query:    "SELECT Name FROM `Users` WHERE id = 1",
+			expected: "SELECT Name FROM `Users` WHERE id = ?",
+		},
+		{
+			name:     "collapse union repeats",
+			fp:       query.Fingerprinter{CollapseUnionRepeats: true},
+			query:    "SELECT a FROM t WHERE id = 1 UNION ALL SELECT a FROM t WHERE id = 2 UNION ALL SELECT a FROM t WHERE id = 3",
+			expected: "select a from t where id = ? /*repeat union all*/",
+		},
+		{
+			name:     "collapse union repeats with different parts",
+			fp:       query.Fingerprinter{CollapseUnionRepeats: true},
+			query:    "select 1 union select 2 union select 3 union select a from t union select 4",
+			expected: "select ? /*repeat union*/ union select a from t union select ?",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.fp.Fingerprint(tc.query))
+		})
+	}
+}
+
+func TestFingerprintDeprecatedGlobals(t *testing.T) {
+	defer func() { query.ReplaceNumbersInWords = false }()
+	query.ReplaceNumbersInWords = true
+	assert.Equal(t, "select c from org?.t", query.Fingerprint("SELECT c FROM org235.t"))
+}
+
+func TestFingerprintMySQL8(t *testing.T) {
+	type testCase struct {
+		name     string
+		fp       query.Fingerprinter
+		query    string
+		expected string
+	}
+	testCases := []testCase{
+		{
+			name:     "CTE",
+			query:    "WITH cte (a) AS (SELECT a FROM t WHERE b = 1), c2 AS (SELECT 2) SELECT * FROM cte JOIN c2",
+			expected: "with cte (a) as (select a from t where b = ?), c2 as (select ?) select * from cte join c2",
+		},
+		{
+			name:     "recursive CTE",
+			query:    "WITH RECURSIVE seq (n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM seq WHERE n < 10) SELECT n FROM seq",
+			expected: "with recursive seq (n) as (select ? union all select n + ? from seq where n < ?) select n from seq",
+		},
+		{
+			name:     "CTE in IN list",
+			query:    "SELECT * FROM t WHERE a IN (WITH c AS (SELECT 1) SELECT * FROM c)",
+			expected: "select * from t where a in(with c as (select ?) select * from c)",
+		},
+		{
+			name:     "window functions",
+			query:    "SELECT a, ROW_NUMBER() OVER (PARTITION BY b ORDER BY c ASC ROWS BETWEEN 1 PRECEDING AND CURRENT ROW) FROM t WINDOW w AS (ORDER BY d ASC)",
+			expected: "select a, row_number() over (partition by b order by c rows between ? preceding and current row) from t window w as (order by d)",
+		},
+		{
+			name:     "JSON operators",
+			query:    "SELECT doc->'$.name', doc->>'$.tags[0]' FROM t WHERE doc->>\"$.id\" = 'x' AND JSON_EXTRACT(doc, '$.a') = 1",
+			expected: "select doc->'$.name', doc->>'$.tags[0]' from t where doc->>\"$.id\" = ? and json_extract(doc, ?) = ?",
+		},
+		{
+			name:     "optimizer hints",
+			query:    "SELECT /*+ MAX_EXECUTION_TIME(1000) BKA(t1) */ * FROM t1",
+			expected: "select * from t1",
+		},
+		{
+			name:     "keep optimizer hints",
+			fp:       query.Fingerprinter{KeepHints: true},
+			query:    "SELECT /*+ MAX_EXECUTION_TIME(1000) BKA(t1) */ * FROM t1",
+			expected: "select /*+ MAX_EXECUTION_TIME(1000) BKA(t1) */ * from t1",
+		},
+		{
+			name:     "VALUES ROW",
+			query:    "INSERT INTO t VALUES ROW(1, 'a'), ROW(2, 'b')",
+			expected: "insert into t values row(?+)",
+		},
+		{
+			name:     "VALUES ROW with arity",
+			fp:       query.Fingerprinter{Lists: query.ListArity},
+			query:    "INSERT INTO t VALUES ROW(1, 'a'), ROW(2, 'b')",
+			expected: "insert into t values row(?, ?), row(?, ?)",
+		},
+		{
+			name:     "VALUES statement",
+			query:    "VALUES ROW(1, 2), ROW(3, 4) ORDER BY column_0 ASC",
+			expected: "values row(?+) order by column_0",
+		},
+		{
+			name:     "TABLE statement",
+			query:    "TABLE t ORDER BY a LIMIT 5",
+			expected: "table t order by a limit ?",
+		},
+		{
+			name:     "TABLE in IN list",
+			query:    "SELECT * FROM t WHERE a IN (TABLE u)",
+			expected: "select * from t where a in(table u)",
+		},
+		{
+			name:     "LATERAL",
+			query:    "SELECT * FROM t1, LATERAL (SELECT * FROM t2 WHERE t2.a = t1.a LIMIT 1) AS x",
+			expected: "select * from t1, lateral (select * from t2 where t2.a = t1.a limit ?) as x",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.fp.Fingerprint(tc.query))
+		})
+	}
+}
+
+func TestFingerprintCommands(t *testing.T) {
+	type testCase struct {
+		name     string
+		fp       query.Fingerprinter
+		query    string
+		expected string
+	}
+	testCases := []testCase{
+		{
+			name:     "PREPARE",
+			query:    "PREPARE s FROM 'SELECT * FROM t WHERE a = ? AND b = ''x'''",
+			expected: "prepare s from select * from t where a = ? and b = ?",
+		},
+		{
+			// The SQL is fingerprinted like a query.
+			name:     "PREPARE CALL",
+			query:    "PREPARE s FROM 'CALL p(1)'",
+			expected: "prepare s from call p",
+		},
+		{
+			name:     "EXECUTE IMMEDIATE USE",
+			query:    "EXECUTE IMMEDIATE 'USE db'",
+			expected: "execute immediate use ?",
+		},
+		{
+			name:     "PREPARE from variable",
+			query:    "PREPARE s FROM @sql",
+			expected: "prepare s from @sql",
+		},
+		{
+			name:     "PREPARE in V1",
+			fp:       query.Fingerprinter{Version: query.FingerprintV1},
+			query:    "PREPARE s FROM 'SELECT * FROM t WHERE a = ?'",
+			expected: "prepare s from ?",
+		},
+		{
+			name:     "EXECUTE",
+			query:    "EXECUTE s USING @a, @b",
+			expected: "execute s",
+		},
+		{
+			name:     "EXECUTE IMMEDIATE",
+			query:    "EXECUTE IMMEDIATE 'SELECT a FROM t WHERE b = 5'",
+			expected: "execute immediate select a from t where b = ?",
+		},
+		{
+			name:     "DEALLOCATE PREPARE",
+			query:    "DEALLOCATE PREPARE s",
+			expected: "deallocate prepare s",
+		},
+		{
+			name:     "DROP PREPARE",
+			query:    "DROP PREPARE s",
+			expected: "deallocate prepare s",
+		},
+		{
+			name:     "HANDLER",
+			query:    "HANDLER t READ idx = (1, 'a') WHERE b > 5 LIMIT 3",
+			expected: "handler t read idx = (?, ?) where b > ? limit ?",
+		},
+		{
+			name:     "LOAD DATA",
+			query:    "LOAD DATA LOCAL INFILE '/data/t.csv' INTO TABLE t FIELDS TERMINATED BY ','",
+			expected: "load data local infile ? into table t fields terminated by ?",
+		},
+		{
+			name:     "INTO OUTFILE",
+			query:    "SELECT a FROM t INTO OUTFILE '/tmp/t.csv'",
+			expected: "select a from t into outfile ?",
+		},
+		{
+			name:     "INTO DUMPFILE",
+			query:    "SELECT a FROM t LIMIT 1 INTO DUMPFILE '/tmp/t.bin'",
+			expected: "select a from t limit ? into dumpfile ?",
+		},
+		{
+			name:     "SHOW LIKE",
+			query:    "SHOW GLOBAL STATUS LIKE 'Threads%'",
+			expected: "show global status",
+		},
+		{
+			name:     "SHOW WHERE",
+			query:    "SHOW SESSION VARIABLES WHERE Variable_name IN ('a', 'b')",
+			expected: "show variables",
+		},
+		{
+			name:     "SHOW FROM LIKE",
+			query:    "SHOW FULL TABLES FROM db1 LIKE 'a%';",
+			expected: "show full tables from db1",
+		},
+		{
+			name:     "SHOW LIKE in V1",
+			fp:       query.Fingerprinter{Version: query.FingerprintV1},
+			query:    "SHOW GLOBAL STATUS LIKE 'Threads%'",
+			expected: "show global status like ?",
+		},
+		{
+			name:     "administrator command",
+			query:    "administrator command: Quit",
+			expected: "administrator command: Quit",
+		},
+		{
+			name:     "administrator command in comment",
+			query:    "# administrator command: binlog  dump;",
+			expected: "administrator command: Binlog Dump",
+		},
+		{
+			name:     "unknown administrator command",
+			query:    "administrator command: Foo Bar;",
+			expected: "administrator command: foo bar",
+		},
+		{
+			name:     "administrator command in V1",
+			fp:       query.Fingerprinter{Version: query.FingerprintV1},
+			query:    "administrator command: binlog  dump;",
+			expected: "administrator command: binlog  dump;",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.fp.Fingerprint(tc.query))
+		})
+	}
+}
+
+func TestFingerprintEvent(t *testing.T) {
+	e := &log.Event{Query: "Binlog Dump", Admin: true}
+	assert.Equal(t, "administrator command: Binlog Dump", query.FingerprintEvent(e.Query, e.Admin))
+
+	e = &log.Event{Query: "SELECT a FROM t WHERE b = 1"}
+	assert.Equal(t, "select a from t where b = ?", query.FingerprintEvent(e.Query, e.Admin))
+}
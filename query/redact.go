@@ -0,0 +1,275 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// RedactOptions configures Redact.
+type RedactOptions struct {
+	// Key enables keyed hashes: if set, each literal is replaced with a typed
+	// placeholder and the HMAC-SHA256 of its value, so equal values map to
+	// equal tokens, like ?str:9f86d081884c7d65. Keep the key secret: without
+	// it, hashes of short or common values cannot be guessed.
+	Key []byte
+
+	// Identifiers enables masking identifiers (database, table, column, and
+	// alias names) as ?ident, or ?ident:<hash> if Key is set. Quoted
+	// identifiers stay quoted. Keywords and the names of built-in functions
+	// are not masked, but other names followed by "(", like the table in
+	// INSERT INTO t (a) or a stored function, are. In optimizer hints, hint
+	// names are kept and the names of tables, indexes, and query blocks are
+	// masked.
+	Identifiers bool
+
+	// KeepComments keeps comments as is. By default, comment text is replaced
+	// with ? because comments can contain anything. Optimizer hints are kept,
+	// except identifiers if Identifiers is set, and MySQL-specific code in
+	// version comments is redacted like the query.
+	KeepComments bool
+}
+
+// Redact returns q with its literals masked. Unlike Fingerprint, Redact keeps
+// the structure of q: case, whitespace, comment positions, and the number of
+// values in value lists do not change, only the literals. Literals are replaced
+// with typed placeholders: ?str for strings, ?num for numbers, ?hex for hex
+// values, and ?bit for bit values. NULL, TRUE, FALSE, and ? are kept. The sign
+// of a negative number is kept because it is a separate operator token.
+func Redact(q string, opt RedactOptions) string {
+	var b strings.Builder
+	b.Grow(len(q))
+	l := Lexer{q: q}
+	for {
+		t := l.Next()
+		switch t.Type {
+		case TokenEOF:
+			return b.String()
+		case TokenString:
+			b.WriteString(opt.mask("?str", unescape(t.Text)))
+		case TokenNumber:
+			b.WriteString(opt.mask("?num", t.Text))
+		case TokenHex:
+			b.WriteString(opt.mask("?hex", hexValue(t.Text)))
+		case TokenBit:
+			b.WriteString(opt.mask("?bit", bitValue(t.Text)))
+		case TokenIdent:
+			next := l
+			if !opt.Identifiers || unmaskedWords[strings.ToLower(t.Text)] ||
+				functionNames[strings.ToLower(t.Text)] && next.NextSignificant().Text == "(" {
+				// SQL_NO_CACHE, DATE, etc., or function call like COUNT(*)
+				b.WriteString(t.Text)
+			} else {
+				b.WriteString(opt.mask("?ident", t.Text))
+			}
+		case TokenQuotedIdent:
+			if opt.Identifiers {
+				b.WriteString("`" + opt.mask("?ident", unquoteIdent(t.Text)) + "`")
+			} else {
+				b.WriteString(t.Text)
+			}
+		case TokenHint:
+			if opt.Identifiers {
+				b.WriteString(opt.redactHint(t.Text))
+			} else {
+				b.WriteString(t.Text)
+			}
+		case TokenComment:
+			if opt.KeepComments {
+				b.WriteString(t.Text)
+			} else {
+				b.WriteString(redactComment(t.Text))
+			}
+		case TokenVersionComment:
+			// /*!50001 code */
+			start := 3
+			for start < len(t.Text) && (isDigitByte(t.Text[start]) || t.Text[start] == '!') {
+				start++
+			}
+			end := len(t.Text)
+			if strings.HasSuffix(t.Text, "*/") && end-2 >= start {
+				end -= 2
+			}
+			b.WriteString(t.Text[:start])
+			b.WriteString(Redact(t.Text[start:end], opt))
+			b.WriteString(t.Text[end:])
+		default:
+			b.WriteString(t.Text)
+		}
+	}
+}
+
+// unmaskedWords are words that are not reserved but are not identifiers in
+// practice: query modifiers, CAST types, and INTERVAL units.
+var unmaskedWords = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`
+		sql_no_cache sql_cache sql_buffer_result share mode nowait skip locked
+		date time datetime timestamp signed json
+		microsecond second minute hour day week month quarter year
+	`) {
+		unmaskedWords[w] = true
+	}
+}
+
+// functionNames are the lowercase names of built-in functions that are not
+// keywords, which are not masked when followed by "(".
+var functionNames = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`
+		abs acos adddate addtime aes_decrypt aes_encrypt any_value ascii asin atan atan2 avg
+		benchmark bin bin_to_uuid bit_and bit_count bit_length bit_or bit_xor
+		cast ceil ceiling char_length character_length charset coalesce coercibility collation
+		compress concat concat_ws connection_id conv convert_tz cos cot count crc32 cume_dist
+		curdate current_role curtime
+		date_add date_format date_sub datediff day dayname dayofmonth dayofweek dayofyear
+		degrees dense_rank elt exp export_set extract extractvalue
+		field find_in_set first_value floor format found_rows from_base64 from_days
+		from_unixtime get_format get_lock greatest group_concat
+		hex hour icu_version ifnull inet_aton inet_ntoa inet6_aton inet6_ntoa instr
+		is_free_lock is_ipv4 is_ipv6 is_used_lock is_uuid isnull
+		json_array json_arrayagg json_array_append json_array_insert json_contains
+		json_contains_path json_depth json_extract json_insert json_keys json_length
+		json_merge json_merge_patch json_merge_preserve json_object json_objectagg
+		json_overlaps json_pretty json_quote json_remove json_replace json_schema_valid
+		json_search json_set json_storage_size json_table json_type json_unquote json_valid
+		json_value
+		lag last_day last_insert_id last_value lcase lead least length ln load_file
+		locate log log10 log2 lower lpad ltrim
+		make_set makedate maketime master_pos_wait max md5 member microsecond mid min minute
+		month monthname name_const now nth_value ntile nullif
+		oct octet_length ord percent_rank period_add period_diff pi position pow power
+		quarter quote radians rand random_bytes rank regexp_instr regexp_like regexp_replace
+		regexp_substr release_all_locks release_lock reverse round row_count row_number
+		rpad rtrim
+		sec_to_time second session_user sha sha1 sha2 sign sin sleep soundex space sqrt
+		st_astext st_contains st_distance st_geomfromtext st_intersects st_within std stddev
+		stddev_pop stddev_samp str_to_date strcmp subdate substr substring substring_index
+		subtime sum sysdate system_user
+		tan time_format time_to_sec timediff timestampadd timestampdiff to_base64 to_days
+		to_seconds trim truncate ucase uncompress uncompressed_length unhex unix_timestamp
+		updatexml upper uuid uuid_short uuid_to_bin validate_password_strength var_pop
+		var_samp variance version wait_for_executed_gtid_set week weekday weekofyear
+		weight_string yearweek
+	`) {
+		functionNames[w] = true
+	}
+}
+
+// redactHint returns the optimizer hint with identifiers masked, except hint
+// names, like INDEX in /*+ INDEX(t idx) */.
+func (opt RedactOptions) redactHint(hint string) string {
+	end := len(hint)
+	if strings.HasSuffix(hint, "*/") && end-2 >= 3 {
+		end -= 2
+	}
+	var b strings.Builder
+	b.WriteString(hint[:3])
+	l := Lexer{q: hint[3:end]}
+	for t := l.Next(); t.Type != TokenEOF; t = l.Next() {
+		next := l
+		switch {
+		case t.Type == TokenIdent && next.NextSignificant().Text == "(":
+			b.WriteString(t.Text)
+		case t.Type == TokenIdent:
+			b.WriteString(opt.mask("?ident", t.Text))
+		case t.Type == TokenQuotedIdent:
+			b.WriteString("`" + opt.mask("?ident", unquoteIdent(t.Text)) + "`")
+		case t.Type == TokenVariable && strings.HasPrefix(t.Text, "@"):
+			// Query block names, like t@qb1 or @qb1.
+			b.WriteString("@" + opt.mask("?ident", strings.TrimPrefix(t.Text, "@")))
+		default:
+			b.WriteString(t.Text)
+		}
+	}
+	b.WriteString(hint[end:])
+	return b.String()
+}
+
+// mask returns the placeholder, and the keyed hash of value if Key is set.
+func (opt RedactOptions) mask(placeholder, value string) string {
+	if len(opt.Key) == 0 {
+		return placeholder
+	}
+	h := hmac.New(sha256.New, opt.Key)
+	h.Write([]byte(placeholder))
+	h.Write([]byte{0})
+	h.Write([]byte(value))
+	return placeholder + ":" + hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// redactComment returns the comment with its text replaced by ?.
+func redactComment(c string) string {
+	if strings.HasPrefix(c, "/*") {
+		return "/* ? */"
+	}
+	redacted := "# ?"
+	if strings.HasPrefix(c, "--") {
+		redacted = "-- ?"
+	}
+	if strings.HasSuffix(c, "\n") {
+		return redacted + "\n"
+	}
+	return redacted
+}
+
+// stringValue returns the quoted value of a string token without its prefix
+// and quotes, so 'a', "a", and _utf8mb4'a' have the same value.
+func stringValue(s string) string {
+	if i := strings.IndexAny(s, `'"`); i >= 0 {
+		s = s[i:]
+	}
+	if len(s) >= 2 && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s[1:]
+}
+
+// hexValue returns the lowercase digits of 0xFF or x'FF'.
+func hexValue(s string) string {
+	if len(s) > 2 && s[0] == '0' {
+		return strings.ToLower(s[2:])
+	}
+	return strings.ToLower(stringValue(s))
+}
+
+// bitValue returns the digits of 0b01 or b'01'.
+func bitValue(s string) string {
+	if len(s) > 2 && s[0] == '0' {
+		return s[2:]
+	}
+	return stringValue(s)
+}
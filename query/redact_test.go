@@ -0,0 +1,126 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/percona/go-mysql/query"
+)
+
+func TestRedact(t *testing.T) {
+	type testCase struct {
+		name     string
+		query    string
+		opt      query.RedactOptions
+		expected string
+	}
+	testCases := []testCase{
+		{
+			name:     "literals",
+			query:    "SELECT name FROM Users WHERE email = 'bob@example.com' AND id IN (1, 2, -3) AND h = 0xFF AND b = b'01' AND x = N'y' AND n IS NULL AND p = ?",
+			expected: "SELECT name FROM Users WHERE email = ?str AND id IN (?num, ?num, -?num) AND h = ?hex AND b = ?bit AND x = ?str AND n IS NULL AND p = ?",
+		},
+		{
+			name:     "whitespace and comments",
+			query:    "select a\n\tfrom t /* user=bob */ where a = 'x' -- bob\n and b = 1 # bob",
+			expected: "select a\n\tfrom t /* ? */ where a = ?str -- ?\n and b = ?num # ?",
+		},
+		{
+			name:     "keep comments",
+			query:    "select a from t /* user=bob */ where a = 'x' -- bob\n",
+			opt:      query.RedactOptions{KeepComments: true},
+			expected: "select a from t /* user=bob */ where a = ?str -- bob\n",
+		},
+		{
+			name:     "hints and version comments",
+			query:    "SELECT /*+ MAX_EXECUTION_TIME(1000) */ /*!40001 SQL_NO_CACHE */ * FROM t WHERE a = 'x' /*!50001 AND b = 2 */",
+			expected: "SELECT /*+ MAX_EXECUTION_TIME(1000) */ /*!40001 SQL_NO_CACHE */ * FROM t WHERE a = ?str /*!50001 AND b = ?num */",
+		},
+		{
+			name:     "identifiers",
+			query:    "SELECT SQL_NO_CACHE name, COUNT(*) FROM `Users` WHERE CAST(d AS DATE) = '2024-01-01'",
+			opt:      query.RedactOptions{Identifiers: true},
+			expected: "SELECT SQL_NO_CACHE ?ident, COUNT(*) FROM `?ident` WHERE CAST(?ident AS DATE) = ?str",
+		},
+		{
+			name:     "names followed by parenthesis",
+			query:    "INSERT INTO customers (name) VALUES (UPPER('bob')); CREATE TABLE secret_t (id INT); SELECT my_func(a)",
+			opt:      query.RedactOptions{Identifiers: true},
+			expected: "INSERT INTO ?ident (?ident) VALUES (UPPER(?str)); CREATE TABLE ?ident (?ident INT); SELECT ?ident(?ident)",
+		},
+		{
+			name:     "identifiers in hints",
+			query:    "SELECT /*+ INDEX(patients idx_ssn) QB_NAME(qb1) */ * FROM patients",
+			opt:      query.RedactOptions{Identifiers: true},
+			expected: "SELECT /*+ INDEX(?ident ?ident) QB_NAME(?ident) */ * FROM ?ident",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, query.Redact(tc.query, tc.opt))
+		})
+	}
+}
+
+func TestRedactKey(t *testing.T) {
+	opt := query.RedactOptions{Key: []byte("secret"), Identifiers: true}
+	r := query.Redact(`select a from t where a = 'bob' or a = "bob" or a = 'alice' or b = 1 or c = 1`, opt)
+	f := strings.Fields(r)
+	assert.Len(t, f, 24)
+
+	// Equal values map to equal tokens, regardless of quoting.
+	assert.Regexp(t, `^\?str:[0-9a-f]{16}$`, f[7])
+	assert.Equal(t, f[7], f[11])
+	assert.NotEqual(t, f[7], f[15])
+	assert.Equal(t, f[19], f[23])
+
+	// Equal values map to equal tokens, regardless of escaping.
+	assert.Equal(t, query.Redact(`select 'it''s'`, opt), query.Redact(`select 'it\'s'`, opt))
+
+	// Equal literals of different types map to different tokens.
+	assert.Regexp(t, `^\?num:[0-9a-f]{16}$`, f[19])
+	str := strings.Split(query.Redact("select '1'", opt), ":")[1]
+	num := strings.Split(query.Redact("select 1", opt), ":")[1]
+	assert.NotEqual(t, str, num)
+
+	// Identifiers are hashed, too: a in the select list and the where clause.
+	assert.Regexp(t, `^\?ident:[0-9a-f]{16}$`, f[1])
+	assert.Equal(t, f[1], f[5])
+
+	// Hashes depend on the key.
+	opt2 := query.RedactOptions{Key: []byte("other"), Identifiers: true}
+	assert.NotEqual(t, r, query.Redact(`select a from t where a = 'bob' or a = "bob" or a = 'alice' or b = 1 or c = 1`, opt2))
+	assert.Equal(t, r, query.Redact(`select a from t where a = 'bob' or a = "bob" or a = 'alice' or b = 1 or c = 1`, opt))
+}
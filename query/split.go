@@ -0,0 +1,187 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query
+
+import (
+	"strings"
+)
+
+// A StatementID is one statement of a multi-statement query, with its
+// fingerprint and ID.
+type StatementID struct {
+	Query       string // statement without delimiter and surrounding space
+	Start       int    // byte offset of statement in query
+	End         int    // byte offset after statement in query
+	Fingerprint string
+	ID          string
+}
+
+// Split returns the statements of q, split on top-level semicolons like the
+// mysql client. Semicolons in quotes, comments, and compound statements
+// (BEGIN ... END) do not split statements. DELIMITER commands change the
+// delimiter, like in dumps of stored routines, and are not returned. Empty
+// statements and statements with only comments are not returned.
+func Split(q string) []string {
+	spans := split(q)
+	statements := make([]string, len(spans))
+	for i, s := range spans {
+		statements[i] = q[s[0]:s[1]]
+	}
+	return statements
+}
+
+// FingerprintStatements returns the statements of q, see Split, with their
+// fingerprints and IDs, see Fingerprint and Id. Multi-statement queries are
+// logged as one event, so their Query_time cannot be attributed to each
+// statement; this lets callers choose the statement class, like the first
+// or most expensive statement, instead of one class for every combination.
+func FingerprintStatements(q string) []StatementID {
+	fp := Fingerprinter{ReplaceNumbersInWords: ReplaceNumbersInWords}
+	return fp.statements(q, Id)
+}
+
+// FingerprintStatements returns the statements of q, see Split, with their
+// fingerprints and IDs made by the spec.
+func (s IDSpec) FingerprintStatements(q string) []StatementID {
+	fp := Fingerprinter{Version: s.Version}
+	return fp.statements(q, s.ID)
+}
+
+func (fp Fingerprinter) statements(q string, id func(string) string) []StatementID {
+	spans := split(q)
+	statements := make([]StatementID, len(spans))
+	for i, s := range spans {
+		f := fp.Fingerprint(q[s[0]:s[1]])
+		statements[i] = StatementID{
+			Query:       q[s[0]:s[1]],
+			Start:       s[0],
+			End:         s[1],
+			Fingerprint: f,
+			ID:          id(f),
+		}
+	}
+	return statements
+}
+
+// split returns the start and end offsets of the statements of q.
+func split(q string) [][2]int {
+	var spans [][2]int
+	delimiter := ";"
+	l := Lexer{q: q}
+	start := 0       // start of statement
+	first := Token{} // first significant token of statement
+	var prev Token   // previous significant token
+	depth := 0       // BEGIN ... END and CASE ... END depth
+
+	add := func(end int) {
+		if first.Type != TokenEOF {
+			s := strings.TrimRight(q[start:end], " \t\r\n")
+			trimmed := strings.TrimLeft(s, " \t\r\n")
+			spans = append(spans, [2]int{start + len(s) - len(trimmed), start + len(s)})
+		}
+	}
+
+	for {
+		t := l.Next()
+		if t.Type == TokenEOF {
+			add(len(q))
+			return spans
+		}
+		if !t.Significant() {
+			continue
+		}
+
+		// DELIMITER // is a command of the mysql client, not a statement.
+		if first.Type == TokenEOF && t.Is("delimiter") {
+			line := q[t.End:]
+			if i := strings.IndexByte(line, '\n'); i >= 0 {
+				line = line[:i]
+			}
+			if d := strings.TrimSpace(line); d != "" {
+				delimiter = d
+			}
+			l.pos = t.End + len(line)
+			start = l.pos
+			continue
+		}
+
+		// Find the delimiter in the token, like $$ in END$$. The part before
+		// it is handled as a token of its own, and the delimiter is found in
+		// the next token.
+		if t.Type != TokenString && t.Type != TokenQuotedIdent && t.Type != TokenVersionComment {
+			if i := indexDelimiter(q, t, delimiter); i > 0 {
+				t.Text, t.End = t.Text[:i], t.Start+i
+				l.pos = t.End
+			} else if i == 0 && depth == 0 {
+				add(t.Start)
+				start = t.Start + len(delimiter)
+				l.pos = start
+				first, prev = Token{}, Token{}
+				continue
+			}
+		}
+
+		switch {
+		case t.Is("begin"):
+			// BEGIN; and BEGIN WORK start transactions, not compound statements.
+			next := l
+			n := next.NextSignificant()
+			transaction := first.Type == TokenEOF &&
+				(n.Type == TokenEOF || n.Is("work") || strings.HasPrefix(q[n.Start:], delimiter))
+			if !transaction && !prev.Is("xa") {
+				depth++
+			}
+		case t.Is("case") && depth > 0 && !prev.Is("end"):
+			depth++
+		case t.Is("end") && depth > 0:
+			// END IF, END LOOP, etc. end blocks that are not counted.
+			next := l
+			if n := next.NextSignificant(); !n.Is("if") && !n.Is("loop") && !n.Is("while") && !n.Is("repeat") {
+				depth--
+			}
+		}
+		if first.Type == TokenEOF {
+			first = t
+		}
+		prev = t
+	}
+}
+
+// indexDelimiter returns the index of the delimiter in the token, or -1. The
+// delimiter can span several tokens, like // which is two / operators.
+func indexDelimiter(q string, t Token, delimiter string) int {
+	for i := t.Start; i < t.End; i++ {
+		if strings.HasPrefix(q[i:], delimiter) {
+			return i - t.Start
+		}
+	}
+	return -1
+}
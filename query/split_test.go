@@ -0,0 +1,122 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/percona/go-mysql/query"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		q          string
+		statements []string
+	}{
+		{
+			q:          "SELECT 1",
+			statements: []string{"SELECT 1"},
+		},
+		{
+			q:          "SELECT 1; SELECT 2;",
+			statements: []string{"SELECT 1", "SELECT 2"},
+		},
+		{
+			q:          "SELECT 'a;b', \"c;d\", `e;f` FROM t /* ; */ -- ;\n; ;  select 2  ",
+			statements: []string{"SELECT 'a;b', \"c;d\", `e;f` FROM t /* ; */ -- ;", "select 2"},
+		},
+		{
+			q:          ";; /* only a comment */ ;",
+			statements: []string{},
+		},
+		{
+			q:          "BEGIN; UPDATE t SET a = 1; COMMIT",
+			statements: []string{"BEGIN", "UPDATE t SET a = 1", "COMMIT"},
+		},
+		{
+			q:          "BEGIN WORK; COMMIT; XA BEGIN 'x'; SELECT 1",
+			statements: []string{"BEGIN WORK", "COMMIT", "XA BEGIN 'x'", "SELECT 1"},
+		},
+		{
+			q: "CREATE PROCEDURE p() BEGIN DECLARE x INT; IF x THEN SELECT CASE WHEN 1 THEN 2 END; END IF; " +
+				"CASE x WHEN 1 THEN SELECT 1; END CASE; lbl: LOOP LEAVE lbl; END LOOP; END; SELECT 3",
+			statements: []string{
+				"CREATE PROCEDURE p() BEGIN DECLARE x INT; IF x THEN SELECT CASE WHEN 1 THEN 2 END; END IF; " +
+					"CASE x WHEN 1 THEN SELECT 1; END CASE; lbl: LOOP LEAVE lbl; END LOOP; END",
+				"SELECT 3",
+			},
+		},
+		{
+			q: "DELIMITER //\nCREATE PROCEDURE p() BEGIN SELECT 1; END//\nDELIMITER ;\nCALL p();",
+			statements: []string{
+				"CREATE PROCEDURE p() BEGIN SELECT 1; END",
+				"CALL p()",
+			},
+		},
+		{
+			q: "DELIMITER $$\nCREATE TRIGGER tr BEFORE INSERT ON t FOR EACH ROW BEGIN SET NEW.a = 1; END$$\n" +
+				"SELECT 1$$\nDELIMITER ;\nSELECT 2",
+			statements: []string{
+				"CREATE TRIGGER tr BEFORE INSERT ON t FOR EACH ROW BEGIN SET NEW.a = 1; END",
+				"SELECT 1",
+				"SELECT 2",
+			},
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.statements, query.Split(test.q), test.q)
+	}
+}
+
+func TestFingerprintStatements(t *testing.T) {
+	q := "SELECT * FROM t WHERE id = 1;\n  UPDATE t SET a = 'x' WHERE id IN (1, 2); "
+	statements := query.FingerprintStatements(q)
+	require.Len(t, statements, 2)
+
+	for _, s := range statements {
+		assert.Equal(t, s.Query, q[s.Start:s.End])
+		assert.Equal(t, query.Fingerprint(s.Query), s.Fingerprint)
+		assert.Equal(t, query.Id(s.Fingerprint), s.ID)
+	}
+	assert.Equal(t, "select * from t where id = ?", statements[0].Fingerprint)
+	assert.Equal(t, "update t set a = ? where id in(?+)", statements[1].Fingerprint)
+	assert.Equal(t, 32, statements[1].Start)
+
+	spec := query.IDSpec{Version: query.FingerprintV1, Hash: query.HashXXH64}
+	statements = spec.FingerprintStatements(q)
+	require.Len(t, statements, 2)
+	for _, s := range statements {
+		assert.Equal(t, spec.ID(spec.Fingerprint(s.Query)), s.ID)
+	}
+}
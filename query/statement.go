@@ -0,0 +1,676 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query
+
+import (
+	"strings"
+)
+
+// A StatementType is the kind of a statement, like SELECT or DDL.
+type StatementType string
+
+const (
+	StatementSelect      StatementType = "SELECT"      // SELECT, TABLE, and VALUES
+	StatementInsert      StatementType = "INSERT"      // INSERT
+	StatementReplace     StatementType = "REPLACE"     // REPLACE
+	StatementUpdate      StatementType = "UPDATE"      // UPDATE
+	StatementDelete      StatementType = "DELETE"      // DELETE
+	StatementLoad        StatementType = "LOAD"        // LOAD DATA and LOAD XML
+	StatementDDL         StatementType = "DDL"         // CREATE, ALTER, DROP, RENAME, and TRUNCATE
+	StatementSet         StatementType = "SET"         // SET
+	StatementCall        StatementType = "CALL"        // CALL
+	StatementShow        StatementType = "SHOW"        // SHOW
+	StatementUse         StatementType = "USE"         // USE
+	StatementExplain     StatementType = "EXPLAIN"     // EXPLAIN, DESCRIBE, and DESC
+	StatementTransaction StatementType = "TRANSACTION" // BEGIN, COMMIT, ROLLBACK, etc.
+	StatementAdmin       StatementType = "ADMIN"       // administrator commands, FLUSH, KILL, GRANT, etc.
+	StatementOther       StatementType = "OTHER"       // everything else
+)
+
+// A Table is a table referenced by a query. Db is empty unless the table is
+// qualified like db.t. Names are unquoted but otherwise as written.
+type Table struct {
+	Db   string `json:",omitempty"`
+	Name string
+}
+
+// String returns db.name, or name if Db is empty.
+func (t Table) String() string {
+	if t.Db == "" {
+		return t.Name
+	}
+	return t.Db + "." + t.Name
+}
+
+// A Statement describes what a query does: its type and the tables it reads
+// and writes. A table can be both read and written, like t in
+// INSERT INTO t SELECT * FROM t. Tables are listed once, in the order they
+// first appear in the query. Common table expressions (WITH) are not tables.
+type Statement struct {
+	Type  StatementType
+	Read  []Table `json:",omitempty"`
+	Write []Table `json:",omitempty"`
+}
+
+// Tables returns the tables read and written, without duplicates.
+func (s Statement) Tables() []Table {
+	tables := append([]Table{}, s.Read...)
+	for _, t := range s.Write {
+		tables = addTable(tables, t)
+	}
+	return tables
+}
+
+// Describe returns the statement type and tables of q. Like Fingerprint, it
+// does not parse SQL, so it never fails, but it can miss tables in unusual
+// queries. Only the first statement of multi-statement queries is described.
+//
+// Tables in FROM, JOIN, subqueries, and common table expressions are read.
+// INSERT, REPLACE, and LOAD write the table they insert into. UPDATE writes the
+// tables assigned in its SET clause, and reads other joined tables; if a column
+// is not qualified and several tables are joined, all of them are written.
+// Multi-table DELETE writes the tables it deletes from. DDL writes the tables
+// and views it creates, alters, drops, renames, or truncates. EXPLAIN only
+// reads tables.
+func Describe(q string) Statement {
+	trimmed := strings.TrimLeft(q, " \t\r\n")
+	if len(trimmed) >= 22 && strings.EqualFold(trimmed[:22], "administrator command:") {
+		return Statement{Type: StatementAdmin}
+	}
+
+	d := newDescriber(q)
+	s := Statement{Type: d.statement(0)}
+	for _, r := range d.refs {
+		if r.table.Db == "" && d.ctes[strings.ToLower(r.table.Name)] {
+			continue
+		}
+		if r.write {
+			s.Write = addTable(s.Write, r.table)
+		} else {
+			s.Read = addTable(s.Read, r.table)
+		}
+	}
+	return s
+}
+
+// addTable appends t to tables if it is not already in tables.
+func addTable(tables []Table, t Table) []Table {
+	for _, t2 := range tables {
+		if t2 == t {
+			return tables
+		}
+	}
+	return append(tables, t)
+}
+
+// A tableRef is a table found in a query.
+type tableRef struct {
+	table Table
+	alias string
+	root  bool // in the table list of the statement, not a subquery
+	write bool
+}
+
+// A target is a table written by UPDATE or DELETE: a table name or alias, or
+// an empty name for unqualified columns.
+type target struct {
+	db   string
+	name string
+}
+
+func (r tableRef) matches(t target) bool {
+	if t.db == "" && r.alias != "" && strings.EqualFold(r.alias, t.name) {
+		return true
+	}
+	return strings.EqualFold(r.table.Name, t.name) && (t.db == "" || strings.EqualFold(r.table.Db, t.db))
+}
+
+// A frame is the state of one level of parentheses while scanning.
+type frame struct {
+	query  bool // SELECT or table list, not function args or a column list
+	list   bool // in table list after FROM, JOIN, etc.
+	expect bool // expecting a table name
+}
+
+// listEnd are keywords that end a table list.
+var listEnd = map[string]bool{
+	"where": true, "group": true, "having": true, "order": true, "limit": true, "union": true,
+	"except": true, "intersect": true, "window": true, "for": true, "into": true, "set": true,
+	"select": true, "values": true, "lock": true,
+}
+
+// describer holds the state of one Describe call.
+type describer struct {
+	tokens []Token // significant tokens
+	refs   []tableRef
+	ctes   map[string]bool // lowercase CTE names
+
+	// idents, if not nil, receives the scope of database and table names by
+	// token offset.
+	idents map[int]IdentScope
+}
+
+// newDescriber returns a describer of the significant tokens of q, without
+// hints and version comments.
+func newDescriber(q string) *describer {
+	d := &describer{ctes: map[string]bool{}}
+	l := Lexer{q: q}
+	for t := l.NextSignificant(); t.Type != TokenEOF; t = l.NextSignificant() {
+		if t.Type == TokenHint || t.Type == TokenVersionComment {
+			continue
+		}
+		d.tokens = append(d.tokens, t)
+	}
+	return d
+}
+
+// tok returns the token at i, or a TokenEOF token if i is out of range.
+func (d *describer) tok(i int) Token {
+	if i >= 0 && i < len(d.tokens) {
+		return d.tokens[i]
+	}
+	return Token{Type: TokenEOF}
+}
+
+// is returns true if the token at i is one of the words.
+func (d *describer) is(i int, words ...string) bool {
+	t := d.tok(i)
+	for _, w := range words {
+		if t.Is(w) {
+			return true
+		}
+	}
+	return false
+}
+
+// isName returns true if the token at i can be a table name.
+func (d *describer) isName(i int) bool {
+	t := d.tok(i)
+	return t.Type == TokenIdent || t.Type == TokenQuotedIdent
+}
+
+// statement describes the statement that begins at i and returns its type.
+func (d *describer) statement(i int) StatementType {
+	for d.tok(i).Text == "(" {
+		i++
+	}
+	all := frame{query: true}
+	switch t := d.tok(i); {
+	case t.Is("with"):
+		main := d.cteNames(i)
+		d.scan(i, main, all)
+		return d.statement(main)
+	case t.Is("select"), t.Is("table"), t.Is("values"):
+		d.scan(i, len(d.tokens), all)
+		return StatementSelect
+	case t.Is("insert"), t.Is("replace"):
+		d.insert(i)
+		if t.Is("replace") {
+			return StatementReplace
+		}
+		return StatementInsert
+	case t.Is("update"):
+		d.update(i)
+		return StatementUpdate
+	case t.Is("delete"):
+		d.delete(i)
+		return StatementDelete
+	case t.Is("create"), t.Is("alter"), t.Is("drop"), t.Is("rename"), t.Is("truncate"):
+		d.ddl(i)
+		return StatementDDL
+	case t.Is("load"):
+		d.load(i)
+		return StatementLoad
+	case t.Is("set"):
+		d.scan(i, len(d.tokens), all)
+		return StatementSet
+	case t.Is("call"):
+		d.scan(i, len(d.tokens), all)
+		return StatementCall
+	case t.Is("show"):
+		return StatementShow
+	case t.Is("use"):
+		return StatementUse
+	case t.Is("explain"), t.Is("describe"), t.Is("desc"):
+		d.explain(i)
+		return StatementExplain
+	case t.Is("begin"), t.Is("commit"), t.Is("rollback"), t.Is("savepoint"), t.Is("release"), t.Is("xa"),
+		t.Is("start") && d.is(i+1, "transaction"):
+		return StatementTransaction
+	case t.Is("flush"), t.Is("kill"), t.Is("reset"), t.Is("purge"), t.Is("grant"), t.Is("revoke"),
+		t.Is("analyze"), t.Is("optimize"), t.Is("check"), t.Is("repair"), t.Is("checksum"),
+		t.Is("install"), t.Is("uninstall"), t.Is("shutdown"), t.Is("restart"), t.Is("change"),
+		t.Is("start"), t.Is("stop"), t.Is("lock"), t.Is("unlock"), t.Is("binlog"), t.Is("cache"):
+		return StatementAdmin
+	}
+	return StatementOther
+}
+
+// scan finds the tables referenced in tokens [i, end), starting in the given
+// frame, and returns the index where it stopped: end, or a ";" at depth 0.
+func (d *describer) scan(i, end int, root frame) int {
+	stack := []frame{root}
+	for ; i < end && i < len(d.tokens); i++ {
+		t := d.tokens[i]
+		f := &stack[len(stack)-1]
+		depth0 := len(stack) == 1
+
+		switch {
+		case t.Text == "(":
+			nf := frame{query: d.is(i+1, "select", "with", "table", "values")}
+			if f.list && f.expect && !nf.query {
+				// FROM (t1 JOIN t2)
+				nf = frame{query: true, list: true, expect: true}
+			}
+			f.expect = false
+			stack = append(stack, nf)
+			continue
+		case t.Text == ")":
+			if !depth0 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		case t.Text == ";" && depth0:
+			return i
+		}
+
+		if f.list && f.expect {
+			f.expect = false
+			if d.isName(i) {
+				i = d.tableRef(i, depth0)
+				continue
+			}
+			if t.Is("lateral") {
+				f.expect = true
+				continue
+			}
+		}
+		if !f.query {
+			continue
+		}
+		switch {
+		case t.Is("from"), t.Is("join"), t.Is("straight_join"), t.Is("table"):
+			f.list, f.expect = true, true
+		case t.Is("using") && d.tok(i+1).Text != "(":
+			// DELETE FROM t1 USING t1 JOIN t2
+			f.list, f.expect = true, true
+		case t.Is("with"):
+			d.cteNames(i)
+		case t.Text == "," && f.list:
+			f.expect = true
+		case t.Type == TokenKeyword && listEnd[strings.ToLower(t.Text)]:
+			f.list = false
+		}
+	}
+	return i
+}
+
+// tableName returns the table name at i, like t or db.t, and the index of its
+// last token.
+func (d *describer) tableName(i int) (Table, int) {
+	t := Table{Name: unquoteIdent(d.tok(i).Text)}
+	if d.tok(i+1).Text == "." && (d.isName(i+2) || d.tok(i+2).Type == TokenKeyword) {
+		t.Db = t.Name
+		t.Name = unquoteIdent(d.tok(i + 2).Text)
+		if d.idents != nil {
+			d.idents[d.tok(i).Start] = IdentDatabase
+		}
+		i += 2
+	}
+	if d.idents != nil {
+		d.idents[d.tok(i).Start] = IdentTable
+	}
+	return t, i
+}
+
+// tableRef adds the table name and optional alias at i, and returns the index
+// of its last token.
+func (d *describer) tableRef(i int, root bool) int {
+	r := tableRef{root: root}
+	r.table, i = d.tableName(i)
+	if d.is(i+1, "as") {
+		i++
+	}
+	if d.isName(i + 1) {
+		r.alias = unquoteIdent(d.tok(i + 1).Text)
+		i++
+	}
+	d.refs = append(d.refs, r)
+	return i
+}
+
+// writeRef adds the table name at i as written, and returns the index after it.
+func (d *describer) writeRef(i int) int {
+	if !d.isName(i) {
+		return i
+	}
+	r := tableRef{root: true, write: true}
+	r.table, i = d.tableName(i)
+	d.refs = append(d.refs, r)
+	return i + 1
+}
+
+// cteNames saves the names of the common table expressions of the WITH clause
+// at i, and returns the index of the statement after the clause.
+func (d *describer) cteNames(i int) int {
+	i++
+	if d.is(i, "recursive") {
+		i++
+	}
+	for d.isName(i) {
+		d.ctes[strings.ToLower(unquoteIdent(d.tok(i).Text))] = true
+		i++
+		if d.tok(i).Text == "(" { // column list
+			i = d.closeParen(i) + 1
+		}
+		if !d.is(i, "as") || d.tok(i+1).Text != "(" {
+			break
+		}
+		i = d.closeParen(i+1) + 1
+		if d.tok(i).Text != "," {
+			break
+		}
+		i++
+	}
+	return i
+}
+
+// closeParen returns the index of the ")" that matches the "(" at i, or the
+// index of the last token if there is none.
+func (d *describer) closeParen(i int) int {
+	depth := 0
+	for ; i < len(d.tokens); i++ {
+		switch d.tokens[i].Text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(d.tokens) - 1
+}
+
+// insert describes INSERT and REPLACE at i.
+func (d *describer) insert(i int) {
+	i++
+	for d.is(i, "low_priority", "delayed", "high_priority", "ignore", "into") {
+		i++
+	}
+	i = d.writeRef(i)
+	d.scan(i, len(d.tokens), frame{query: true})
+}
+
+// update describes UPDATE at i.
+func (d *describer) update(i int) {
+	i++
+	for d.is(i, "low_priority", "ignore") {
+		i++
+	}
+	mark := len(d.refs)
+	end := d.scan(i, len(d.tokens), frame{query: true, list: true, expect: true})
+
+	// Targets are the columns assigned in SET: SET t1.a = 1, b = 2.
+	var targets []target
+	depth := 0
+	set := false
+	var parts []string
+	for ; i < end; i++ {
+		t := d.tokens[i]
+		switch t.Text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+		if depth > 0 {
+			continue
+		}
+		switch {
+		case !set:
+			set = t.Is("set")
+		case t.Is("where"), t.Is("order"), t.Is("limit"):
+			i = end
+		case t.Text == "=" && parts != nil:
+			targets = append(targets, columnTarget(parts))
+			parts = nil
+		case parts != nil && (d.isName(i) || t.Type == TokenKeyword):
+			parts = append(parts, unquoteIdent(t.Text))
+		}
+		if t.Is("set") || t.Text == "," {
+			parts = []string{}
+		}
+	}
+	d.markWrites(mark, targets)
+}
+
+// columnTarget returns the table of a column like db.t.col, t.col, or col.
+func columnTarget(parts []string) target {
+	switch len(parts) {
+	case 0, 1:
+		return target{}
+	case 2:
+		return target{name: parts[0]}
+	}
+	return target{db: parts[len(parts)-3], name: parts[len(parts)-2]}
+}
+
+// delete describes DELETE at i.
+func (d *describer) delete(i int) {
+	i++
+	for d.is(i, "low_priority", "quick", "ignore") {
+		i++
+	}
+	from := d.is(i, "from")
+	if from {
+		i++
+	}
+
+	// DELETE t1, t2 FROM ... or DELETE FROM t1, t2 USING ...
+	var targets []target
+	j := i
+	for d.isName(j) {
+		var parts []string
+		for ; d.isName(j) || d.tok(j).Text == "." || d.tok(j).Text == "*"; j++ {
+			if d.isName(j) {
+				parts = append(parts, unquoteIdent(d.tok(j).Text))
+			}
+		}
+		if len(parts) > 1 {
+			targets = append(targets, target{db: parts[len(parts)-2], name: parts[len(parts)-1]})
+		} else if len(parts) == 1 {
+			targets = append(targets, target{name: parts[0]})
+		}
+		if d.tok(j).Text != "," {
+			break
+		}
+		j++
+	}
+
+	mark := len(d.refs)
+	switch {
+	case !from && d.is(j, "from"), from && d.is(j, "using"):
+		d.scan(j, len(d.tokens), frame{query: true})
+	default:
+		// Single-table DELETE FROM t WHERE ...
+		d.scan(i, len(d.tokens), frame{query: true, list: true, expect: true})
+		targets = []target{{}}
+	}
+	d.markWrites(mark, targets)
+}
+
+// markWrites marks the table refs since mark in the statement table list that
+// match a target as written. If a target matches no table, like an unqualified
+// column, all tables in the list are written.
+func (d *describer) markWrites(mark int, targets []target) {
+	for _, t := range targets {
+		matched := false
+		for j := mark; j < len(d.refs); j++ {
+			if d.refs[j].root && t.name != "" && d.refs[j].matches(t) {
+				d.refs[j].write = true
+				matched = true
+			}
+		}
+		if !matched {
+			for j := mark; j < len(d.refs); j++ {
+				if d.refs[j].root {
+					d.refs[j].write = true
+				}
+			}
+		}
+	}
+}
+
+// ddl describes CREATE, ALTER, DROP, RENAME, and TRUNCATE at i.
+func (d *describer) ddl(i int) {
+	create := d.is(i, "create")
+	switch {
+	case d.is(i, "truncate"):
+		i++
+		if d.is(i, "table") {
+			i++
+		}
+		d.writeRef(i)
+		return
+	case d.is(i, "rename"):
+		// RENAME TABLE a TO b, c TO d
+		i++
+		if !d.is(i, "table", "tables") {
+			return
+		}
+		for i++; d.isName(i); i++ {
+			i = d.writeRef(i)
+			if !d.is(i, "to") && d.tok(i).Text != "," {
+				return
+			}
+		}
+		return
+	}
+
+	// Skip modifiers like OR REPLACE, TEMPORARY, DEFINER = user, UNIQUE, etc.
+	for i++; i < len(d.tokens); i++ {
+		if d.is(i, "table", "tables", "view", "index") {
+			break
+		}
+		if d.is(i, "database", "schema", "procedure", "function", "trigger", "event", "user", "role",
+			"tablespace", "server", "logfile", "resource", "spatial", "instance", "undo") {
+			return
+		}
+	}
+	if d.is(i, "index") {
+		// CREATE INDEX i ON t, DROP INDEX i ON t
+		for ; i < len(d.tokens) && !d.is(i, "on"); i++ {
+		}
+		d.writeRef(i + 1)
+		return
+	}
+
+	i++
+	if d.is(i, "if") {
+		// IF [NOT] EXISTS
+		for ; i < len(d.tokens) && !d.is(i, "exists"); i++ {
+		}
+		i++
+	}
+	for d.isName(i) {
+		i = d.writeRef(i)
+		if create || d.tok(i).Text != "," {
+			break
+		}
+		i++
+	}
+	if !create {
+		return
+	}
+	if d.tok(i).Text == "(" && d.is(i+1, "like") {
+		i++
+	}
+	if d.is(i, "like") {
+		// CREATE TABLE t LIKE t2
+		r := tableRef{root: true}
+		r.table, _ = d.tableName(i + 1)
+		d.refs = append(d.refs, r)
+		return
+	}
+	// CREATE TABLE t SELECT ..., CREATE VIEW v AS SELECT ...
+	d.scan(i, len(d.tokens), frame{query: true})
+}
+
+// load describes LOAD DATA and LOAD XML at i.
+func (d *describer) load(i int) {
+	for ; i < len(d.tokens); i++ {
+		if d.is(i, "into") && d.is(i+1, "table") {
+			d.writeRef(i + 2)
+			return
+		}
+	}
+}
+
+// explain describes EXPLAIN, DESCRIBE, and DESC at i. Explained statements
+// do not write tables.
+func (d *describer) explain(i int) {
+	i++
+	for {
+		if d.is(i, "analyze", "extended", "partitions") {
+			i++
+		} else if d.is(i, "format") && d.tok(i+1).Text == "=" {
+			i += 3
+		} else {
+			break
+		}
+	}
+	if d.isName(i) {
+		// DESCRIBE t
+		r := tableRef{root: true}
+		r.table, _ = d.tableName(i)
+		d.refs = append(d.refs, r)
+		return
+	}
+	mark := len(d.refs)
+	d.statement(i)
+	for j := mark; j < len(d.refs); j++ {
+		d.refs[j].write = false
+	}
+}
+
+// unquoteIdent returns the identifier without backticks.
+func unquoteIdent(s string) string {
+	if len(s) == 0 || s[0] != '`' {
+		return s
+	}
+	s = s[1:]
+	if strings.HasSuffix(s, "`") {
+		s = s[:len(s)-1]
+	}
+	return strings.ReplaceAll(s, "``", "`")
+}
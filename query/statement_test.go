@@ -0,0 +1,207 @@
+/*
+Copyright (c) 2019, Percona LLC.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package query_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/percona/go-mysql/query"
+)
+
+func TestDescribe(t *testing.T) {
+	type testCase struct {
+		name     string
+		query    string
+		expected query.Statement
+	}
+	tbl := func(names ...string) []query.Table {
+		var tables []query.Table
+		for i := 0; i < len(names); i += 2 {
+			tables = append(tables, query.Table{Db: names[i], Name: names[i+1]})
+		}
+		return tables
+	}
+	testCases := []testCase{
+		{
+			name:     "select with joins and subquery",
+			query:    "SELECT a FROM db1.t1 AS x JOIN `t``2` y ON x.a=y.a LEFT JOIN t3 USING (a), t4 WHERE b IN (SELECT c FROM t5) AND EXTRACT(YEAR FROM d) = 1",
+			expected: query.Statement{Type: query.StatementSelect, Read: tbl("db1", "t1", "", "t`2", "", "t3", "", "t4", "", "t5")},
+		},
+		{
+			name:     "derived table",
+			query:    "select * from (select a from t1) x, t2 where x.a = t2.a",
+			expected: query.Statement{Type: query.StatementSelect, Read: tbl("", "t1", "", "t2")},
+		},
+		{
+			name:     "union",
+			query:    "(select a from t1) union (select a from t2) union select a from t1",
+			expected: query.Statement{Type: query.StatementSelect, Read: tbl("", "t1", "", "t2")},
+		},
+		{
+			name:     "no tables",
+			query:    "select 1",
+			expected: query.Statement{Type: query.StatementSelect},
+		},
+		{
+			name:     "insert select",
+			query:    "insert ignore into t1 (a,b) select a, b from t2 join t3 on t2.a = t3.a on duplicate key update a=values(a)",
+			expected: query.Statement{Type: query.StatementInsert, Read: tbl("", "t2", "", "t3"), Write: tbl("", "t1")},
+		},
+		{
+			name:     "replace",
+			query:    "REPLACE INTO db.t VALUES (1)",
+			expected: query.Statement{Type: query.StatementReplace, Write: tbl("db", "t")},
+		},
+		{
+			name:     "multi-table update",
+			query:    "update t1 join t2 b on t1.id=b.id set t1.a = b.b where b.c in (select c from t3)",
+			expected: query.Statement{Type: query.StatementUpdate, Read: tbl("", "t2", "", "t3"), Write: tbl("", "t1")},
+		},
+		{
+			name:     "multi-table update with alias",
+			query:    "update t1 a, t2 b set b.x = a.x",
+			expected: query.Statement{Type: query.StatementUpdate, Read: tbl("", "t1"), Write: tbl("", "t2")},
+		},
+		{
+			name:     "multi-table update with unqualified column",
+			query:    "update t1, t2 set a = 1",
+			expected: query.Statement{Type: query.StatementUpdate, Write: tbl("", "t1", "", "t2")},
+		},
+		{
+			name:     "delete",
+			query:    "delete from t1 where id in (select id from t2)",
+			expected: query.Statement{Type: query.StatementDelete, Read: tbl("", "t2"), Write: tbl("", "t1")},
+		},
+		{
+			name:     "multi-table delete",
+			query:    "delete a, b from t1 a join t2 b on a.id=b.id join t3 on t3.id=a.id",
+			expected: query.Statement{Type: query.StatementDelete, Read: tbl("", "t3"), Write: tbl("", "t1", "", "t2")},
+		},
+		{
+			name:     "multi-table delete using",
+			query:    "delete from t1, db.t2 using t1 join db.t2 join t3",
+			expected: query.Statement{Type: query.StatementDelete, Read: tbl("", "t3"), Write: tbl("", "t1", "db", "t2")},
+		},
+		{
+			name:     "cte",
+			query:    "with recursive cte as (select a from t1), c2 (x) as (select 1 from t2) select * from cte join c2 join t3",
+			expected: query.Statement{Type: query.StatementSelect, Read: tbl("", "t1", "", "t2", "", "t3")},
+		},
+		{
+			name:     "cte update",
+			query:    "with cte as (select a from t1) update t2 join cte using (a) set t2.b = 1",
+			expected: query.Statement{Type: query.StatementUpdate, Read: tbl("", "t1"), Write: tbl("", "t2")},
+		},
+		{
+			name:     "create table select",
+			query:    "create table if not exists db.t (a int) select * from s",
+			expected: query.Statement{Type: query.StatementDDL, Read: tbl("", "s"), Write: tbl("db", "t")},
+		},
+		{
+			name:     "create view",
+			query:    "create or replace algorithm=merge definer=`u`@`h` sql security definer view v as select * from t",
+			expected: query.Statement{Type: query.StatementDDL, Read: tbl("", "t"), Write: tbl("", "v")},
+		},
+		{
+			name:     "create index",
+			query:    "create unique index i on t (a)",
+			expected: query.Statement{Type: query.StatementDDL, Write: tbl("", "t")},
+		},
+		{
+			name:     "drop tables",
+			query:    "DROP TABLE IF EXISTS a, b",
+			expected: query.Statement{Type: query.StatementDDL, Write: tbl("", "a", "", "b")},
+		},
+		{
+			name:     "rename tables",
+			query:    "rename table a to b, c to d",
+			expected: query.Statement{Type: query.StatementDDL, Write: tbl("", "a", "", "b", "", "c", "", "d")},
+		},
+		{
+			name:     "drop database",
+			query:    "drop database d",
+			expected: query.Statement{Type: query.StatementDDL},
+		},
+		{
+			name:     "load data",
+			query:    "load data local infile 'x' replace into table t",
+			expected: query.Statement{Type: query.StatementLoad, Write: tbl("", "t")},
+		},
+		{
+			name:     "explain",
+			query:    "explain format=json update t set a=1",
+			expected: query.Statement{Type: query.StatementExplain, Read: tbl("", "t")},
+		},
+		{
+			name:     "set",
+			query:    "set @a = (select max(id) from t)",
+			expected: query.Statement{Type: query.StatementSet, Read: tbl("", "t")},
+		},
+		{
+			name:     "call",
+			query:    "CALL p(1)",
+			expected: query.Statement{Type: query.StatementCall},
+		},
+		{
+			name:     "show",
+			query:    "show tables from db",
+			expected: query.Statement{Type: query.StatementShow},
+		},
+		{
+			name:     "transaction",
+			query:    "START TRANSACTION",
+			expected: query.Statement{Type: query.StatementTransaction},
+		},
+		{
+			name:     "administrator command",
+			query:    "administrator command: Ping",
+			expected: query.Statement{Type: query.StatementAdmin},
+		},
+		{
+			name:     "only first statement",
+			query:    "select * from t1; select * from t2",
+			expected: query.Statement{Type: query.StatementSelect, Read: tbl("", "t1")},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, query.Describe(tc.query))
+		})
+	}
+}
+
+func TestStatementTables(t *testing.T) {
+	s := query.Describe("insert into t1 select * from t2 join t1")
+	assert.Equal(t, []query.Table{{Name: "t2"}, {Name: "t1"}}, s.Tables())
+	assert.Equal(t, "db.t", query.Table{Db: "db", Name: "t"}.String())
+}